@@ -0,0 +1,243 @@
+package acl
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/protocal/auth"
+	"github.com/service-exposer/exposer/protocal/route"
+)
+
+const (
+	DefaultIdentity = auth.DefaultIdentity
+
+	// Any matches every route type in Rule.Actions.
+	Any route.Type = "*"
+)
+
+var (
+	ErrDuplicateIdentity = errors.New("duplicate identity")
+	ErrDuplicateKey      = errors.New("duplicate key")
+	ErrEmptyKey          = errors.New("empty key")
+)
+
+// Rule grants the identity authenticated by Key the route types in
+// Actions. Services and Forwards are path.Match patterns for the service
+// names the identity may expose or link and the addresses it may forward to.
+// Hostnames are the patterns of the custom HTTP hostnames its exposed
+// services may claim and Listens those of the addresses its remote
+// forwards may listen on, none when empty.
+type Rule struct {
+	Identity  string
+	Key       string
+	Actions   []route.Type
+	Services  []string
+	Forwards  []string
+	Hostnames []string `json:",omitempty"`
+	Listens   []string `json:",omitempty"`
+}
+
+// Policy maps keys to identities and identities to their rule.
+// It implements route.Authorizer.
+type Policy struct {
+	mu    *sync.RWMutex
+	rules map[string]*Rule
+}
+
+func NewPolicy(rules []Rule) (*Policy, error) {
+	policy := &Policy{
+		mu:    new(sync.RWMutex),
+		rules: nil,
+	}
+
+	err := policy.Replace(rules)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return policy, nil
+}
+
+// AllowAll returns a policy with a single identity, DefaultIdentity,
+// which may do anything after presenting key.
+func AllowAll(key string) *Policy {
+	rule := AllowAllRule(key)
+	return &Policy{
+		mu: new(sync.RWMutex),
+		rules: map[string]*Rule{
+			DefaultIdentity: &rule,
+		},
+	}
+}
+
+// AllowAllRule is the rule of AllowAll.
+func AllowAllRule(key string) Rule {
+	return Rule{
+		Identity:  DefaultIdentity,
+		Key:       key,
+		Actions:   []route.Type{Any},
+		Services:  []string{"*"},
+		Forwards:  []string{"*"},
+		Hostnames: []string{"*"},
+		Listens:   []string{"*"},
+	}
+}
+
+// Load reads a JSON list of rules from filename.
+func Load(filename string) (*Policy, error) {
+	rules, err := LoadRules(filename)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	policy, err := NewPolicy(rules)
+	return policy, errors.Annotatef(err, "load %s", filename)
+}
+
+// LoadRules reads a JSON list of rules from filename without checking them.
+func LoadRules(filename string) ([]Rule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+
+	var rules []Rule
+	err = json.NewDecoder(f).Decode(&rules)
+	if err != nil {
+		return nil, errors.Annotatef(err, "decode %s", filename)
+	}
+	return rules, nil
+}
+
+// Replace validates rules and swaps them in as a whole.
+func (policy *Policy) Replace(rules []Rule) error {
+	m := make(map[string]*Rule, len(rules))
+	keys := make(map[string]bool, len(rules))
+	for i := range rules {
+		rule := rules[i]
+		if rule.Identity == "" {
+			rule.Identity = DefaultIdentity
+		}
+
+		if _, exist := m[rule.Identity]; exist {
+			return errors.Annotatef(ErrDuplicateIdentity, "%q", rule.Identity)
+		}
+		if rule.Key == "" {
+			return errors.Annotatef(ErrEmptyKey, "identity %q", rule.Identity)
+		}
+		if keys[rule.Key] {
+			return errors.Annotatef(ErrDuplicateKey, "identity %q", rule.Identity)
+		}
+
+		for _, patterns := range [][]string{rule.Services, rule.Forwards, rule.Hostnames, rule.Listens} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return errors.Annotatef(err, "identity %q pattern %q", rule.Identity, pattern)
+				}
+			}
+		}
+
+		m[rule.Identity] = &rule
+		keys[rule.Key] = true
+	}
+
+	policy.mu.Lock()
+	defer policy.mu.Unlock()
+
+	policy.rules = m
+	return nil
+}
+
+func (policy *Policy) rule(identity string) *Rule {
+	policy.mu.RLock()
+	defer policy.mu.RUnlock()
+
+	return policy.rules[identity]
+}
+
+// Authenticate returns the identity key belongs to.
+func (policy *Policy) Authenticate(key string) (identity string, ok bool) {
+	policy.mu.RLock()
+	defer policy.mu.RUnlock()
+
+	for _, rule := range policy.rules {
+		if subtle.ConstantTimeCompare([]byte(rule.Key), []byte(key)) == 1 {
+			identity, ok = rule.Identity, true
+		}
+	}
+
+	return identity, ok
+}
+
+// Key returns the key of identity.
+func (policy *Policy) Key(identity string) (key string, ok bool) {
+	rule := policy.rule(identity)
+	if rule == nil {
+		return "", false
+	}
+
+	return rule.Key, true
+}
+
+func (rule *Rule) allow(typ route.Type) bool {
+	for _, action := range rule.Actions {
+		if action == Any || action == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *Policy) AllowRoute(identity string, typ route.Type) bool {
+	rule := policy.rule(identity)
+	return rule != nil && rule.allow(typ)
+}
+
+func (policy *Policy) AllowTarget(identity string, typ route.Type, target string) bool {
+	rule := policy.rule(identity)
+	if rule == nil {
+		return false
+	}
+	if typ == route.Hostname {
+		// claimed by exposing
+		return rule.allow(route.Expose) && rule.matchHostname(target)
+	}
+	if !rule.allow(typ) {
+		return false
+	}
+
+	var patterns []string
+	switch typ {
+	case route.Expose, route.Link:
+		patterns = rule.Services
+	case route.Forward:
+		patterns = rule.Forwards
+	case route.RemoteForward:
+		patterns = rule.Listens
+	default:
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *Rule) matchHostname(hostname string) bool {
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	for _, pattern := range rule.Hostnames {
+		if ok, _ := path.Match(strings.ToLower(pattern), hostname); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package acl
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/protocal/route"
+)
+
+func TestPolicy(t *testing.T) {
+	policy, err := NewPolicy([]Rule{
+		{
+			Identity:  "alice",
+			Key:       "alice-key",
+			Actions:   []route.Type{route.Expose, route.KeepAlive},
+			Services:  []string{"alice-*"},
+			Hostnames: []string{"*.alice.test"},
+		},
+		{
+			Identity: "bob",
+			Key:      "bob-key",
+			Actions:  []route.Type{Any},
+			Services: []string{"*"},
+			Forwards: []string{"10.0.0.*:22"},
+			Listens:  []string{"127.0.0.1:*"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, ok := policy.Authenticate("alice-key")
+	if !ok || identity != "alice" {
+		t.Fatal(identity, ok, "want", "alice", true)
+	}
+	_, ok = policy.Authenticate("wrong-key")
+	if ok {
+		t.Fatal("expect !ok")
+	}
+
+	key, ok := policy.Key("bob")
+	if !ok || key != "bob-key" {
+		t.Fatal(key, ok, "want", "bob-key", true)
+	}
+	_, ok = policy.Key("mallory")
+	if ok {
+		t.Fatal("expect !ok")
+	}
+
+	cases := []struct {
+		identity string
+		typ      route.Type
+		target   string
+		allow    bool
+	}{
+		{"alice", route.Expose, "alice-web", true},
+		{"alice", route.Expose, "web", false},
+		{"alice", route.Link, "alice-web", false},
+		{"alice", route.KeepAlive, "", true},
+		{"alice", route.Forward, "10.0.0.1:22", false},
+		{"bob", route.Link, "alice-web", true},
+		{"bob", route.Forward, "10.0.0.1:22", true},
+		{"bob", route.Forward, "10.0.0.1:80", false},
+		{"mallory", route.KeepAlive, "", false},
+		{"alice", route.Hostname, "www.alice.test", true},
+		{"alice", route.Hostname, "WWW.Alice.test.", true},
+		{"alice", route.Hostname, "www.bob.test", false},
+		{"bob", route.Hostname, "www.bob.test", false},
+		{"bob", route.RemoteForward, "127.0.0.1:2222", true},
+		{"bob", route.RemoteForward, "0.0.0.0:2222", false},
+		{"alice", route.RemoteForward, "127.0.0.1:2222", false},
+	}
+	for _, c := range cases {
+		if allow := policy.AllowTarget(c.identity, c.typ, c.target); allow != c.allow {
+			t.Fatal(c, "got", allow)
+		}
+	}
+
+	if policy.AllowRoute("alice", route.Forward) {
+		t.Fatal("expect alice cannot forward")
+	}
+}
+
+func TestPolicy_Replace(t *testing.T) {
+	_, err := NewPolicy([]Rule{
+		{Identity: "a", Key: "k"},
+		{Identity: "a", Key: "k2"},
+	})
+	if errors.Cause(err) != ErrDuplicateIdentity {
+		t.Fatal(errors.Cause(err), "want", ErrDuplicateIdentity)
+	}
+
+	_, err = NewPolicy([]Rule{
+		{Identity: "a", Key: "k"},
+		{Identity: "b", Key: "k"},
+	})
+	if errors.Cause(err) != ErrDuplicateKey {
+		t.Fatal(errors.Cause(err), "want", ErrDuplicateKey)
+	}
+
+	_, err = NewPolicy([]Rule{
+		{Identity: "a"},
+	})
+	if errors.Cause(err) != ErrEmptyKey {
+		t.Fatal(errors.Cause(err), "want", ErrEmptyKey)
+	}
+
+	_, err = NewPolicy([]Rule{
+		{Identity: "a", Key: "k", Services: []string{"["}},
+	})
+	if err == nil {
+		t.Fatal("expect err")
+	}
+
+	policy := AllowAll("k")
+	identity, ok := policy.Authenticate("k")
+	if !ok || identity != DefaultIdentity {
+		t.Fatal(identity, ok, "want", DefaultIdentity, true)
+	}
+
+	err = policy.Replace([]Rule{
+		{Identity: "a", Key: "k2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok = policy.Authenticate("k")
+	if ok {
+		t.Fatal("expect !ok")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	f, err := ioutil.TempFile("", "acl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(`[{"identity":"ci","key":"secret","actions":["link"],"services":["db"]}]`)
+	f.Close()
+
+	policy, err := Load(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !policy.AllowTarget("ci", route.Link, "db") {
+		t.Fatal("expect allow")
+	}
+}
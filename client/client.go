@@ -0,0 +1,426 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/listener"
+	"github.com/service-exposer/exposer/listener/utils"
+	"github.com/service-exposer/exposer/protocal"
+	"github.com/service-exposer/exposer/protocal/auth"
+	"github.com/service-exposer/exposer/protocal/expose"
+	"github.com/service-exposer/exposer/protocal/forward"
+	"github.com/service-exposer/exposer/protocal/keepalive"
+	"github.com/service-exposer/exposer/protocal/link"
+	"github.com/service-exposer/exposer/protocal/remoteforward"
+	"github.com/service-exposer/exposer/protocal/route"
+	"github.com/service-exposer/exposer/service"
+)
+
+var (
+	ErrClosed = errors.New("client closed")
+)
+
+// Client is an authenticated session with a daemon. Several routes can
+// be opened on it, each of them fails alone.
+type Client struct {
+	conn  net.Conn
+	proto *protocal.Protocal
+
+	mu        *sync.RWMutex
+	closed    bool
+	closing   chan struct{}
+	closeOnce *sync.Once
+	routes    chan auth.NextRoute
+
+	draining  chan struct{}
+	drainOnce *sync.Once
+
+	healthMu *sync.Mutex
+	health   keepalive.Status
+
+	done chan struct{}
+	err  error
+}
+
+// Dial connects to the daemon at url, a ws:// or wss:// URL, and
+// authenticates with key as the default identity.
+func Dial(ctx context.Context, url, key string) (*Client, error) {
+	return DialWithCredential(ctx, url, auth.Credential{
+		Key: key,
+	})
+}
+
+// DialWithCredential is like Dial but authenticates with cred.
+// ctx bounds connecting and authentication.
+func DialWithCredential(ctx context.Context, url string, cred auth.Credential) (*Client, error) {
+	return DialWithConfig(ctx, url, &Config{
+		Credential: cred,
+	})
+}
+
+// Config is the configuration of a session.
+type Config struct {
+	Credential auth.Credential
+	// KeepAlive is the configuration of the keepalive pings, its OnDrain
+	// and OnHealth are called besides those of the Client.
+	KeepAlive keepalive.Config
+}
+
+// DialWithConfig is like DialWithCredential with the full configuration.
+func DialWithConfig(ctx context.Context, url string, config *Config) (*Client, error) {
+	conn, err := utils.DialWebsocketContext(ctx, url)
+	if err != nil {
+		return nil, errors.Annotatef(err, "connect %s", url)
+	}
+
+	c := &Client{
+		conn:  conn,
+		proto: protocal.NewProtocal(conn),
+
+		mu:        new(sync.RWMutex),
+		closed:    false,
+		closing:   make(chan struct{}),
+		closeOnce: new(sync.Once),
+		routes:    make(chan auth.NextRoute),
+
+		draining:  make(chan struct{}),
+		drainOnce: new(sync.Once),
+
+		healthMu: new(sync.Mutex),
+		health:   keepalive.Status{},
+
+		done: make(chan struct{}),
+		err:  nil,
+	}
+
+	req, handlefn := auth.ClientSideWithCredential(config.Credential, c.routes)
+	c.proto.On = handlefn
+
+	go func() {
+		c.err = c.proto.Wait()
+		close(c.done)
+		c.Close()
+	}()
+	go c.proto.Request(auth.CMD_AUTH, req)
+
+	// routes are taken only after authentication succeeded
+	err = c.send(ctx, auth.NextRoute{
+		Req: route.RouteReq{
+			Type: route.KeepAlive,
+		},
+		HandleFunc: keepalive.ClientSideWithConfig(c.keepAliveConfig(config.KeepAlive)),
+		Cmd:        keepalive.CMD_PING,
+	})
+	if err != nil {
+		c.Close()
+		return nil, errors.Annotate(err, "auth")
+	}
+
+	return c, nil
+}
+
+// keepAliveConfig is config with the callbacks of c chained in.
+func (c *Client) keepAliveConfig(config keepalive.Config) *keepalive.Config {
+	onDrain, onHealth := config.OnDrain, config.OnHealth
+	config.OnDrain = func() {
+		c.drainOnce.Do(func() {
+			close(c.draining)
+		})
+		if onDrain != nil {
+			onDrain()
+		}
+	}
+	config.OnHealth = func(status keepalive.Status) {
+		c.healthMu.Lock()
+		c.health = status
+		c.healthMu.Unlock()
+		if onHealth != nil {
+			onHealth(status)
+		}
+	}
+	return &config
+}
+
+func (c *Client) send(ctx context.Context, nr auth.NextRoute) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return errors.Trace(ErrClosed)
+	}
+
+	select {
+	case c.routes <- nr:
+		return nil
+	case <-c.done:
+		return errors.Trace(c.err)
+	case <-c.closing:
+		return errors.Trace(ErrClosed)
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	}
+}
+
+// Done is closed when the session is gone.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Draining is closed when the daemon is shutting down. Open streams go
+// on until the daemon closes the session.
+func (c *Client) Draining() <-chan struct{} {
+	return c.draining
+}
+
+// Health is the last keepalive measurement of the session, its Health
+// is empty before the first one.
+func (c *Client) Health() keepalive.Status {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+
+	return c.health
+}
+
+// Wait blocks until the session is gone and returns why.
+func (c *Client) Wait() error {
+	<-c.done
+	return errors.Trace(c.err)
+}
+
+// Close ends the session and every route on it.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closing) // wakes up send
+
+		c.mu.Lock()
+		c.closed = true
+		close(c.routes) // ends the route loop of auth.ClientSide
+		c.mu.Unlock()
+	})
+
+	return errors.Trace(c.conn.Close())
+}
+
+// open sets up a detached route and waits until the daemon replied
+// replyCmd to cmd. ln, if not nil, is closed with the route.
+func (c *Client) open(ctx context.Context, typ route.Type, handlefn protocal.HandshakeHandleFunc,
+	cmd, replyCmd string, details interface{}, ln net.Listener) (*Route, error) {
+	var (
+		r = &Route{
+			typ:  typ,
+			ln:   ln,
+			done: make(chan struct{}),
+		}
+		opened = make(chan struct{})
+		ready  = make(chan error, 1)
+	)
+
+	err := c.send(ctx, auth.NextRoute{
+		Req: route.RouteReq{
+			Type: typ,
+		},
+		HandleFunc: func(proto *protocal.Protocal, cmd string, details []byte) error {
+			if cmd == replyCmd {
+				var reply struct {
+					OK  bool
+					Err string
+				}
+				err := json.Unmarshal(details, &reply)
+				if err == nil && !reply.OK {
+					err = errors.New(reply.Err)
+				}
+				select {
+				case ready <- err:
+				default:
+				}
+			}
+
+			return handlefn(proto, cmd, details)
+		},
+		Cmd:      cmd,
+		Details:  details,
+		Detached: true,
+		Opened: func(proto *protocal.Protocal, conn net.Conn) {
+			r.proto, r.conn = proto, conn
+			close(opened)
+		},
+	})
+	if err == nil {
+		select {
+		case <-opened:
+		case <-c.done:
+			err = c.err
+		}
+	}
+	if err != nil {
+		if ln != nil {
+			ln.Close()
+		}
+		return nil, errors.Trace(err)
+	}
+
+	go func() {
+		r.err = r.proto.Wait()
+		if r.ln != nil {
+			r.ln.Close()
+		}
+		close(r.done)
+	}()
+	go func() {
+		// not every route notices a dead session while it waits for
+		// local connections
+		select {
+		case <-c.done:
+			r.Close()
+		case <-r.done:
+		}
+	}()
+
+	select {
+	case err = <-ready:
+	case <-r.done:
+		err = r.err
+		if err == nil {
+			err = errors.Errorf("%s route closed", typ)
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		r.Close()
+		return nil, errors.Annotatef(err, "%s route", typ)
+	}
+
+	return r, nil
+}
+
+// Expose serves the service name through the daemon, dial connects to
+// the service for every incoming connection.
+func (c *Client) Expose(ctx context.Context, name string, attr service.Attribute,
+	dial func() (net.Conn, error)) (*Route, error) {
+	return c.ExposeWithReq(ctx, &expose.ExposeReq{
+		Name: name,
+		Attr: attr,
+	}, dial)
+}
+
+// ExposeWithReq is like Expose with the full expose request, for groups
+// and remote ports. It sets req.Token to the one the daemon replied, so
+// passing req again after a reconnect reclaims the name.
+func (c *Client) ExposeWithReq(ctx context.Context, req *expose.ExposeReq,
+	dial func() (net.Conn, error)) (*Route, error) {
+	var (
+		handlefn = expose.ClientSide(dial)
+		tokens   = make(chan string, 1)
+	)
+	r, err := c.open(ctx, route.Expose, func(proto *protocal.Protocal, cmd string, details []byte) error {
+		if cmd == expose.CMD_EXPOSE_REPLY {
+			var reply expose.Reply
+			if json.Unmarshal(details, &reply) == nil && reply.OK {
+				tokens <- reply.Token
+			}
+		}
+		return handlefn(proto, cmd, details)
+	}, expose.CMD_EXPOSE, expose.CMD_EXPOSE_REPLY, req, nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	select {
+	case token := <-tokens:
+		if token != "" {
+			req.Token = token
+		}
+	case <-r.Done():
+	}
+	return r, nil
+}
+
+// Link connects to the service name, every Tunnel.Dial is a new
+// connection to it.
+func (c *Client) Link(ctx context.Context, name string) (*Tunnel, error) {
+	ln, dial := listener.Pipe()
+	r, err := c.link(ctx, name, ln, ln)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return &Tunnel{
+		Route: r,
+		dial:  dial,
+	}, nil
+}
+
+// LinkWithListener passes every connection accepted by ln to the service name.
+func (c *Client) LinkWithListener(ctx context.Context, name string, ln net.Listener) (*Route, error) {
+	r, err := c.link(ctx, name, ln, nil)
+	return r, errors.Trace(err)
+}
+
+func (c *Client) link(ctx context.Context, name string, ln, own net.Listener) (*Route, error) {
+	r, err := c.open(ctx, route.Link, link.ClientSide(ln),
+		link.CMD_LINK, link.CMD_LINK_REPLY, &link.LinkReq{
+			Name:        name,
+			StreamReply: true,
+		}, own)
+	return r, errors.Trace(err)
+}
+
+// RemoteForward has the daemon listen on address, dial connects to the
+// local target for every connection it accepts. listening, if set, is
+// told the address the daemon listens on.
+func (c *Client) RemoteForward(ctx context.Context, address string,
+	dial func() (net.Conn, error), listening func(address string)) (*Route, error) {
+	r, err := c.open(ctx, route.RemoteForward, remoteforward.ClientSide(dial, listening),
+		remoteforward.CMD_REMOTE_FORWARD, remoteforward.CMD_REMOTE_FORWARD_REPLY, &remoteforward.RemoteForward{
+			Network: "tcp",
+			Address: address,
+		}, nil)
+	return r, errors.Trace(err)
+}
+
+// Forward connects to address on the daemon side, every Tunnel.Dial is
+// a new connection to it.
+func (c *Client) Forward(ctx context.Context, network, address string) (*Tunnel, error) {
+	ln, dial := listener.Pipe()
+	r, err := c.forward(ctx, network, address, ln, ln)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return &Tunnel{
+		Route: r,
+		dial:  dial,
+	}, nil
+}
+
+// ForwardWithListener passes every connection accepted by ln to address
+// on the daemon side.
+func (c *Client) ForwardWithListener(ctx context.Context, network, address string, ln net.Listener) (*Route, error) {
+	r, err := c.forward(ctx, network, address, ln, nil)
+	return r, errors.Trace(err)
+}
+
+// ForwardDynamicWithListener passes every connection accepted by ln to
+// the destination it names, ln yields forward.Target conns such as those
+// of socks5.NewListener or httpconnect.NewListener.
+func (c *Client) ForwardDynamicWithListener(ctx context.Context, ln net.Listener) (*Route, error) {
+	r, err := c.open(ctx, route.Forward, forward.DynamicClientSide(ln),
+		forward.CMD_FORWARD, forward.CMD_FORWARD_REPLY, &forward.Forward{
+			Dynamic: true,
+		}, nil)
+	return r, errors.Trace(err)
+}
+
+func (c *Client) forward(ctx context.Context, network, address string, ln, own net.Listener) (*Route, error) {
+	r, err := c.open(ctx, route.Forward, forward.ClientSide(ln),
+		forward.CMD_FORWARD, forward.CMD_FORWARD_REPLY, &forward.Forward{
+			Network: network,
+			Address: address,
+		}, own)
+	return r, errors.Trace(err)
+}
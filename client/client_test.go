@@ -0,0 +1,377 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/service-exposer/exposer/httpconnect"
+	"github.com/service-exposer/exposer/listener/utils"
+	"github.com/service-exposer/exposer/protocal"
+	"github.com/service-exposer/exposer/protocal/auth"
+	"github.com/service-exposer/exposer/protocal/expose"
+	"github.com/service-exposer/exposer/protocal/route"
+	"github.com/service-exposer/exposer/service"
+	"github.com/service-exposer/exposer/socks5"
+)
+
+func serve(t *testing.T, router *service.Router) string {
+	return serveWithConfig(t, &route.Config{
+		Router: router,
+	})
+}
+
+func serveWithConfig(t *testing.T, config *route.Config) string {
+	ln, err := utils.WebsocketListener("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.On = auth.ServerSideWithConfig(&auth.Config{
+			Route: *config,
+			Key: func(identity string) (string, bool) {
+				return "test", identity == auth.DefaultIdentity
+			},
+		})
+		return proto
+	})
+
+	return "ws://" + ln.Addr().String()
+}
+
+func echo(conn net.Conn) {
+	io.Copy(conn, conn)
+	conn.Close()
+}
+
+func expectEcho(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+
+	conn.Write([]byte("hello"))
+	data := make([]byte, 5)
+	_, err := io.ReadAtLeast(conn, data, len(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatal("expect hello got", string(data))
+	}
+}
+
+func TestClient(t *testing.T) {
+	router := service.NewRouter()
+	url := serve(t, router)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := Dial(ctx, url, "bad")
+	if err == nil {
+		t.Fatal("expect auth failure")
+	}
+
+	c1, err := Dial(ctx, url, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+
+	exposed, err := c1.Expose(ctx, "echo", service.Attribute{}, func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go echo(c1)
+		return c2, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c2, err := Dial(ctx, url, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	tunnel, err := c2.Link(ctx, "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		conn, err := tunnel.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectEcho(t, conn)
+	}
+
+	// a failed route leaves the session and its other routes alone
+	_, err = c2.Expose(ctx, "echo", service.Attribute{}, nil)
+	if err == nil {
+		t.Fatal("expect service name exist")
+	}
+	conn, err := tunnel.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEcho(t, conn)
+
+	exposed.Close()
+	for i := 0; i < 100 && router.Get("echo") != nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if router.Get("echo") != nil {
+		t.Fatal("expect service removed after Route.Close")
+	}
+
+	select {
+	case <-c1.Done():
+		t.Fatal("expect session alive after Route.Close")
+	default:
+	}
+}
+
+func TestClient_exposeReclaim(t *testing.T) {
+	router := service.NewRouter()
+	url := serveWithConfig(t, &route.Config{
+		Router: router,
+		Expose: expose.Config{
+			Grace: time.Minute,
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &expose.ExposeReq{
+		Name: "echo",
+	}
+	dial := func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go echo(c1)
+		return c2, nil
+	}
+
+	c1, err := Dial(ctx, url, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c1.ExposeWithReq(ctx, req, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Token == "" {
+		t.Fatal("expect req.Token set")
+	}
+	c1.Close()
+	for i := 0; i < 100 && !router.Get("echo").Info().Reserved; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c2, err := Dial(ctx, url, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	_, err = c2.Expose(ctx, "echo", service.Attribute{}, dial)
+	if err == nil {
+		t.Fatal("expect service name reserved")
+	}
+	_, err = c2.ExposeWithReq(ctx, req, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := router.Get("echo").Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEcho(t, conn)
+}
+
+func TestClient_forward(t *testing.T) {
+	url := serve(t, service.NewRouter())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go echo(conn)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, url, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tunnel, err := c.Forward(ctx, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := tunnel.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEcho(t, conn)
+
+	c.Close()
+	select {
+	case <-tunnel.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect route gone after Client.Close")
+	}
+	if c.Wait() == nil {
+		t.Fatal("expect session error after Close")
+	}
+}
+
+func TestClient_forwardDynamic(t *testing.T) {
+	url := serve(t, service.NewRouter())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go echo(conn)
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, url, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	socksln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.ForwardDynamicWithListener(ctx, socks5.NewListener(socksln))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connect := func(host string, port string) (net.Conn, byte) {
+		conn, err := net.Dial("tcp", socksln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, _ := strconv.Atoi(port)
+		req := []byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x03, byte(len(host))}
+		req = append(req, host...)
+		req = append(req, byte(p>>8), byte(p))
+		conn.Write(req)
+
+		reply := make([]byte, 12)
+		_, err = io.ReadFull(conn, reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, reply[3]
+	}
+
+	// the hostname is resolved by the daemon
+	conn, rep := connect("localhost", port)
+	if rep != 0x00 {
+		t.Fatal("expect", 0x00, "got", rep)
+	}
+	expectEcho(t, conn)
+
+	ln.Close()
+	conn, rep = connect("127.0.0.1", port)
+	conn.Close()
+	if rep != 0x05 {
+		t.Fatal("expect connection refused", 0x05, "got", rep)
+	}
+}
+
+func TestClient_forwardHTTPProxy(t *testing.T) {
+	router := service.NewRouter()
+	url := serve(t, router)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.URL.Path)
+	}))
+	defer backend.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := Dial(ctx, url, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	proxyln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.ForwardDynamicWithListener(ctx, httpconnect.NewListener(proxyln))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy, _ := neturl.Parse("http://" + proxyln.Addr().String())
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxy)},
+	}
+
+	resp, err := client.Get(backend.URL + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello /a" {
+		t.Fatal("expect", "hello /a", "got", string(body))
+	}
+
+	// CONNECT to a closed port
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	conn, err := net.Dial("tcp", proxyln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "CONNECT "+closed.Addr().String()+" HTTP/1.1\r\nHost: x\r\n\r\n")
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatal("expect", http.StatusBadGateway, "got", resp.StatusCode)
+	}
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"net"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/protocal"
+	"github.com/service-exposer/exposer/protocal/route"
+)
+
+// Route is a route opened on a Client.
+type Route struct {
+	typ   route.Type
+	proto *protocal.Protocal
+	conn  net.Conn
+	ln    net.Listener // closed with the route if set
+
+	done chan struct{}
+	err  error
+}
+
+func (r *Route) Type() route.Type {
+	return r.typ
+}
+
+// Done is closed when the route is gone.
+func (r *Route) Done() <-chan struct{} {
+	return r.done
+}
+
+// Wait blocks until the route is gone and returns why.
+func (r *Route) Wait() error {
+	<-r.done
+	return errors.Trace(r.err)
+}
+
+// Close ends the route, the session stays open.
+func (r *Route) Close() error {
+	if r.ln != nil {
+		r.ln.Close()
+	}
+	return errors.Trace(r.conn.Close())
+}
+
+// Tunnel is a link or forward route that connections are dialed through.
+type Tunnel struct {
+	*Route
+	dial func() (net.Conn, error)
+}
+
+// Dial opens a new connection through the tunnel.
+func (t *Tunnel) Dial() (net.Conn, error) {
+	conn, err := t.dial()
+	return conn, errors.Trace(err)
+}
@@ -15,26 +15,25 @@
 package cmd
 
 import (
-	"crypto/tls"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/gorilla/mux"
 	"github.com/juju/errors"
-	"github.com/service-exposer/exposer/listener/utils"
-	"github.com/service-exposer/exposer/protocal"
-	"github.com/service-exposer/exposer/protocal/auth"
-	"github.com/service-exposer/exposer/service"
+	"github.com/service-exposer/exposer/netacl"
+	"github.com/service-exposer/exposer/protocal/forward"
+	"github.com/service-exposer/exposer/protocal/remoteforward"
+	"github.com/service-exposer/exposer/server"
+	"github.com/service-exposer/exposer/server/config"
 	"github.com/spf13/cobra"
-	"github.com/urfave/negroni"
+	"github.com/spf13/pflag"
 )
 
 // daemonCmd represents the daemon command
@@ -56,215 +55,213 @@ func init() {
 	// is called directly, e.g.:
 	// daemonCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	var (
+		config_file = ""
+
 		addr       = "0.0.0.0:9000"
 		enableTLS  = false
 		https_cert = ""
 		https_key  = ""
-	)
-	daemonCmd.Flags().StringVarP(&addr, "addr", "a", addr, "listen address")
-	daemonCmd.Flags().BoolVarP(&enableTLS, "https", "", enableTLS, "enable TLS")
-	daemonCmd.Flags().StringVarP(&https_cert, "https-cert", "", https_cert, "TLS certificate")
-	daemonCmd.Flags().StringVarP(&https_key, "https-key", "", https_key, "TLS key")
+		acl_file   = ""
 
-	daemonCmd.Run = func(cmd *cobra.Command, args []string) {
-		ln, err := net.Listen("tcp", addr)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, errors.ErrorStack(errors.Annotatef(err, "listen %s", addr)))
-			os.Exit(-1)
-		}
-		defer ln.Close()
+		allow_legacy_auth = false
 
-		var schema = "http"
-		var tlsConf *tls.Config
-		if enableTLS {
-			cert, err := tls.LoadX509KeyPair(https_cert, https_key)
-			if err != nil {
-				exit(-5, errors.ErrorStack(errors.Annotate(err, "LoadX509KeyPair")))
-			}
-			tlsConf = &tls.Config{
-				Certificates: []tls.Certificate{cert},
-			}
-
-			// replace ln to tls.Listener
-			ln = tls.NewListener(ln, tlsConf)
-			defer ln.Close()
-			schema = "https"
-		}
-		log.Print("listen ", fmt.Sprintf("%s://%s/", schema, ln.Addr()))
+		forward_policy = netacl.Config{}
 
-		wsln, wsconnHandler, err := utils.WebsocketHandlerListener(ln.Addr())
-		if err != nil {
-			fmt.Fprintln(os.Stderr, errors.ErrorStack(errors.Annotatef(err, "listen ws %s", ln.Addr)))
-			os.Exit(-2)
-		}
-		defer wsln.Close()
+		remote_ports = ""
+		remote_host  = ""
 
-		serviceRouter := service.NewRouter()
+		http_domain    = ""
+		http_hostnames = []string{}
 
-		r := mux.NewRouter()
+		expose_grace = 30 * time.Second
+		limits       = config.Limits{}
 
-		r.Path("/api/services").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			services := serviceRouter.All()
+		shutdown_timeout = 30 * time.Second
 
-			result := make(map[string]*json.RawMessage)
-			for _, s := range services {
-				s.Attribute().View(func(attr service.Attribute) error {
-					data, err := json.Marshal(attr)
-					if err != nil {
-						return errors.Trace(err)
-					}
+		metrics_addr = ""
+	)
+	daemonCmd.Flags().StringVarP(&config_file, "config", "c", config_file, "YAML config file instead of the flags below, keys, identities, the forward policy and limits are reloaded on SIGHUP")
+	daemonCmd.Flags().StringVarP(&addr, "addr", "a", addr, "listen address")
+	daemonCmd.Flags().BoolVarP(&enableTLS, "https", "", enableTLS, "enable TLS")
+	daemonCmd.Flags().StringVarP(&https_cert, "https-cert", "", https_cert, "TLS certificate")
+	daemonCmd.Flags().StringVarP(&https_key, "https-key", "", https_key, "TLS key")
+	daemonCmd.Flags().BoolVarP(&allow_legacy_auth, "allow-legacy-auth", "", allow_legacy_auth, "accept old clients that send the key in plaintext")
+	daemonCmd.Flags().StringSliceVar(&forward_policy.Allow, "forward-allow", nil, "destinations clients may forward to, format: host|cidr[:port[-port]], default all")
+	daemonCmd.Flags().StringSliceVar(&forward_policy.Deny, "forward-deny", nil, "destinations clients may not forward to, format: host|cidr[:port[-port]]")
+	daemonCmd.Flags().BoolVar(&forward_policy.AllowLoopback, "forward-allow-loopback", false, "allow forwarding to loopback addresses")
+	daemonCmd.Flags().BoolVar(&forward_policy.AllowLinkLocal, "forward-allow-link-local", false, "allow forwarding to link-local addresses")
+	daemonCmd.Flags().StringVar(&remote_ports, "remote-ports", remote_ports, "TCP ports expose --remote-port and remote-forward may listen on, format: port[-port], default disabled")
+	daemonCmd.Flags().StringVar(&remote_host, "remote-host", remote_host, "the only host remote ports and remote forwards listen on, default all interfaces and the host a remote forward asks for")
+	daemonCmd.Flags().StringVar(&http_domain, "http-domain", http_domain, "route requests for <name>.<domain> to the HTTP service name")
+	daemonCmd.Flags().StringSliceVar(&http_hostnames, "http-hostname", http_hostnames, "patterns of custom hostnames HTTP services may claim, like *.example.org, default none")
+	daemonCmd.Flags().DurationVar(&expose_grace, "expose-grace", expose_grace, "how long the name of a dropped exposer stays reserved for it to reconnect, 0 frees it at once")
+	daemonCmd.Flags().IntVar(&limits.MaxStreams, "limit.streams", 0, "cap of the concurrent streams of every service, 0 is unlimited")
+	daemonCmd.Flags().IntVar(&limits.StreamsPerSecond, "limit.stream-rate", 0, "cap of the new streams per second of every service")
+	daemonCmd.Flags().Int64Var(&limits.ReadBytesPerSecond, "limit.read-rate", 0, "cap of the bytes per second read from every service")
+	daemonCmd.Flags().Int64Var(&limits.WriteBytesPerSecond, "limit.write-rate", 0, "cap of the bytes per second written to every service")
+	daemonCmd.Flags().DurationVar(&shutdown_timeout, "shutdown-timeout", shutdown_timeout, "how long to wait for open connections on SIGINT or SIGTERM")
+	daemonCmd.Flags().StringVar(&metrics_addr, "metrics-addr", metrics_addr, "serve /metrics without auth on this address, by default it is only served behind API tokens")
+	daemonCmd.Flags().StringVarP(&acl_file, "acl", "", acl_file, "JSON file of per key access rules, default allows everything to --key, reloaded on SIGHUP")
 
-					rawmsg := json.RawMessage(data)
-					result[s.Name()] = &rawmsg
-					return nil
-				})
+	daemonCmd.Run = func(cmd *cobra.Command, args []string) {
+		// load returns the daemon config, read again on SIGHUP
+		load := func() (*config.Config, error) {
+			conf := config.Default()
+			conf.Listen = addr
+			if enableTLS {
+				conf.TLS.Cert, conf.TLS.Key = https_cert, https_key
 			}
-
-			json.NewEncoder(w).Encode(&result)
-
-		}).Methods("GET")
-
-		r.PathPrefix("/service/{name}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			vars := mux.Vars(r)
-			var (
-				name = vars["name"]
-			)
-
-			s := serviceRouter.Get(name)
-			if s == nil {
-				http.Error(w, "service is not exist", 404)
-				return
+			conf.Key = key
+			conf.AllowLegacyAuth = allow_legacy_auth
+			conf.ACL = acl_file
+			conf.Forward = config.Forward{
+				Allow:          forward_policy.Allow,
+				Deny:           forward_policy.Deny,
+				AllowLoopback:  forward_policy.AllowLoopback,
+				AllowLinkLocal: forward_policy.AllowLinkLocal,
 			}
-
-			var attr service.Attribute
-			s.Attribute().View(func(a service.Attribute) error {
-				attr = a
-				return nil
+			conf.RemotePorts = remote_ports
+			conf.RemoteHost = remote_host
+			conf.HTTP.Domain = http_domain
+			conf.HTTP.Hostnames = http_hostnames
+			conf.ExposeGrace = expose_grace
+			conf.Limits = limits
+			conf.ShutdownTimeout = shutdown_timeout
+			conf.MetricsListen = metrics_addr
+			return conf, nil
+		}
+		if config_file != "" {
+			cmd.LocalFlags().VisitAll(func(f *pflag.Flag) {
+				if f.Changed && f.Name != "config" {
+					exit(-10, "--config can't be used with --"+f.Name)
+				}
 			})
 
-			if !attr.HTTP.Is {
-				http.Error(w, "service is not a HTTP service", 404)
-				return
-			}
-
-			if r.URL.Path == "/service/"+name {
-				http.Redirect(w, r, "/service/"+name+"/", 302)
-				return
+			load = func() (*config.Config, error) {
+				conf, err := config.Load(config_file)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				if conf.Key == "" {
+					conf.Key = key
+				}
+				return conf, nil
 			}
+		}
 
-			hj, ok := w.(http.Hijacker)
-			if !ok {
-				http.Error(w, "webserver doesn't support hijacking", 500)
-				return
-			}
+		conf, err := load()
+		if err != nil {
+			exit(-6, errors.ErrorStack(errors.Annotate(err, "load config")))
+		}
 
-			client, clientbufrw, err := hj.Hijack()
+		opts, err := conf.Options()
+		if err != nil {
+			exit(-7, errors.ErrorStack(errors.Annotate(err, "config")))
+		}
+		opts.Forward.Audit = func(identity string, f forward.Forward, err error) {
 			if err != nil {
-				http.Error(w, err.Error(), 500)
+				log.Printf("forward %q %s %s denied: %v", identity, f.Network, f.Address, err)
 				return
 			}
-			// clear up deadline that maybe set by http.Server
-			client.SetDeadline(time.Time{})
+			log.Printf("forward %q %s %s", identity, f.Network, f.Address)
+		}
 
-			server, err := s.Open()
+		opts.RemoteForward.Audit = func(identity string, f remoteforward.RemoteForward, err error) {
 			if err != nil {
-				http.Error(w, err.Error(), 500)
+				log.Printf("remote forward %q %s %s denied: %v", identity, f.Network, f.Address, err)
 				return
 			}
+			log.Printf("remote forward %q %s %s", identity, f.Network, f.Address)
+		}
 
-			go func(r *http.Request) {
-				var err error
-				for err == nil {
-					subPath := r.URL.Path[len("/service/"+name):]
-					if subPath == "" {
-						client.Close()
-						server.Close()
-						return
-					}
-					if subPath[0] != '/' {
-						subPath = "/" + subPath
-					}
-					url, _ := url.Parse(subPath)
-
-					r.URL = url
-					if attr.HTTP.Host != "" {
-						r.Host = attr.HTTP.Host
-					}
-					r.Header.Set("X-Origin-IP", client.RemoteAddr().String())
-
-					r.Write(server)
-
-					if r.Header.Get("Upgrade") != "" {
-						break
-					}
-					r, err = http.ReadRequest(clientbufrw.Reader)
-				}
-
-				io.Copy(server, clientbufrw)
-				client.Close()
-			}(r)
-
-			io.Copy(clientbufrw, server)
-			server.Close()
-		})
+		srv, err := server.New(opts)
+		if err != nil {
+			exit(-2, errors.ErrorStack(errors.Annotate(err, "server")))
+		}
 
-		n := negroni.New()
+		ln, err := net.Listen("tcp", conf.Listen)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, errors.ErrorStack(errors.Annotatef(err, "listen %s", conf.Listen)))
+			os.Exit(-1)
+		}
 
-		// ws
-		n.UseFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-			if strings.HasPrefix(r.URL.Path, "/service/") {
-				next(w, r)
-				return
+		if conf.MetricsListen != "" {
+			metricsln, err := net.Listen("tcp", conf.MetricsListen)
+			if err != nil {
+				exit(-9, errors.ErrorStack(errors.Annotatef(err, "listen metrics %s", conf.MetricsListen)))
 			}
+			log.Print("metrics ", fmt.Sprintf("http://%s/metrics", metricsln.Addr()))
 
-			connection := r.Header.Get("Connection")
-			upgrade := r.Header.Get("Upgrade")
-			if connection == "Upgrade" && upgrade == "websocket" {
-				wsconnHandler.ServeHTTP(w, r)
-				return
-			}
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", srv.MetricsHandler())
+			go http.Serve(metricsln, mux)
+		}
 
-			next(w, r)
-		})
+		var schema = "http"
+		if opts.TLSConfig != nil {
+			schema = "https"
+		}
+		log.Print("listen ", fmt.Sprintf("%s://%s/", schema, ln.Addr()))
 
-		// auth
-		n.UseFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-			if !strings.HasPrefix(r.URL.Path, "/api/") {
-				next(w, r)
-				return
-			}
+		go func() {
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			running := conf
+			for range hup {
+				conf, err := load()
+				if err != nil {
+					log.Print("reload: ", err)
+					continue
+				}
+				// everything is checked before any of it is applied,
+				// Reload fails before it changes anything
+				rules, err := conf.Rules()
+				if err != nil {
+					log.Print("reload: ", err)
+					continue
+				}
+				forwardPolicy, err := netacl.New(conf.ForwardPolicy())
+				if err != nil {
+					log.Print("reload: ", errors.Annotate(err, "forward policy"))
+					continue
+				}
+				revoked, err := srv.Reload(conf.Key, rules)
+				if err != nil {
+					log.Print("reload: ", err)
+					continue
+				}
+				opts.Forward.Policy.Set(forwardPolicy)
+				srv.SetLimits(conf.ServiceLimits())
+				log.Print("reload keys, identities, forward policy and limits, closed ", revoked, " revoked sessions")
 
-			auth := r.Header.Get("Authorization")
-			if auth != key {
-				w.WriteHeader(401)
-				fmt.Fprintln(w, "Please set Header Authorization as Key")
-				return
+				if fields := conf.Restart(running); len(fields) > 0 {
+					log.Print("reload: WARNING ", strings.Join(fields, ", "), " changed, restart to apply")
+				}
 			}
+		}()
 
-			next(w, r)
-		})
-
-		n.UseHandler(r)
-
+		stopped := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 		go func() {
-			server := &http.Server{
-				ReadTimeout:  30 * time.Second,
-				WriteTimeout: 30 * time.Second,
-				Handler:      n,
-				TLSConfig:    tlsConf,
-			}
+			defer close(stopped)
+
+			sig := <-signals
+			log.Print(sig, ", shutting down, waiting at most ", conf.ShutdownTimeout, " for open connections")
+			signal.Stop(signals)
 
-			err := server.Serve(ln)
+			ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+			defer cancel()
+			err := srv.Shutdown(ctx)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, errors.ErrorStack(errors.Annotate(err, "HTTP server shutdown")))
+				log.Print("shutdown: ", err)
 			}
 		}()
-		protocal.Serve(wsln, func(conn net.Conn) protocal.ProtocalHandler {
-			proto := protocal.NewProtocal(conn)
-			proto.On = auth.ServerSide(serviceRouter, func(k string) bool {
-				return k == key
-			})
-			return proto
-		})
+
+		err = srv.Serve(ln)
+		if errors.Cause(err) != server.ErrServerClosed {
+			exit(-1, errors.ErrorStack(errors.Annotate(err, "HTTP server shutdown")))
+		}
+		<-stopped
 	}
 }
@@ -15,16 +15,12 @@
 package cmd
 
 import (
-	"log"
+	"context"
 	"net"
 
 	"github.com/juju/errors"
-	"github.com/service-exposer/exposer/listener/utils"
-	"github.com/service-exposer/exposer/protocal"
-	"github.com/service-exposer/exposer/protocal/auth"
+	"github.com/service-exposer/exposer/client"
 	"github.com/service-exposer/exposer/protocal/expose"
-	"github.com/service-exposer/exposer/protocal/keepalive"
-	"github.com/service-exposer/exposer/protocal/route"
 	"github.com/service-exposer/exposer/service"
 	"github.com/spf13/cobra"
 )
@@ -52,11 +48,23 @@ func init() {
 		service_addr = "" // [host]:port
 		is_http      = false
 		http_host    = ""
+		http_names   = []string{}
+		group        = ""
+		remote_port  = 0
+		limits       = service.Limits{}
 	)
 	exposeCmd.Flags().StringVarP(&service_name, "name", "n", service_name, "service name")
 	exposeCmd.Flags().StringVarP(&service_addr, "addr", "a", service_addr, "service address format: [host]:port")
 	exposeCmd.Flags().BoolVar(&is_http, "http", is_http, "expose service as HTTP")
 	exposeCmd.Flags().StringVar(&http_host, "http.host", "", "set HTTP host")
+	exposeCmd.Flags().StringSliceVar(&http_names, "http.hostname", http_names, "request hosts the daemon routes to this HTTP service")
+	exposeCmd.Flags().StringVar(&group, "group", group, "join service group balanced by round-robin|least-conn|random instead of claiming the name")
+	exposeCmd.Flags().IntVar(&remote_port, "remote-port", remote_port, "also expose service on this TCP port of the daemon, 0 picks a free port")
+	exposeCmd.Flags().IntVar(&limits.MaxStreams, "limit.streams", 0, "max concurrent streams to the service, 0 is unlimited, the daemon may lower it")
+	exposeCmd.Flags().IntVar(&limits.StreamsPerSecond, "limit.stream-rate", 0, "max new streams per second")
+	exposeCmd.Flags().Int64Var(&limits.ReadBytesPerSecond, "limit.read-rate", 0, "max bytes per second read from the service, all streams together")
+	exposeCmd.Flags().Int64Var(&limits.WriteBytesPerSecond, "limit.write-rate", 0, "max bytes per second written to the service, all streams together")
+	addReconnectFlags(exposeCmd)
 	exposeCmd.Run = func(cmd *cobra.Command, args []string) {
 		if service_name == "" {
 			exit(1, "not set service name")
@@ -66,51 +74,37 @@ func init() {
 			exit(2, "not set service address")
 		}
 
-		conn, err := utils.DialWebsocket(server_websocket_url())
-		if err != nil {
-			exit(-3, errors.ErrorStack(errors.Annotatef(err, "conn %s", server_websocket_url())))
+		var remotePort *int
+		if cmd.Flags().Changed("remote-port") {
+			remotePort = &remote_port
 		}
-		defer conn.Close()
-		log.Print("connect ", server_websocket_url())
 
-		nextRoutes := make(chan auth.NextRoute)
-		proto := protocal.NewProtocal(conn)
-		proto.On = auth.ClientSide(nextRoutes)
-
-		go func() {
-			nextRoutes <- auth.NextRoute{
-				Req: route.RouteReq{
-					Type: route.KeepAlive,
-				},
-				HandleFunc: keepalive.ClientSide(0, 0),
-				Cmd:        keepalive.CMD_PING,
-			}
-			log.Print("setup keepalive route")
+		var reqLimits *service.Limits
+		if !limits.Unlimited() {
+			reqLimits = &limits
+		}
 
-			nextRoutes <- auth.NextRoute{
-				Req: route.RouteReq{
-					Type: route.Expose,
-				},
-				HandleFunc: expose.ClientSide(func() (net.Conn, error) {
+		// the same req reclaims the name after a reconnect
+		req := &expose.ExposeReq{
+			Name:       service_name,
+			Group:      service.Balance(group),
+			RemotePort: remotePort,
+			Limits:     reqLimits,
+			Attr: func() (attr service.Attribute) {
+				attr.HTTP.Is = is_http
+				attr.HTTP.Host = http_host
+				attr.HTTP.Hostnames = http_names
+				return
+			}(),
+		}
+		err := supervise(func(established func()) error {
+			return runSession(established, func(ctx context.Context, c *client.Client) (*client.Route, error) {
+				return c.ExposeWithReq(ctx, req, func() (net.Conn, error) {
 					conn, err := net.Dial("tcp", service_addr)
 					return conn, errors.Trace(err)
-				}),
-				Cmd: expose.CMD_EXPOSE,
-				Details: &expose.ExposeReq{
-					Name: service_name,
-					Attr: func() (attr service.Attribute) {
-						attr.HTTP.Is = is_http
-						attr.HTTP.Host = http_host
-						return
-					}(),
-				},
-			}
-			log.Print("setup expose route")
-		}()
-
-		go proto.Request(auth.CMD_AUTH, &auth.AuthReq{
-			Key: key,
+				})
+			})
 		})
-		exit(0, errors.ErrorStack(errors.Trace(proto.Wait())))
+		exit(-3, errors.ErrorStack(err))
 	}
 }
@@ -1,16 +1,15 @@
 package cmd
 
 import (
+	"context"
 	"log"
 	"net"
 
 	"github.com/juju/errors"
-	"github.com/service-exposer/exposer/listener/utils"
-	"github.com/service-exposer/exposer/protocal"
-	"github.com/service-exposer/exposer/protocal/auth"
-	"github.com/service-exposer/exposer/protocal/forward"
-	"github.com/service-exposer/exposer/protocal/keepalive"
-	"github.com/service-exposer/exposer/protocal/route"
+	"github.com/service-exposer/exposer/client"
+	"github.com/service-exposer/exposer/httpconnect"
+	"github.com/service-exposer/exposer/listener"
+	"github.com/service-exposer/exposer/socks5"
 	"github.com/spf13/cobra"
 )
 
@@ -37,10 +36,23 @@ func init() {
 	var (
 		forward_addr = ""
 		listen_addr  = "localhost:"
+		socks        = false
+		http_proxy   = false
 	)
 	forwardCmd.Flags().StringVarP(&listen_addr, "listen", "l", listen_addr, "local listen address")
 	forwardCmd.Flags().StringVarP(&forward_addr, "forward-addr", "f", forward_addr, "forward address")
+	forwardCmd.Flags().BoolVar(&socks, "socks5", socks, "run a SOCKS5 server, every connection goes to the address it asks for, resolved by the daemon")
+	forwardCmd.Flags().BoolVar(&http_proxy, "http-proxy", http_proxy, "run an HTTP proxy for CONNECT and plain http:// requests, every request goes to the host it asks for, resolved by the daemon")
+	addReconnectFlags(forwardCmd)
 	forwardCmd.Run = func(cmd *cobra.Command, args []string) {
+		if socks && forward_addr != "" {
+			exit(1, "--socks5 can't be used with --forward-addr")
+		}
+		if http_proxy && (socks || forward_addr != "") {
+			exit(1, "--http-proxy can't be used with --socks5 or --forward-addr")
+		}
+		dynamic := socks || http_proxy
+
 		ln, err := net.Listen("tcp", listen_addr)
 		if err != nil {
 			exit(-1, errors.ErrorStack(errors.Annotatef(err, "listen %s", listen_addr)))
@@ -48,45 +60,25 @@ func init() {
 		defer ln.Close()
 		log.Print("listen ", ln.Addr())
 
-		conn, err := utils.DialWebsocket(server_websocket_url())
-		if err != nil {
-			exit(-2, errors.ErrorStack(errors.Annotatef(err, "connect %s", server_websocket_url())))
+		if socks {
+			ln = socks5.NewListener(ln)
 		}
-		defer conn.Close()
-		log.Print("connect ", server_websocket_url())
-
-		nextRoutes := make(chan auth.NextRoute)
-		proto := protocal.NewProtocal(conn)
-		proto.On = auth.ClientSide(nextRoutes)
-
-		go func() {
-			nextRoutes <- auth.NextRoute{
-				Req: route.RouteReq{
-					Type: route.KeepAlive,
-				},
-				HandleFunc: keepalive.ClientSide(0, 0),
-				Cmd:        keepalive.CMD_PING,
-			}
-			log.Print("setup keepalive route")
+		if http_proxy {
+			ln = httpconnect.NewListener(ln)
+		}
+		shared := listener.Shared(ln)
 
-			nextRoutes <- auth.NextRoute{
-				Req: route.RouteReq{
-					Type: route.Forward,
-				},
-				HandleFunc: forward.ClientSide(ln),
-				Cmd:        forward.CMD_FORWARD,
-				Details: &forward.Forward{
-					Network: "tcp",
-					Address: forward_addr,
-				},
-			}
-			log.Print("setup forward route")
-		}()
+		err = supervise(func(established func()) error {
+			sessionln := shared.Listener()
+			defer sessionln.Close()
 
-		go proto.Request(auth.CMD_AUTH, &auth.AuthReq{
-			Key: key,
+			return runSession(established, func(ctx context.Context, c *client.Client) (*client.Route, error) {
+				if dynamic {
+					return c.ForwardDynamicWithListener(ctx, sessionln)
+				}
+				return c.ForwardWithListener(ctx, "tcp", forward_addr, sessionln)
+			})
 		})
-
-		exit(0, errors.ErrorStack(errors.Trace(proto.Wait())))
+		exit(-2, errors.ErrorStack(err))
 	}
 }
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"log"
+	"net/url"
+
+	"github.com/juju/errors"
+	"github.com/spf13/cobra"
+)
+
+// kickCmd represents the kick command
+var kickCmd = &cobra.Command{
+	Use:   "kick",
+	Short: "close a client session, or unregister a service and close the session of its exposer",
+}
+
+func init() {
+	RootCmd.AddCommand(kickCmd)
+
+	var (
+		session_id   = ""
+		service_name = ""
+	)
+	kickCmd.Flags().StringVar(&session_id, "session", session_id, "session ID, as listed by sessions")
+	kickCmd.Flags().StringVarP(&service_name, "name", "n", service_name, "service name")
+	kickCmd.Run = func(cmd *cobra.Command, args []string) {
+		if (session_id == "") == (service_name == "") {
+			exit(1, "set either --session or --name")
+		}
+
+		path, done := "/api/sessions/"+url.PathEscape(session_id), "session "+session_id+" closed"
+		if service_name != "" {
+			path, done = "/api/services/"+url.PathEscape(service_name), "service "+service_name+" unregistered"
+		}
+		err := callAPI("DELETE", path, nil)
+		if err != nil {
+			exit(-2, errors.ErrorStack(err))
+		}
+		log.Print(done)
+	}
+}
@@ -15,16 +15,16 @@
 package cmd
 
 import (
+	"context"
+	"io/ioutil"
 	"log"
 	"net"
+	"os"
 
 	"github.com/juju/errors"
-	"github.com/service-exposer/exposer/listener/utils"
-	"github.com/service-exposer/exposer/protocal"
-	"github.com/service-exposer/exposer/protocal/auth"
-	"github.com/service-exposer/exposer/protocal/keepalive"
-	"github.com/service-exposer/exposer/protocal/link"
-	"github.com/service-exposer/exposer/protocal/route"
+	"github.com/service-exposer/exposer/client"
+	"github.com/service-exposer/exposer/listener"
+	"github.com/service-exposer/exposer/stdio"
 	"github.com/spf13/cobra"
 )
 
@@ -48,15 +48,23 @@ func init() {
 	var (
 		service_name = ""
 		listen_addr  = "localhost:" // [host]:port
+		stdio        = false
 	)
 	linkCmd.Flags().StringVarP(&service_name, "name", "n", service_name, "service name")
 	linkCmd.Flags().StringVarP(&listen_addr, "listen", "l", listen_addr, "listen address. format: [host]:port")
+	linkCmd.Flags().BoolVar(&stdio, "stdio", stdio, "open one stream to the service on stdin/stdout instead of listening, e.g. as ssh ProxyCommand")
+	addReconnectFlags(linkCmd)
 
 	linkCmd.Run = func(cmd *cobra.Command, args []string) {
 		if service_name == "" {
 			exit(1, "not set service name")
 		}
 
+		if stdio {
+			linkStdio(service_name)
+			return
+		}
+
 		if listen_addr == "" {
 			exit(2, "not set listen address")
 		}
@@ -68,43 +76,41 @@ func init() {
 		defer ln.Close()
 		log.Print("listen ", ln.Addr())
 
-		conn, err := utils.DialWebsocket(server_websocket_url())
-		if err != nil {
-			exit(-3, errors.ErrorStack(errors.Annotatef(err, "connect %s", server_websocket_url())))
-		}
-		defer conn.Close()
-		log.Print("connect ", server_websocket_url())
-
-		nextRoutes := make(chan auth.NextRoute)
-		proto := protocal.NewProtocal(conn)
-		proto.On = auth.ClientSide(nextRoutes)
-
-		go func() {
-			nextRoutes <- auth.NextRoute{
-				Req: route.RouteReq{
-					Type: route.KeepAlive,
-				},
-				HandleFunc: keepalive.ClientSide(0, 0),
-				Cmd:        keepalive.CMD_PING,
-			}
-			log.Print("setup keepalive route")
-
-			nextRoutes <- auth.NextRoute{
-				Req: route.RouteReq{
-					Type: route.Link,
-				},
-				HandleFunc: link.ClientSide(ln),
-				Cmd:        link.CMD_LINK,
-				Details: &link.LinkReq{
-					Name: service_name,
-				},
-			}
-			log.Print("setup link route")
-		}()
-
-		go proto.Request(auth.CMD_AUTH, &auth.AuthReq{
-			Key: key,
+		shared := listener.Shared(ln)
+
+		err = supervise(func(established func()) error {
+			sessionln := shared.Listener()
+			defer sessionln.Close()
+
+			return runSession(established, func(ctx context.Context, c *client.Client) (*client.Route, error) {
+				return c.LinkWithListener(ctx, service_name, sessionln)
+			})
 		})
-		exit(0, errors.ErrorStack(errors.Trace(proto.Wait())))
+		exit(-3, errors.ErrorStack(err))
+	}
+}
+
+// linkStdio pipes stdin and stdout through one stream to the service name
+// and exits non-zero unless the stream ended cleanly.
+func linkStdio(service_name string) {
+	// stderr is shown by ssh, only errors go there
+	log.SetOutput(ioutil.Discard)
+
+	ctx := context.Background()
+	c, err := dialSession(ctx)
+	if err != nil {
+		exit(-3, errors.ErrorStack(err))
+	}
+	defer c.Close()
+
+	conn := stdio.NewConn(os.Stdin, os.Stdout)
+	r, err := c.LinkWithListener(ctx, service_name, stdio.NewListener(conn))
+	if err != nil {
+		exit(-4, errors.ErrorStack(err))
+	}
+	defer r.Close()
+
+	if err := conn.Wait(r); err != nil {
+		exit(-5, errors.ErrorStack(err))
 	}
 }
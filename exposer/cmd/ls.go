@@ -15,12 +15,17 @@
 package cmd
 
 import (
+	"bufio"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/protocal/auth"
 	"github.com/service-exposer/exposer/service"
 	"github.com/spf13/cobra"
 )
@@ -44,14 +49,27 @@ func init() {
 	// is called directly, e.g.:
 	// lsCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
+	var (
+		watch = false
+	)
+	lsCmd.Flags().BoolVarP(&watch, "watch", "w", watch, "print service changes as JSON lines, starting with the current services")
+
 	lsCmd.Run = func(cmd *cobra.Command, args []string) {
+		if watch {
+			err := watchServices(os.Stdout)
+			if err != nil {
+				exit(-3, errors.ErrorStack(err))
+			}
+			return
+		}
+
 		url := server_http_url() + "/api/services"
 		req, err := http.NewRequest("GET", url, nil)
 		if err != nil {
 			exit(-1, errors.ErrorStack(errors.Annotatef(err, "GET %s", url)))
 			os.Exit(-1)
 		}
-		req.Header.Set("Authorization", key)
+		req.Header.Set("Authorization", apiAuthorization(req))
 
 		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
@@ -65,7 +83,7 @@ func init() {
 			os.Exit(1)
 		}
 
-		var result map[string]*service.Attribute
+		var result map[string]*service.Info
 		err = json.NewDecoder(resp.Body).Decode(&result)
 		if err != nil {
 			exit(2, errors.ErrorStack(errors.Trace(err)))
@@ -80,3 +98,47 @@ func init() {
 		os.Stdout.Write([]byte{'\n'})
 	}
 }
+
+// apiAuthorization authenticates the API request req without sending the
+// key, the token is good for req only.
+func apiAuthorization(req *http.Request) string {
+	return "Bearer " + auth.APIToken(key, req.Method, req.URL.Path, time.Now())
+}
+
+// watchServices prints the data of every event of /api/events to w, one
+// line each, until the daemon ends the stream.
+func watchServices(w io.Writer) error {
+	url := server_http_url() + "/api/events"
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return errors.Annotatef(err, "GET %s", url)
+	}
+	req.Header.Set("Authorization", apiAuthorization(req))
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Annotatef(err, "GET %s", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		return errors.Errorf("GET %s: %s %s", url, resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		fmt.Fprintln(w, strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Annotate(err, "read events")
+	}
+	return errors.New("event stream closed by daemon")
+}
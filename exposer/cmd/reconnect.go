@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/client"
+	"github.com/service-exposer/exposer/protocal/auth"
+	"github.com/service-exposer/exposer/protocal/keepalive"
+	"github.com/spf13/cobra"
+)
+
+const (
+	reconnect_min_backoff = 500 * time.Millisecond
+)
+
+var (
+	reconnect_max_attempts = 0 // 0 means unlimited
+	reconnect_max_backoff  = 60 * time.Second
+
+	keepalive_degraded_rtt    = keepalive.DefaultDegradedRTT
+	keepalive_degraded_jitter = keepalive.DefaultDegradedJitter
+	keepalive_max_degraded    = 0 // 0 means never reconnect
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+func addReconnectFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&reconnect_max_attempts, "max-attempts", reconnect_max_attempts, "max reconnect attempts in a row, 0 means unlimited")
+	cmd.Flags().DurationVar(&reconnect_max_backoff, "max-backoff", reconnect_max_backoff, "max wait time between reconnect attempts, at least "+reconnect_min_backoff.String())
+	cmd.Flags().DurationVar(&keepalive_degraded_rtt, "degraded-rtt", keepalive_degraded_rtt, "keepalive RTT above which the session is degraded")
+	cmd.Flags().DurationVar(&keepalive_degraded_jitter, "degraded-jitter", keepalive_degraded_jitter, "keepalive jitter above which the session is degraded")
+	cmd.Flags().IntVar(&keepalive_max_degraded, "max-degraded", keepalive_max_degraded, "reconnect after this many degraded keepalive pings in a row, 0 means never")
+}
+
+// backoff returns the wait time before the nth attempt in a row:
+// exponential growth capped by reconnect_max_backoff, half of it jittered.
+// A cap below reconnect_min_backoff counts as reconnect_min_backoff, so
+// --max-backoff 0 can't spin.
+func backoff(attempt int) time.Duration {
+	max := reconnect_max_backoff
+	if max < reconnect_min_backoff {
+		max = reconnect_min_backoff
+	}
+
+	d := reconnect_min_backoff
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// supervise keeps running session until it fails more than
+// reconnect_max_attempts times in a row. A session that got its route
+// set up resets the count.
+func supervise(session func(established func()) error) error {
+	attempt := 0
+	for {
+		var (
+			mutex = new(sync.Mutex)
+			ok    = false
+		)
+		err := session(func() {
+			mutex.Lock()
+			ok = true
+			mutex.Unlock()
+		})
+
+		mutex.Lock()
+		if ok {
+			attempt = 0
+		}
+		mutex.Unlock()
+
+		attempt++
+		if reconnect_max_attempts > 0 && attempt > reconnect_max_attempts {
+			return errors.Annotatef(err, "give up after %d attempts", reconnect_max_attempts)
+		}
+
+		wait := backoff(attempt)
+		log.Printf("session closed: %v, reconnect in %s", err, wait)
+		time.Sleep(wait)
+	}
+}
+
+// runSession connects to the daemon, authenticates and opens a route
+// with open. It returns when the session or the route is gone.
+func runSession(established func(), open func(ctx context.Context, c *client.Client) (*client.Route, error)) error {
+	ctx := context.Background()
+
+	c, err := dialSession(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer c.Close()
+
+	r, err := open(ctx, c)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer r.Close()
+
+	established()
+	log.Print("setup ", r.Type(), " route")
+
+	draining := c.Draining()
+	for {
+		select {
+		case <-draining:
+			log.Print("daemon is draining")
+			draining = nil
+		case <-c.Done():
+			return errors.Trace(c.Wait())
+		case <-r.Done():
+			return errors.Trace(r.Wait())
+		}
+	}
+}
+
+// dialSession connects to the daemon and authenticates. Health changes
+// of the session are logged.
+func dialSession(ctx context.Context) (*client.Client, error) {
+	var (
+		mutex = new(sync.Mutex)
+		last  = keepalive.Healthy
+	)
+	c, err := client.DialWithConfig(ctx, server_websocket_url(), &client.Config{
+		Credential: auth.Credential{
+			Identity:     identity,
+			Key:          key,
+			VerifyServer: verify_server,
+		},
+		KeepAlive: keepalive.Config{
+			DegradedRTT:    keepalive_degraded_rtt,
+			DegradedJitter: keepalive_degraded_jitter,
+			MaxDegraded:    keepalive_max_degraded,
+			OnHealth: func(status keepalive.Status) {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if status.Health == last {
+					return
+				}
+				last = status.Health
+				log.Printf("session %s: rtt %s jitter %s", status.Health, status.RTT, status.Jitter)
+			},
+		},
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	log.Print("connect ", server_websocket_url())
+	return c, nil
+}
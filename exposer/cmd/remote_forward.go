@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"net"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/client"
+	"github.com/spf13/cobra"
+)
+
+// remoteForwardCmd represents the remote-forward command
+var remoteForwardCmd = &cobra.Command{
+	Use:   "remote-forward",
+	Short: "listen on the daemon and forward every connection to a local address, like ssh -R",
+}
+
+func init() {
+	RootCmd.AddCommand(remoteForwardCmd)
+
+	var (
+		remote_addr = "" // [host]:port
+		to_addr     = "" // [host]:port
+	)
+	remoteForwardCmd.Flags().StringVar(&remote_addr, "remote", remote_addr, "address the daemon listens on, format: [host]:port, port 0 picks a free port")
+	remoteForwardCmd.Flags().StringVar(&to_addr, "to", to_addr, "local address connections go to, format: [host]:port")
+	addReconnectFlags(remoteForwardCmd)
+	remoteForwardCmd.Run = func(cmd *cobra.Command, args []string) {
+		if remote_addr == "" {
+			exit(1, "not set remote address")
+		}
+
+		if to_addr == "" {
+			exit(2, "not set local address")
+		}
+
+		err := supervise(func(established func()) error {
+			return runSession(established, func(ctx context.Context, c *client.Client) (*client.Route, error) {
+				return c.RemoteForward(ctx, remote_addr, func() (net.Conn, error) {
+					conn, err := net.Dial("tcp", to_addr)
+					return conn, errors.Trace(err)
+				}, func(address string) {
+					log.Print("daemon listens on ", address)
+				})
+			})
+		})
+		exit(-3, errors.ErrorStack(err))
+	}
+}
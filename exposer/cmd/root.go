@@ -21,16 +21,21 @@ like firewall limitation.`,
 const (
 	ENV_SERVER_URL = "EXPOSER_SERVER"
 	ENV_KEY        = "EXPOSER_KEY"
+	ENV_IDENTITY   = "EXPOSER_IDENTITY"
 )
 
 var (
-	server_url = ""
-	key        = ""
+	server_url    = ""
+	key           = ""
+	identity      = ""
+	verify_server = false
 )
 
 func init() {
 	RootCmd.PersistentFlags().StringVarP(&server_url, "server", "s", os.Getenv(ENV_SERVER_URL), "server url <http(s)://host:port> ,you can set env EXPOSER_SERVER")
 	RootCmd.PersistentFlags().StringVarP(&key, "key", "k", os.Getenv(ENV_KEY), "auth key,you can set env EXPOSER_KEY")
+	RootCmd.PersistentFlags().StringVarP(&identity, "identity", "i", os.Getenv(ENV_IDENTITY), "identity the key belongs to,you can set env EXPOSER_IDENTITY")
+	RootCmd.PersistentFlags().BoolVar(&verify_server, "verify-server", verify_server, "check that the server knows the key too")
 }
 
 func server_http_url() string {
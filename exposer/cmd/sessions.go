@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/server"
+	"github.com/spf13/cobra"
+)
+
+// sessionsCmd represents the sessions command
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "list client sessions of the daemon",
+}
+
+func init() {
+	RootCmd.AddCommand(sessionsCmd)
+
+	sessionsCmd.Run = func(cmd *cobra.Command, args []string) {
+		var sessions []server.SessionInfo
+		err := callAPI("GET", "/api/sessions", &sessions)
+		if err != nil {
+			exit(-2, errors.ErrorStack(err))
+		}
+
+		data, err := json.MarshalIndent(&sessions, "", "  ")
+		if err != nil {
+			exit(3, errors.ErrorStack(errors.Trace(err)))
+		}
+
+		os.Stdout.Write(data)
+		os.Stdout.Write([]byte{'\n'})
+	}
+}
+
+// callAPI sends an API request to the daemon and decodes the JSON
+// response into result, if not nil.
+func callAPI(method, path string, result interface{}) error {
+	url := server_http_url() + path
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return errors.Annotatef(err, "%s %s", method, url)
+	}
+	req.Header.Set("Authorization", apiAuthorization(req))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Annotatef(err, "%s %s", method, url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(resp.Body)
+		return errors.Errorf("%s %s: %s %s", method, url, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if result == nil {
+		return nil
+	}
+	return errors.Trace(json.NewDecoder(resp.Body).Decode(result))
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/tunnels"
+	"github.com/spf13/cobra"
+)
+
+// upCmd represents the up command
+var upCmd = &cobra.Command{
+	Use:   "up",
+	Short: "run the exposes, links and forwards of a tunnels file over one session",
+}
+
+func init() {
+	RootCmd.AddCommand(upCmd)
+
+	var (
+		config_file = "tunnels.yaml"
+	)
+	upCmd.Flags().StringVarP(&config_file, "config", "c", config_file, "tunnels file, reloaded on SIGHUP")
+	addReconnectFlags(upCmd)
+	upCmd.Run = func(cmd *cobra.Command, args []string) {
+		config, err := tunnels.Load(config_file)
+		if err != nil {
+			exit(1, errors.ErrorStack(err))
+		}
+
+		set := tunnels.NewSet()
+		set.Logf = log.Printf
+		err = set.Apply(config)
+		if err != nil {
+			exit(-2, errors.ErrorStack(err))
+		}
+
+		go func() {
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			for range hup {
+				config, err := tunnels.Load(config_file)
+				if err == nil {
+					err = set.Apply(config)
+				}
+				if err != nil {
+					log.Print("reload ", config_file, ": ", err, ", keep running the old tunnels")
+					continue
+				}
+				log.Print("reload ", config_file)
+			}
+		}()
+
+		err = supervise(func(established func()) error {
+			c, err := dialSession(context.Background())
+			if err != nil {
+				return errors.Trace(err)
+			}
+			defer c.Close()
+
+			established()
+			go func() {
+				select {
+				case <-c.Draining():
+					log.Print("daemon is draining")
+				case <-c.Done():
+				}
+			}()
+			return errors.Trace(set.Serve(c))
+		})
+		exit(-3, errors.ErrorStack(err))
+	}
+}
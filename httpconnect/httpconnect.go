@@ -0,0 +1,180 @@
+// Package httpconnect is the server side of an HTTP proxy: CONNECT requests
+// and plain requests for absolute http URIs. Like socks5 it only reads the
+// requests, the caller dials the target.
+package httpconnect
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/listener"
+	"github.com/service-exposer/exposer/netacl"
+	"github.com/service-exposer/exposer/protocal/forward"
+	"github.com/service-exposer/exposer/protocal/route"
+)
+
+var (
+	// HandshakeTimeout bounds the reading of every request.
+	HandshakeTimeout = 10 * time.Second
+)
+
+var (
+	ErrNotProxyRequest = errors.New("not a proxy request")
+)
+
+// Conn is a client connection that asked to reach its target. For plain
+// requests it reads the request rewritten for the target, then EOF, since
+// the next request on the connection may go elsewhere.
+type Conn struct {
+	net.Conn
+	r       io.Reader
+	address string
+	req     *http.Request // nil for CONNECT
+
+	mu      *sync.Mutex
+	replied bool
+	pr      *io.PipeReader
+}
+
+// Target returns the destination asked for, hostnames are not resolved.
+func (conn *Conn) Target() (network, address string) {
+	return "tcp", conn.address
+}
+
+// Dialed answers the request, err is nil if the target was reached.
+func (conn *Conn) Dialed(err error) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.replied {
+		return nil
+	}
+	conn.replied = true
+
+	if err != nil {
+		code := statusCode(err)
+		body := err.Error() + "\n"
+		_, err := fmt.Fprintf(conn.Conn, "HTTP/1.1 %d %s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+			code, http.StatusText(code), len(body), body)
+		return errors.Trace(err)
+	}
+
+	if conn.req == nil {
+		_, err := io.WriteString(conn.Conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+		return errors.Trace(err)
+	}
+
+	pr, pw := io.Pipe()
+	conn.pr = pr
+	conn.r = pr
+	go func() {
+		pw.CloseWithError(conn.req.Write(pw))
+	}()
+	return nil
+}
+
+func (conn *Conn) Read(b []byte) (int, error) {
+	conn.mu.Lock()
+	r := conn.r
+	conn.mu.Unlock()
+
+	return r.Read(b)
+}
+
+// CloseWrite half-closes the client connection if it supports it.
+func (conn *Conn) CloseWrite() error {
+	return listener.CloseWrite(conn.Conn)
+}
+
+func (conn *Conn) Close() error {
+	conn.mu.Lock()
+	if conn.pr != nil {
+		conn.pr.Close()
+	}
+	conn.mu.Unlock()
+
+	return conn.Conn.Close()
+}
+
+// statusCode is the HTTP status of err, a bad request or a failed dial
+// of the daemon.
+func statusCode(err error) int {
+	cause := errors.Cause(err)
+	switch cause {
+	case ErrNotProxyRequest:
+		return http.StatusBadRequest
+	case netacl.ErrDenied, forward.ErrForbidden, route.ErrForbidden:
+		return http.StatusForbidden
+	}
+	if ne, ok := cause.(net.Error); ok && ne.Timeout() {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// Handshake reads the request of a client on conn.
+func Handshake(conn net.Conn) (*Conn, error) {
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	c := &Conn{
+		Conn:    conn,
+		r:       br,
+		address: "",
+		req:     nil,
+
+		mu:      new(sync.Mutex),
+		replied: false,
+		pr:      nil,
+	}
+
+	if req.Method == http.MethodConnect {
+		_, _, err := net.SplitHostPort(req.RequestURI)
+		if err != nil {
+			c.Dialed(errors.Annotate(ErrNotProxyRequest, err.Error()))
+			return nil, errors.Annotatef(ErrNotProxyRequest, "CONNECT %q", req.RequestURI)
+		}
+		c.address = req.RequestURI
+		return c, nil
+	}
+
+	if req.URL.Scheme != "http" || req.URL.Host == "" {
+		c.Dialed(ErrNotProxyRequest)
+		return nil, errors.Annotatef(ErrNotProxyRequest, "%s %q", req.Method, req.RequestURI)
+	}
+
+	c.address = req.URL.Host
+	if req.URL.Port() == "" {
+		c.address = net.JoinHostPort(req.URL.Hostname(), "80")
+	}
+
+	// one request per connection, to the origin server
+	req.Header.Del("Proxy-Connection")
+	req.Header.Del("Proxy-Authorization")
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header.Set("User-Agent", "")
+	}
+	req.Close = true
+	c.req = req
+	c.r = strings.NewReader("") // until Dialed
+
+	return c, nil
+}
+
+// NewListener returns a listener that reads the request of every client
+// of ln and accepts the ones that asked for a target, as *Conn.
+func NewListener(ln net.Listener) net.Listener {
+	return listener.Handshake(ln, HandshakeTimeout, func(conn net.Conn) (net.Conn, error) {
+		return Handshake(conn)
+	})
+}
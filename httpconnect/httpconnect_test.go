@@ -0,0 +1,185 @@
+package httpconnect
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/netacl"
+	"github.com/service-exposer/exposer/protocal"
+	"github.com/service-exposer/exposer/protocal/forward"
+)
+
+func TestHandshake(t *testing.T) {
+	for req, expect := range map[string]string{
+		"CONNECT 10.0.0.2:22 HTTP/1.1\r\nHost: 10.0.0.2:22\r\n\r\n":        "10.0.0.2:22",
+		"GET http://example.org/a?b HTTP/1.1\r\nHost: example.org\r\n\r\n": "example.org:80",
+		"GET http://[::1]:8080/ HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n":      "[::1]:8080",
+	} {
+		c1, c2 := net.Pipe()
+		go c2.Write([]byte(req))
+
+		conn, err := Handshake(c1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		network, address := conn.Target()
+		if network != "tcp" || address != expect {
+			t.Fatal("expect", expect, "got", network, address)
+		}
+		c1.Close()
+	}
+}
+
+func TestHandshake_reply(t *testing.T) {
+	for dialErr, expect := range map[error]string{
+		nil: "HTTP/1.1 200 Connection Established",
+		errors.Annotate(netacl.ErrDenied, "loopback address 127.0.0.1"): "HTTP/1.1 403 Forbidden",
+		errors.Annotate(forward.ErrRefused, "dial tcp 10.0.0.2:22"):     "HTTP/1.1 502 Bad Gateway",
+		errors.Annotate(forward.ErrTimeout, "dial tcp 10.0.0.2:22"):     "HTTP/1.1 504 Gateway Timeout",
+		errors.Annotatef(forward.ErrForbidden, "forward %q", "x:1"):     "HTTP/1.1 403 Forbidden",
+		errors.Annotate(forward.ErrUnreachable, "no such host"):         "HTTP/1.1 502 Bad Gateway",
+		errors.New("destination denied"):                                "HTTP/1.1 502 Bad Gateway",
+	} {
+		c1, c2 := net.Pipe()
+		go func(dialErr error) {
+			conn, err := Handshake(c1)
+			if err != nil {
+				return
+			}
+			conn.Dialed(dialErr)
+		}(dialErr)
+
+		c2.Write([]byte("CONNECT 10.0.0.2:22 HTTP/1.1\r\nHost: 10.0.0.2:22\r\n\r\n"))
+		line, err := bufio.NewReader(c2).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(line) != expect {
+			t.Fatal("expect", expect, "got", line)
+		}
+		c1.Close()
+		c2.Close()
+	}
+}
+
+func TestHandshake_invalid(t *testing.T) {
+	for _, req := range []string{
+		"GET / HTTP/1.1\r\nHost: example.org\r\n\r\n",
+		"GET https://example.org/ HTTP/1.1\r\nHost: example.org\r\n\r\n",
+		"CONNECT example.org HTTP/1.1\r\nHost: example.org\r\n\r\n",
+	} {
+		c1, c2 := net.Pipe()
+		go c2.Write([]byte(req))
+
+		errch := make(chan error, 1)
+		go func() {
+			_, err := Handshake(c1)
+			errch <- err
+		}()
+
+		line, err := bufio.NewReader(c2).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(line) != "HTTP/1.1 400 Bad Request" {
+			t.Fatal("expect", "HTTP/1.1 400 Bad Request", "got", line)
+		}
+		go io.Copy(io.Discard, c2)
+		if <-errch == nil {
+			t.Fatal("expect error for", req)
+		}
+		c1.Close()
+	}
+}
+
+func TestHandshake_plain(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go c2.Write([]byte("POST http://example.org/a?b HTTP/1.1\r\nHost: example.org\r\nProxy-Connection: keep-alive\r\nContent-Length: 5\r\n\r\nhello"))
+
+	conn, err := Handshake(c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Dialed(nil)
+
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.RequestURI != "/a?b" || req.Host != "example.org" {
+		t.Fatal("expect", "/a?b example.org", "got", req.RequestURI, req.Host)
+	}
+	if !req.Close || req.Header.Get("Proxy-Connection") != "" {
+		t.Fatal("expect", "Connection: close only", "got", req.Header)
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil || string(body) != "hello" {
+		t.Fatal("expect", "hello", "got", string(body), err)
+	}
+}
+
+func TestListener(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.URL.Path)
+	}))
+	defer backend.Close()
+	tlsBackend := httptest.NewTLSServer(backend.Config.Handler)
+	defer tlsBackend.Close()
+
+	tcpln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := NewListener(tcpln)
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			_, address := conn.(*Conn).Target()
+			target, err := net.Dial("tcp", address)
+			conn.(*Conn).Dialed(err)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go protocal.Forward(conn, target)
+		}
+	}()
+
+	proxy, _ := url.Parse("http://" + tcpln.Addr().String())
+	for _, client := range []*http.Client{
+		{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}},
+		{Transport: &http.Transport{Proxy: http.ProxyURL(proxy), TLSClientConfig: tlsBackend.Client().Transport.(*http.Transport).TLSClientConfig}},
+	} {
+		server := backend.URL
+		if client.Transport.(*http.Transport).TLSClientConfig != nil {
+			server = tlsBackend.URL
+		}
+
+		for _, path := range []string{"/a", "/b"} {
+			resp, err := client.Get(server + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "hello "+path {
+				t.Fatal("expect", "hello "+path, "got", string(body))
+			}
+		}
+	}
+}
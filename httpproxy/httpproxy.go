@@ -0,0 +1,170 @@
+package httpproxy
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/service"
+)
+
+var (
+	ErrServiceNotExist = errors.New("service is not exist")
+	ErrNotHTTPService  = errors.New("service is not a HTTP service")
+)
+
+var (
+	// ResponseHeaderTimeout limits how long a service may take to answer,
+	// the proxy replies 504 after it.
+	ResponseHeaderTimeout = 60 * time.Second
+	// IdleConnTimeout closes streams kept for reuse after this long.
+	IdleConnTimeout = 90 * time.Second
+)
+
+type contextKey struct{}
+
+// target is what Director needs to know about a proxied request.
+type target struct {
+	name   string
+	prefix string
+	host   string // Attribute.HTTP.Host
+}
+
+// Proxy is a reverse proxy to the HTTP services of a router. Its transport
+// dials services through Service.Open and keeps the streams for reuse.
+type Proxy struct {
+	router *service.Router
+	proxy  *httputil.ReverseProxy
+}
+
+func New(router *service.Router) *Proxy {
+	p := &Proxy{
+		router: router,
+		proxy:  nil,
+	}
+
+	p.proxy = &httputil.ReverseProxy{
+		Director: director,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           p.dial,
+			ResponseHeaderTimeout: ResponseHeaderTimeout,
+			IdleConnTimeout:       IdleConnTimeout,
+			MaxIdleConnsPerHost:   16,
+		},
+		FlushInterval: -1, // stream responses as they come
+		ErrorHandler:  errorHandler,
+	}
+
+	return p
+}
+
+// ServeService proxies r to the HTTP service name, with prefix stripped
+// from the request path.
+func (p *Proxy) ServeService(w http.ResponseWriter, r *http.Request, name, prefix string) {
+	s := p.router.Get(name)
+	if s == nil {
+		http.Error(w, ErrServiceNotExist.Error(), http.StatusNotFound)
+		return
+	}
+
+	var attr service.Attribute
+	s.Attribute().View(func(a service.Attribute) error {
+		attr = a
+		return nil
+	})
+
+	if !attr.HTTP.Is {
+		http.Error(w, ErrNotHTTPService.Error(), http.StatusNotFound)
+		return
+	}
+
+	// long responses and upgraded connections outlive http.Server timeouts
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	ctx := context.WithValue(r.Context(), contextKey{}, &target{
+		name:   name,
+		prefix: prefix,
+		host:   attr.HTTP.Host,
+	})
+	p.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// CloseIdleConnections closes the service streams kept for reuse.
+func (p *Proxy) CloseIdleConnections() {
+	p.proxy.Transport.(*http.Transport).CloseIdleConnections()
+}
+
+func director(r *http.Request) {
+	t := r.Context().Value(contextKey{}).(*target)
+
+	r.URL.Scheme = "http"
+	// the transport pools connections by host, one per service
+	r.URL.Host = hex.EncodeToString([]byte(t.name)) + ":80"
+
+	if t.prefix != "" {
+		r.URL.Path = stripPrefix(r.URL.Path, t.prefix)
+		if r.URL.RawPath != "" {
+			r.URL.RawPath = stripPrefix(r.URL.RawPath, t.prefix)
+		}
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	r.Header.Set("X-Forwarded-Proto", proto)
+	r.Header.Set("X-Forwarded-Host", r.Host)
+	r.Header.Set("X-Origin-IP", r.RemoteAddr)
+
+	if t.host != "" {
+		r.Host = t.host
+	}
+}
+
+func stripPrefix(path, prefix string) string {
+	path = strings.TrimPrefix(path, prefix)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+func (p *Proxy) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	name, err := hex.DecodeString(host)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	s := p.router.Get(string(name))
+	if s == nil {
+		return nil, errors.Annotatef(ErrServiceNotExist, "%q", name)
+	}
+
+	conn, err := s.Open()
+	return conn, errors.Trace(err)
+}
+
+func errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusBadGateway
+	if ne, ok := errors.Cause(err).(net.Error); ok && ne.Timeout() || errors.Cause(err) == context.DeadlineExceeded {
+		status = http.StatusGatewayTimeout
+	}
+	if errors.Cause(err) == service.ErrLimitExceeded {
+		status = http.StatusTooManyRequests
+	}
+
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,194 @@
+package httpproxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/service-exposer/exposer/service"
+)
+
+func exposeHTTP(t *testing.T, router *service.Router, name string, handler http.Handler) *httptest.Server {
+	backend := httptest.NewServer(handler)
+
+	err := router.Prepare(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.Add(name, func() (net.Conn, error) {
+		return net.Dial("tcp", backend.Listener.Addr().String())
+	}, func() error { return nil })
+	router.Get(name).Attribute().Update(func(attr *service.Attribute) error {
+		attr.HTTP.Is = true
+		return nil
+	})
+
+	return backend
+}
+
+func newFrontend(router *service.Router) *httptest.Server {
+	proxy := New(router)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/service/"), "/", 2)[0]
+		proxy.ServeService(w, r, name, "/service/"+name)
+	}))
+}
+
+func TestProxy(t *testing.T) {
+	router := service.NewRouter()
+	backend := exposeHTTP(t, router, "web", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s %s %s",
+			r.URL.RequestURI(), body,
+			r.Header.Get("X-Forwarded-Host"),
+			r.Header.Get("X-Forwarded-Proto"),
+			r.Header.Get("X-Forwarded-For"))
+	}))
+	defer backend.Close()
+
+	frontend := newFrontend(router)
+	defer frontend.Close()
+
+	// chunked request body
+	resp, err := http.Post(frontend.URL+"/service/web/a/b?q=1", "text/plain", io.MultiReader(strings.NewReader("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	host := strings.TrimPrefix(frontend.URL, "http://")
+	expect := "/a/b?q=1 hello " + host + " http 127.0.0.1"
+	if string(data) != expect {
+		t.Fatal("expect", expect, "got", string(data))
+	}
+}
+
+func TestProxy_status(t *testing.T) {
+	router := service.NewRouter()
+
+	ResponseHeaderTimeout = 100 * time.Millisecond
+	defer func() { ResponseHeaderTimeout = 60 * time.Second }()
+
+	backend := exposeHTTP(t, router, "slow", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer backend.Close()
+
+	router.Prepare("down")
+	router.Add("down", func() (net.Conn, error) {
+		return nil, fmt.Errorf("down")
+	}, func() error { return nil })
+	router.Get("down").Attribute().Update(func(attr *service.Attribute) error {
+		attr.HTTP.Is = true
+		return nil
+	})
+
+	busy := exposeHTTP(t, router, "busy", http.NotFoundHandler())
+	defer busy.Close()
+	router.Get("busy").SetLimits(service.Limits{MaxStreams: 1})
+	conn, err := router.Get("busy").Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	frontend := newFrontend(router)
+	defer frontend.Close()
+
+	for path, expect := range map[string]int{
+		"/service/none/": http.StatusNotFound,
+		"/service/down/": http.StatusBadGateway,
+		"/service/slow/": http.StatusGatewayTimeout,
+		"/service/busy/": http.StatusTooManyRequests,
+	} {
+		resp, err := http.Get(frontend.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != expect {
+			t.Fatal(path, "expect", expect, "got", resp.StatusCode)
+		}
+	}
+}
+
+func TestProxy_stream(t *testing.T) {
+	router := service.NewRouter()
+	next := make(chan struct{})
+	backend := exposeHTTP(t, router, "stream", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "first")
+		w.(http.Flusher).Flush()
+		<-next
+		fmt.Fprintln(w, "second")
+	}))
+	defer backend.Close()
+
+	frontend := newFrontend(router)
+	defer frontend.Close()
+
+	resp, err := http.Get(frontend.URL + "/service/stream/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "first\n" {
+		t.Fatal("expect first got", line)
+	}
+
+	close(next)
+	line, _ = reader.ReadString('\n')
+	if line != "second\n" {
+		t.Fatal("expect second got", line)
+	}
+}
+
+func TestProxy_websocket(t *testing.T) {
+	router := service.NewRouter()
+	backend := exposeHTTP(t, router, "ws", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		typ, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		ws.WriteMessage(typ, data)
+	}))
+	defer backend.Close()
+
+	frontend := newFrontend(router)
+	defer frontend.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(frontend.URL, "http")+"/service/ws/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	ws.WriteMessage(websocket.TextMessage, []byte("hello"))
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatal("expect hello got", string(data))
+	}
+}
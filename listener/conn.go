@@ -0,0 +1,19 @@
+package listener
+
+import (
+	"net"
+)
+
+// CloseWriter is a conn whose writing side can be shut down alone.
+type CloseWriter interface {
+	CloseWrite() error
+}
+
+// CloseWrite shuts down the writing side of conn, or all of conn if it
+// cannot be half-closed. Conn wrappers use it to pass CloseWrite on.
+func CloseWrite(conn net.Conn) error {
+	if cw, ok := conn.(CloseWriter); ok {
+		return cw.CloseWrite()
+	}
+	return conn.Close()
+}
@@ -4,4 +4,5 @@ import "errors"
 
 var (
 	ErrListenerClosed = errors.New("listener closed")
+	ErrWriteClosed    = errors.New("write closed")
 )
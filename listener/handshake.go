@@ -0,0 +1,94 @@
+package listener
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+type handshakeListener struct {
+	ln        net.Listener
+	timeout   time.Duration
+	handshake func(conn net.Conn) (net.Conn, error)
+
+	accepts chan net.Conn
+	done    chan struct{}
+	err     error
+
+	closeOnce *sync.Once
+	closed    chan struct{}
+}
+
+// Handshake returns a listener that runs handshake on every client of ln,
+// within timeout, and accepts the conns it returns. Clients that fail the
+// handshake are closed.
+func Handshake(ln net.Listener, timeout time.Duration, handshake func(conn net.Conn) (net.Conn, error)) net.Listener {
+	hln := &handshakeListener{
+		ln:        ln,
+		timeout:   timeout,
+		handshake: handshake,
+
+		accepts: make(chan net.Conn),
+		done:    make(chan struct{}),
+		err:     nil,
+
+		closeOnce: new(sync.Once),
+		closed:    make(chan struct{}),
+	}
+
+	go hln.serve()
+
+	return hln
+}
+
+func (ln *handshakeListener) serve() {
+	defer close(ln.done)
+
+	for {
+		conn, err := ln.ln.Accept()
+		if err != nil {
+			ln.err = errors.Trace(err)
+			return
+		}
+
+		go func() {
+			conn.SetDeadline(time.Now().Add(ln.timeout))
+			handshaked, err := ln.handshake(conn)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			conn.SetDeadline(time.Time{})
+
+			select {
+			case ln.accepts <- handshaked:
+			case <-ln.closed:
+				conn.Close()
+			}
+		}()
+	}
+}
+
+func (ln *handshakeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.accepts:
+		return conn, nil
+	case <-ln.closed:
+		return nil, errors.Annotate(ErrListenerClosed, "handshake")
+	case <-ln.done:
+		return nil, ln.err
+	}
+}
+
+func (ln *handshakeListener) Close() error {
+	ln.closeOnce.Do(func() {
+		close(ln.closed)
+	})
+	return ln.ln.Close()
+}
+
+func (ln *handshakeListener) Addr() net.Addr {
+	return ln.ln.Addr()
+}
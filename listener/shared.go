@@ -0,0 +1,106 @@
+package listener
+
+import (
+	"net"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// SharedListener keeps accepting on one listener while handing conns to a
+// sequence of short lived listeners, so a local listening socket survives
+// reconnects of the session that serves it.
+type SharedListener struct {
+	ln      net.Listener
+	accepts chan net.Conn
+	done    chan struct{}
+	err     error
+
+	once   *sync.Once
+	closed chan struct{}
+}
+
+func Shared(ln net.Listener) *SharedListener {
+	shared := &SharedListener{
+		ln:      ln,
+		accepts: make(chan net.Conn),
+		done:    make(chan struct{}),
+		err:     nil,
+
+		once:   new(sync.Once),
+		closed: make(chan struct{}),
+	}
+
+	go shared.serve()
+
+	return shared
+}
+
+func (shared *SharedListener) serve() {
+	defer close(shared.done)
+
+	for {
+		conn, err := shared.ln.Accept()
+		if err != nil {
+			shared.err = errors.Trace(err)
+			return
+		}
+
+		select {
+		case shared.accepts <- conn:
+		case <-shared.closed:
+			conn.Close()
+			shared.err = errors.Annotate(ErrListenerClosed, "shared")
+			return
+		}
+	}
+}
+
+// Listener returns a listener that receives conns accepted by shared
+// until it is closed. Closing it leaves shared open.
+func (shared *SharedListener) Listener() net.Listener {
+	return &sharedListener{
+		shared: shared,
+		once:   new(sync.Once),
+		closed: make(chan struct{}),
+	}
+}
+
+func (shared *SharedListener) Addr() net.Addr {
+	return shared.ln.Addr()
+}
+
+func (shared *SharedListener) Close() error {
+	shared.once.Do(func() {
+		close(shared.closed)
+	})
+	return shared.ln.Close()
+}
+
+type sharedListener struct {
+	shared *SharedListener
+	once   *sync.Once
+	closed chan struct{}
+}
+
+func (ln *sharedListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.shared.accepts:
+		return conn, nil
+	case <-ln.closed:
+		return nil, errors.Annotate(ErrListenerClosed, "shared")
+	case <-ln.shared.done:
+		return nil, ln.shared.err
+	}
+}
+
+func (ln *sharedListener) Close() error {
+	ln.once.Do(func() {
+		close(ln.closed)
+	})
+	return nil
+}
+
+func (ln *sharedListener) Addr() net.Addr {
+	return ln.shared.Addr()
+}
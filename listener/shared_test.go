@@ -0,0 +1,61 @@
+package listener
+
+import (
+	"testing"
+)
+
+func TestShared(t *testing.T) {
+	pipeln, dial := Pipe()
+	shared := Shared(pipeln)
+
+	ln := shared.Listener()
+
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+
+	_, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-accepted; err != nil {
+		t.Fatal(err)
+	}
+
+	ln.Close()
+	_, err = ln.Accept()
+	if err == nil {
+		t.Fatal("expect err")
+	}
+
+	// a closed listener leaves the shared one accepting
+	ln = shared.Listener()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+
+	_, err = dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-accepted; err != nil {
+		t.Fatal(err)
+	}
+
+	shared.Close()
+	_, err = ln.Accept()
+	if err == nil {
+		t.Fatal("expect err")
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"sync"
@@ -15,6 +16,7 @@ var upgrader = websocket.Upgrader{
 	ReadBufferSize:   1024,
 	WriteBufferSize:  1024,
 	HandshakeTimeout: 15 * time.Second,
+	Subprotocols:     []string{listener.WebsocketSubprotocol},
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
@@ -23,6 +25,7 @@ var dialer = websocket.Dialer{
 	ReadBufferSize:   1024,
 	WriteBufferSize:  1024,
 	HandshakeTimeout: 15 * time.Second,
+	Subprotocols:     []string{listener.WebsocketSubprotocol},
 }
 
 func WebsocketHandlerListener(addr net.Addr) (net.Listener, http.Handler, error) {
@@ -42,6 +45,7 @@ func WebsocketHandlerListener(addr net.Addr) (net.Listener, http.Handler, error)
 		defer mutex.Unlock()
 
 		if closed {
+			ws.Close()
 			return
 		}
 
@@ -87,10 +91,14 @@ func WebsocketListener(network, addr string) (net.Listener, error) {
 }
 
 func DialWebsocket(url string) (net.Conn, error) {
-	ws, _, err := dialer.Dial(url, nil)
+	return DialWebsocketContext(context.Background(), url)
+}
+
+func DialWebsocketContext(ctx context.Context, url string) (net.Conn, error) {
+	ws, _, err := dialer.DialContext(ctx, url, nil)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
-	return listener.NewWebsocketConn(ws), nil
+	return listener.NewNegotiatedWebsocketConn(ws), nil
 }
@@ -4,6 +4,9 @@ import (
 	"io"
 	"sync"
 	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/service-exposer/exposer/listener"
 )
 
 func TestWebsocket(t *testing.T) {
@@ -63,3 +66,50 @@ func TestWebsocket(t *testing.T) {
 
 	closeWg.Wait()
 }
+
+func TestWebsocket_rawFallback(t *testing.T) {
+	ln, err := WebsocketListener("tcp", "localhost:9776")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	// a client without subprotocol support
+	ws, _, err := websocket.DefaultDialer.Dial("ws://localhost:9776/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.Subprotocol() != "" {
+		t.Fatal("expect", "", "got", ws.Subprotocol())
+	}
+
+	conn := listener.NewWebsocketConn(ws)
+	defer conn.Close()
+
+	conn.Write([]byte("hello"))
+	buf := make([]byte, 5)
+
+	_, err = io.ReadAtLeast(conn, buf, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "hello" {
+		t.Fatal("expect", "hello", "got", string(buf))
+	}
+}
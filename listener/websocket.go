@@ -27,7 +27,7 @@ func (ln *websocketListener) Accept() (net.Conn, error) {
 		return nil, errors.Annotate(ErrListenerClosed, "websocket")
 	}
 
-	return NewWebsocketConn(ws), nil
+	return NewNegotiatedWebsocketConn(ws), nil
 }
 
 func (ln *websocketListener) Close() error {
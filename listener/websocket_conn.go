@@ -1,17 +1,349 @@
 package listener
 
 import (
+	"io"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/juju/errors"
+)
+
+// WebsocketSubprotocol is negotiated by peers that exchange traffic as
+// binary WebSocket messages. Peers that don't offer it fall back to the
+// raw passthrough of the underlying conn.
+const WebsocketSubprotocol = "exposer.binary.v1"
+
+// websocketCloseWrite is sent as a text message by CloseWrite. Data only
+// travels in binary messages, so older peers skip it.
+const websocketCloseWrite = "close-write"
+
+var (
+	WebsocketPingInterval = 20 * time.Second
+	WebsocketCloseTimeout = 5 * time.Second
 )
 
 type websocketConn struct {
 	net.Conn
 }
 
+func (conn *websocketConn) CloseWrite() error {
+	return CloseWrite(conn.Conn)
+}
+
+// NewWebsocketConn returns the raw underlying conn of ws.
+// All traffic bypasses WebSocket framing.
 func NewWebsocketConn(conn *websocket.Conn) net.Conn {
 	return &websocketConn{
 		Conn: conn.UnderlyingConn(),
 	}
 }
+
+// NewNegotiatedWebsocketConn picks the message framed conn when the peer
+// agreed on WebsocketSubprotocol and the raw conn otherwise.
+func NewNegotiatedWebsocketConn(conn *websocket.Conn) net.Conn {
+	if conn.Subprotocol() == WebsocketSubprotocol {
+		return NewWebsocketMessageConn(conn)
+	}
+
+	return NewWebsocketConn(conn)
+}
+
+type websocketMessageConn struct {
+	ws *websocket.Conn
+
+	// WebsocketPingInterval and WebsocketCloseTimeout when created
+	pingInterval time.Duration
+	closeTimeout time.Duration
+
+	readMutex *sync.Mutex
+	reader    io.Reader
+
+	readClosed bool
+
+	deadlineMutex *sync.Mutex
+	readDeadline  time.Time // set by the caller, zero if none
+	pongDeadline  time.Time // when the peer missed a pong
+
+	writeMutex  *sync.Mutex
+	writeClosed bool
+
+	closeOnce *sync.Once
+	done      chan struct{}
+
+	peerCloseOnce *sync.Once
+	peerClosed    chan struct{}
+}
+
+// NewWebsocketMessageConn returns a net.Conn which sends every Write as a
+// binary WebSocket message, answers and sends pings, and closes with a
+// close frame. Reads fail once the peer misses a pong or at the read
+// deadline set by the caller, whichever comes first.
+func NewWebsocketMessageConn(ws *websocket.Conn) net.Conn {
+	conn := &websocketMessageConn{
+		ws: ws,
+
+		pingInterval: WebsocketPingInterval,
+		closeTimeout: WebsocketCloseTimeout,
+
+		readMutex: new(sync.Mutex),
+		reader:    nil,
+
+		readClosed: false,
+
+		deadlineMutex: new(sync.Mutex),
+		readDeadline:  time.Time{},
+		pongDeadline:  time.Time{},
+
+		writeMutex:  new(sync.Mutex),
+		writeClosed: false,
+
+		closeOnce: new(sync.Once),
+		done:      make(chan struct{}),
+
+		peerCloseOnce: new(sync.Once),
+		peerClosed:    make(chan struct{}),
+	}
+
+	conn.setPongDeadline()
+	ws.SetPongHandler(func(string) error {
+		return conn.setPongDeadline()
+	})
+	ws.SetCloseHandler(func(code int, text string) error {
+		conn.peerCloseOnce.Do(func() {
+			close(conn.peerClosed)
+		})
+
+		// answer like the default handler, a no-op after our own close
+		ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, ""),
+			time.Now().Add(conn.closeTimeout))
+		return nil
+	})
+
+	go conn.ping()
+
+	return conn
+}
+
+// pongWait is how long conn reads without a pong before it gives up on
+// the peer.
+func (conn *websocketMessageConn) pongWait() time.Duration {
+	return conn.pingInterval + conn.closeTimeout
+}
+
+func (conn *websocketMessageConn) setPongDeadline() error {
+	conn.deadlineMutex.Lock()
+	defer conn.deadlineMutex.Unlock()
+
+	conn.pongDeadline = time.Now().Add(conn.pongWait())
+	return conn.applyReadDeadline()
+}
+
+// applyReadDeadline sets the earlier of the deadlines on ws,
+// conn.deadlineMutex must be held.
+func (conn *websocketMessageConn) applyReadDeadline() error {
+	select {
+	case <-conn.done:
+		return nil // keep the deadline of Close
+	default:
+	}
+
+	deadline := conn.pongDeadline
+	if !conn.readDeadline.IsZero() && conn.readDeadline.Before(deadline) {
+		deadline = conn.readDeadline
+	}
+	return conn.ws.SetReadDeadline(deadline)
+}
+
+func (conn *websocketMessageConn) ping() {
+	ticker := time.NewTicker(conn.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-conn.done:
+			return
+		case <-ticker.C:
+		}
+
+		err := conn.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(conn.closeTimeout))
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (conn *websocketMessageConn) Read(b []byte) (int, error) {
+	conn.readMutex.Lock()
+	defer conn.readMutex.Unlock()
+
+	for {
+		if conn.readClosed {
+			return 0, io.EOF
+		}
+
+		if conn.reader == nil {
+			typ, reader, err := conn.ws.NextReader()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+
+			if typ == websocket.TextMessage {
+				// websocketCloseWrite is the only text message, a longer
+				// one is not read into memory
+				data, err := io.ReadAll(io.LimitReader(reader, int64(len(websocketCloseWrite))+1))
+				if err != nil {
+					return 0, err
+				}
+				if string(data) == websocketCloseWrite {
+					conn.readClosed = true
+					go conn.drain()
+				}
+				continue
+			}
+			if typ != websocket.BinaryMessage {
+				continue
+			}
+
+			conn.reader = reader
+		}
+
+		n, err := conn.reader.Read(b)
+		if err == io.EOF {
+			conn.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+
+		return n, err
+	}
+}
+
+// drain goes on reading after the peer closed its side, so pings, pongs
+// and the close frame are still handled.
+func (conn *websocketMessageConn) drain() {
+	for {
+		_, _, err := conn.ws.NextReader()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (conn *websocketMessageConn) Write(b []byte) (int, error) {
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	if conn.writeClosed {
+		return 0, errors.Annotate(ErrWriteClosed, "websocket")
+	}
+
+	err := conn.ws.WriteMessage(websocket.BinaryMessage, b)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// CloseWrite tells the peer that no more data follows, its Read returns
+// io.EOF. Reading goes on until the peer is done too.
+func (conn *websocketMessageConn) CloseWrite() error {
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	if conn.writeClosed {
+		return nil
+	}
+	conn.writeClosed = true
+
+	return errors.Trace(conn.ws.WriteMessage(websocket.TextMessage, []byte(websocketCloseWrite)))
+}
+
+// Close sends a close frame and waits up to WebsocketCloseTimeout for the
+// peer to answer before closing the underlying conn.
+func (conn *websocketMessageConn) Close() error {
+	var err error
+	conn.closeOnce.Do(func() {
+		// under deadlineMutex, no pong moves the deadline set below
+		conn.deadlineMutex.Lock()
+		close(conn.done)
+		conn.deadlineMutex.Unlock()
+
+		deadline := time.Now().Add(conn.closeTimeout)
+		e := conn.ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			deadline)
+		if e == nil {
+			conn.waitPeerClose(deadline)
+		}
+
+		err = errors.Trace(conn.ws.Close())
+	})
+
+	return err
+}
+
+// waitPeerClose waits until deadline for the close frame of the peer. It
+// is read here unless a Read or drain is in progress, which gets it instead.
+func (conn *websocketMessageConn) waitPeerClose(deadline time.Time) {
+	conn.ws.SetReadDeadline(deadline)
+
+	if conn.readMutex.TryLock() {
+		if !conn.readClosed {
+			defer conn.readMutex.Unlock()
+
+			for {
+				_, _, err := conn.ws.NextReader()
+				if err != nil {
+					return
+				}
+			}
+		}
+		conn.readMutex.Unlock()
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-conn.peerClosed:
+	case <-timer.C:
+	}
+}
+
+func (conn *websocketMessageConn) LocalAddr() net.Addr {
+	return conn.ws.LocalAddr()
+}
+
+func (conn *websocketMessageConn) RemoteAddr() net.Addr {
+	return conn.ws.RemoteAddr()
+}
+
+func (conn *websocketMessageConn) SetDeadline(t time.Time) error {
+	err := conn.SetReadDeadline(t)
+	if err != nil {
+		return err
+	}
+
+	return conn.ws.SetWriteDeadline(t)
+}
+
+func (conn *websocketMessageConn) SetReadDeadline(t time.Time) error {
+	conn.deadlineMutex.Lock()
+	defer conn.deadlineMutex.Unlock()
+
+	conn.readDeadline = t
+	return conn.applyReadDeadline()
+}
+
+func (conn *websocketMessageConn) SetWriteDeadline(t time.Time) error {
+	return conn.ws.SetWriteDeadline(t)
+}
@@ -2,12 +2,16 @@ package listener
 
 import (
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/juju/errors"
 )
 
 func TestWebsocket(t *testing.T) {
@@ -68,3 +72,316 @@ func TestWebsocket(t *testing.T) {
 		t.Fatal("expect", "hello", "got", string(readbuf))
 	}
 }
+
+func TestWebsocketMessageConn(t *testing.T) {
+	var upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		Subprotocols:    []string{WebsocketSubprotocol},
+	}
+
+	accepts := make(chan *websocket.Conn, 16)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		accepts <- ws
+	}))
+	defer ts.Close()
+
+	ln := Websocket(accepts, func() error {
+		return nil
+	}, ts.Listener.Addr())
+
+	dialer := websocket.Dialer{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		Subprotocols:    []string{WebsocketSubprotocol},
+	}
+	ws, _, err := dialer.Dial(strings.Replace(ts.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.Subprotocol() != WebsocketSubprotocol {
+		t.Fatal("expect", WebsocketSubprotocol, "got", ws.Subprotocol())
+	}
+
+	conn := NewNegotiatedWebsocketConn(ws)
+	defer conn.Close()
+
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		conn.Write([]byte("hel"))
+		conn.Write([]byte("lo"))
+		conn.Close()
+	}()
+
+	data, err := ioutil.ReadAll(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "hello" {
+		t.Fatal("expect", "hello", "got", string(data))
+	}
+
+	server.Close()
+}
+
+func TestWebsocketMessageConn_CloseWrite(t *testing.T) {
+	var upgrader = websocket.Upgrader{
+		Subprotocols: []string{WebsocketSubprotocol},
+	}
+
+	accepts := make(chan *websocket.Conn, 16)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		accepts <- ws
+	}))
+	defer ts.Close()
+
+	dialer := websocket.Dialer{
+		Subprotocols: []string{WebsocketSubprotocol},
+	}
+	ws, _, err := dialer.Dial(strings.Replace(ts.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := NewNegotiatedWebsocketConn(ws)
+	defer conn.Close()
+	server := NewNegotiatedWebsocketConn(<-accepts)
+
+	go func() {
+		conn.Write([]byte("hello"))
+		conn.(interface {
+			CloseWrite() error
+		}).CloseWrite()
+	}()
+
+	data, err := ioutil.ReadAll(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatal("expect", "hello", "got", string(data))
+	}
+
+	// the other direction is still open, Close waits for conn to answer
+	server.Write([]byte("world"))
+	go server.Close()
+
+	data, err = ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "world" {
+		t.Fatal("expect", "world", "got", string(data))
+	}
+
+	_, err = conn.Write([]byte("late"))
+	if errors.Cause(err) != ErrWriteClosed {
+		t.Fatal("expect", ErrWriteClosed, "got", err)
+	}
+}
+
+// websocketPair returns a message conn and the raw client ws of its peer.
+func websocketPair(t *testing.T) (net.Conn, *websocket.Conn, func()) {
+	var upgrader = websocket.Upgrader{
+		Subprotocols: []string{WebsocketSubprotocol},
+	}
+
+	accepts := make(chan *websocket.Conn, 16)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		accepts <- ws
+	}))
+
+	dialer := websocket.Dialer{
+		Subprotocols: []string{WebsocketSubprotocol},
+	}
+	ws, _, err := dialer.Dial(strings.Replace(ts.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewNegotiatedWebsocketConn(<-accepts), ws, ts.Close
+}
+
+func TestWebsocketMessageConn_pongTimeout(t *testing.T) {
+	defer func(ping, close time.Duration) {
+		WebsocketPingInterval, WebsocketCloseTimeout = ping, close
+	}(WebsocketPingInterval, WebsocketCloseTimeout)
+	WebsocketPingInterval, WebsocketCloseTimeout = 50*time.Millisecond, 50*time.Millisecond
+
+	server, ws, stop := websocketPair(t)
+	defer stop()
+	defer server.Close()
+
+	// the peer reads but never answers pings
+	ws.SetPingHandler(func(string) error {
+		return nil
+	})
+	go io.Copy(ioutil.Discard, NewWebsocketConn(ws))
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := server.Read(make([]byte, 1))
+		errs <- err
+	}()
+
+	select {
+	case err := <-errs:
+		if e, ok := errors.Cause(err).(net.Error); !ok || !e.Timeout() {
+			t.Fatal("expect timeout got", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expect Read to fail without pongs")
+	}
+}
+
+func TestWebsocketMessageConn_Close(t *testing.T) {
+	defer func(close time.Duration) {
+		WebsocketCloseTimeout = close
+	}(WebsocketCloseTimeout)
+	WebsocketCloseTimeout = time.Second
+
+	server, ws, stop := websocketPair(t)
+	defer stop()
+
+	// the peer answers the close frame late
+	const delay = 200 * time.Millisecond
+	closed := make(chan error, 1)
+	go func() {
+		time.Sleep(delay)
+		_, _, err := ws.NextReader()
+		closed <- err
+	}()
+
+	start := time.Now()
+	server.Close()
+	if d := time.Since(start); d < delay || d >= WebsocketCloseTimeout {
+		t.Fatal("expect Close to wait for the answer of the peer, took", d)
+	}
+	if err := <-closed; !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatal("expect normal closure got", err)
+	}
+
+	// a peer that never answers holds Close up to WebsocketCloseTimeout
+	server, ws, stop = websocketPair(t)
+	defer stop()
+	defer ws.Close()
+
+	start = time.Now()
+	server.Close()
+	if d := time.Since(start); d < WebsocketCloseTimeout || d > 2*WebsocketCloseTimeout {
+		t.Fatal("expect Close to give up after", WebsocketCloseTimeout, "took", d)
+	}
+}
+
+func TestWebsocketMessageConn_drainAfterCloseWrite(t *testing.T) {
+	defer func(ping, close time.Duration) {
+		WebsocketPingInterval, WebsocketCloseTimeout = ping, close
+	}(WebsocketPingInterval, WebsocketCloseTimeout)
+	WebsocketPingInterval, WebsocketCloseTimeout = 50*time.Millisecond, 50*time.Millisecond
+
+	server, ws, stop := websocketPair(t)
+	defer stop()
+	conn := NewNegotiatedWebsocketConn(ws)
+	defer conn.Close()
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		data, err := ioutil.ReadAll(conn)
+		results <- result{data, err}
+	}()
+
+	CloseWrite(conn)
+	data, err := ioutil.ReadAll(server)
+	if err != nil || len(data) != 0 {
+		t.Fatal("expect EOF got", data, err)
+	}
+
+	// the pings of conn are still answered by server
+	time.Sleep(300 * time.Millisecond)
+
+	server.Write([]byte("late"))
+	go server.Close()
+
+	r := <-results
+	if r.err != nil || string(r.data) != "late" {
+		t.Fatal("expect", "late", "got", string(r.data), r.err)
+	}
+}
+
+func TestWebsocketMessageConn_SetReadDeadline(t *testing.T) {
+	defer func(ping, close time.Duration) {
+		WebsocketPingInterval, WebsocketCloseTimeout = ping, close
+	}(WebsocketPingInterval, WebsocketCloseTimeout)
+	WebsocketPingInterval, WebsocketCloseTimeout = 20*time.Millisecond, 50*time.Millisecond
+
+	server, ws, stop := websocketPair(t)
+	defer stop()
+	defer server.Close()
+
+	// the peer answers every ping, yet sends nothing
+	peer := NewWebsocketMessageConn(ws)
+	defer peer.Close()
+	go io.Copy(ioutil.Discard, peer)
+
+	server.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	errs := make(chan error, 1)
+	go func() {
+		_, err := server.Read(make([]byte, 1))
+		errs <- err
+	}()
+
+	select {
+	case err := <-errs:
+		if e, ok := errors.Cause(err).(net.Error); !ok || !e.Timeout() {
+			t.Fatal("expect timeout got", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expect Read to fail at the deadline despite pongs")
+	}
+}
+
+func TestWebsocketMessageConn_longText(t *testing.T) {
+	server, ws, stop := websocketPair(t)
+	defer stop()
+	defer server.Close()
+	defer ws.Close()
+
+	long := websocketCloseWrite + strings.Repeat("x", 1<<20)
+	go func() {
+		ws.WriteMessage(websocket.TextMessage, []byte(long))
+		ws.WriteMessage(websocket.BinaryMessage, []byte("hello"))
+	}()
+
+	data := make([]byte, 5)
+	_, err := io.ReadFull(server, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatal("expect", "hello", "got", string(data))
+	}
+}
@@ -0,0 +1,294 @@
+// Package metrics keeps counters, gauges and histograms and writes them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type family struct {
+	name  string
+	help  string
+	typ   string
+	write func(w io.Writer, name string)
+}
+
+type Registry struct {
+	mu       *sync.Mutex
+	families []*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		mu:       new(sync.Mutex),
+		families: nil,
+	}
+}
+
+func (r *Registry) register(name, help, typ string, write func(w io.Writer, name string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, f := range r.families {
+		if f.name == name {
+			panic("metric " + name + " registered twice")
+		}
+	}
+
+	r.families = append(r.families, &family{
+		name:  name,
+		help:  help,
+		typ:   typ,
+		write: write,
+	})
+}
+
+// Counter registers a counter with the label names labels.
+func (r *Registry) Counter(name, help string, labels ...string) *Vec {
+	vec := newVec(labels)
+	r.register(name, help, "counter", vec.write)
+	return vec
+}
+
+// Gauge registers a gauge with the label names labels.
+func (r *Registry) Gauge(name, help string, labels ...string) *Vec {
+	vec := newVec(labels)
+	r.register(name, help, "gauge", vec.write)
+	return vec
+}
+
+// GaugeFunc registers a gauge whose values are taken by fn at every
+// scrape. fn calls set once for every label values it reports.
+func (r *Registry) GaugeFunc(name, help string, labels []string,
+	fn func(set func(value float64, values ...string))) {
+	r.register(name, help, "gauge", func(w io.Writer, name string) {
+		fn(func(value float64, values ...string) {
+			if len(values) != len(labels) {
+				panic(fmt.Sprint("metric ", name, " expects ", len(labels), " label values"))
+			}
+			writeSample(w, name, labels, values, "", "", value)
+		})
+	})
+}
+
+// Histogram registers a histogram with the upper bounds buckets.
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	h := newHistogram(buckets)
+	r.register(name, help, "histogram", h.write)
+	return h
+}
+
+// WriteTo writes all metrics in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+
+	cw := &countWriter{
+		w: bufio.NewWriter(w),
+		n: 0,
+	}
+	for _, f := range families {
+		fmt.Fprintf(cw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(cw, "# TYPE %s %s\n", f.name, f.typ)
+		f.write(cw, f.name)
+	}
+
+	return cw.n, cw.w.Flush()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+type countWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Vec is a counter or gauge, one Value for every set of label values.
+type Vec struct {
+	labels []string
+
+	mu     *sync.Mutex
+	values map[string]*Value
+}
+
+func newVec(labels []string) *Vec {
+	return &Vec{
+		labels: labels,
+
+		mu:     new(sync.Mutex),
+		values: make(map[string]*Value),
+	}
+}
+
+// With returns the Value of the label values, in the order of the label
+// names given at registration.
+func (vec *Vec) With(values ...string) *Value {
+	if len(values) != len(vec.labels) {
+		panic(fmt.Sprint("expect ", len(vec.labels), " label values got ", len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+
+	v, exist := vec.values[key]
+	if !exist {
+		v = &Value{
+			v:      0,
+			values: values,
+		}
+		vec.values[key] = v
+	}
+	return v
+}
+
+func (vec *Vec) write(w io.Writer, name string) {
+	vec.mu.Lock()
+	keys := make([]string, 0, len(vec.values))
+	for k := range vec.values {
+		keys = append(keys, k)
+	}
+	values := make([]*Value, 0, len(keys))
+	sort.Strings(keys)
+	for _, k := range keys {
+		values = append(values, vec.values[k])
+	}
+	vec.mu.Unlock()
+
+	for _, v := range values {
+		writeSample(w, name, vec.labels, v.values, "", "", float64(v.Get()))
+	}
+}
+
+type Value struct {
+	v      int64 // atomic, first for 64-bit alignment
+	values []string
+}
+
+func (v *Value) Add(delta int64) {
+	atomic.AddInt64(&v.v, delta)
+}
+
+func (v *Value) Inc() {
+	v.Add(1)
+}
+
+func (v *Value) Dec() {
+	v.Add(-1)
+}
+
+func (v *Value) Set(n int64) {
+	atomic.StoreInt64(&v.v, n)
+}
+
+func (v *Value) Get() int64 {
+	return atomic.LoadInt64(&v.v)
+}
+
+type Histogram struct {
+	mu      *sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &Histogram{
+		mu:      new(sync.Mutex),
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+		sum:     0,
+		count:   0,
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) write(w io.Writer, name string) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		writeSample(w, name+"_bucket", nil, nil, "le", formatFloat(bound), float64(counts[i]))
+	}
+	writeSample(w, name+"_bucket", nil, nil, "le", "+Inf", float64(count))
+	writeSample(w, name+"_sum", nil, nil, "", "", sum)
+	writeSample(w, name+"_count", nil, nil, "", "", float64(count))
+}
+
+// writeSample writes a sample line, extra is an additional label like le.
+func writeSample(w io.Writer, name string, labels, values []string, extra, extraValue string, value float64) {
+	pairs := make([]string, 0, len(labels)+1)
+	for i, label := range labels {
+		pairs = append(pairs, label+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra+`="`+extraValue+`"`)
+	}
+
+	if len(pairs) == 0 {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+		return
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(value))
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
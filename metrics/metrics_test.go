@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	bytes_total := r.Counter("test_bytes_total", "Bytes sent.", "service")
+	bytes_total.With("web").Add(10)
+	bytes_total.With(`a"b`).Inc()
+
+	sessions := r.Gauge("test_sessions", "Open sessions.")
+	sessions.With().Inc()
+	sessions.With().Inc()
+	sessions.With().Dec()
+
+	r.GaugeFunc("test_streams", "Open streams.", []string{"service"}, func(set func(float64, ...string)) {
+		set(3, "web")
+	})
+
+	latency := r.Histogram("test_seconds", "Latency.", []float64{1, 0.1})
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(2)
+
+	buf := &bytes.Buffer{}
+	n, err := r.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatal("expect", buf.Len(), "got", n)
+	}
+
+	expect := `# HELP test_bytes_total Bytes sent.
+# TYPE test_bytes_total counter
+test_bytes_total{service="a\"b"} 1
+test_bytes_total{service="web"} 10
+# HELP test_sessions Open sessions.
+# TYPE test_sessions gauge
+test_sessions 1
+# HELP test_streams Open streams.
+# TYPE test_streams gauge
+test_streams{service="web"} 3
+# HELP test_seconds Latency.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 2.55
+test_seconds_count 3
+`
+	if buf.String() != expect {
+		t.Fatal("expect", expect, "got", buf.String())
+	}
+}
+
+func TestRegistry_register(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("test_total", "")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic")
+		}
+	}()
+	r.Gauge("test_total", "")
+}
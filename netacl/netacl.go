@@ -0,0 +1,307 @@
+package netacl
+
+import (
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+var (
+	ErrDenied = errors.New("destination denied")
+)
+
+// Config is the serializable form of a Policy.
+//
+// Allow and Deny entries look like HOST[:PORTS]. HOST is a CIDR range,
+// an IP, or a hostname pattern such as "*.example.test" ("*" matches
+// every host). PORTS is a port, a range like "8000-8999" or "*", all
+// ports when omitted. IPv6 hosts are bracketed: "[fd00::/8]:22".
+type Config struct {
+	Allow []string
+	Deny  []string
+
+	AllowLoopback  bool
+	AllowLinkLocal bool
+
+	// LookupIP resolves hostnames, net.LookupIP if nil.
+	LookupIP func(host string) ([]net.IP, error) `json:"-"`
+}
+
+// Policy decides which destinations may be dialed. Deny entries win over
+// Allow entries, an empty Allow list allows every destination that is
+// not denied. Loopback, link-local and unspecified addresses are denied
+// unless allowed by Config.
+type Policy struct {
+	mu  *sync.RWMutex
+	set *ruleset
+}
+
+// ruleset is a compiled Config.
+type ruleset struct {
+	config Config
+	allow  []*rule
+	deny   []*rule
+}
+
+// Default denies loopback and link-local addresses and allows the rest.
+var Default = func() *Policy {
+	policy, err := New(Config{})
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}()
+
+func New(config Config) (*Policy, error) {
+	set, err := compile(config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return &Policy{
+		mu:  new(sync.RWMutex),
+		set: set,
+	}, nil
+}
+
+// Replace validates config and swaps it in as a whole. Destinations
+// already dialed stay connected.
+func (policy *Policy) Replace(config Config) error {
+	set, err := compile(config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	policy.mu.Lock()
+	defer policy.mu.Unlock()
+
+	policy.set = set
+	return nil
+}
+
+// Set swaps in the destinations of other, a policy checked beforehand
+// with New.
+func (policy *Policy) Set(other *Policy) {
+	other.mu.RLock()
+	set := other.set
+	other.mu.RUnlock()
+
+	policy.mu.Lock()
+	defer policy.mu.Unlock()
+
+	policy.set = set
+}
+
+func (policy *Policy) ruleset() *ruleset {
+	policy.mu.RLock()
+	defer policy.mu.RUnlock()
+
+	return policy.set
+}
+
+func compile(config Config) (*ruleset, error) {
+	set := &ruleset{
+		config: config,
+	}
+
+	for _, entry := range config.Allow {
+		r, err := parseRule(entry)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		set.allow = append(set.allow, r)
+	}
+
+	for _, entry := range config.Deny {
+		r, err := parseRule(entry)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		set.deny = append(set.deny, r)
+	}
+
+	return set, nil
+}
+
+type rule struct {
+	host    string
+	ipnet   *net.IPNet
+	minPort int
+	maxPort int
+}
+
+func parseRule(entry string) (*rule, error) {
+	host, ports := entry, "*"
+	if strings.HasPrefix(entry, "[") {
+		end := strings.Index(entry, "]")
+		if end < 0 {
+			return nil, errors.Errorf("rule %q: missing ']'", entry)
+		}
+		host = entry[1:end]
+		if rest := entry[end+1:]; rest != "" {
+			if rest[0] != ':' {
+				return nil, errors.Errorf("rule %q: expect ':' after ']'", entry)
+			}
+			ports = rest[1:]
+		}
+	} else if i := strings.LastIndex(entry, ":"); i >= 0 {
+		host, ports = entry[:i], entry[i+1:]
+	}
+
+	r := &rule{
+		host:    strings.ToLower(host),
+		minPort: 0,
+		maxPort: 65535,
+	}
+
+	if strings.Contains(host, "/") {
+		_, ipnet, err := net.ParseCIDR(host)
+		if err != nil {
+			return nil, errors.Annotatef(err, "rule %q", entry)
+		}
+		r.ipnet = ipnet
+	} else if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		bits := 8 * len(ip)
+		r.ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	} else if _, err := path.Match(r.host, ""); err != nil {
+		return nil, errors.Annotatef(err, "rule %q", entry)
+	}
+
+	if ports != "*" && ports != "" {
+		min, max := ports, ports
+		if i := strings.Index(ports, "-"); i >= 0 {
+			min, max = ports[:i], ports[i+1:]
+		}
+
+		var err error
+		r.minPort, err = strconv.Atoi(min)
+		if err != nil {
+			return nil, errors.Annotatef(err, "rule %q", entry)
+		}
+		r.maxPort, err = strconv.Atoi(max)
+		if err != nil {
+			return nil, errors.Annotatef(err, "rule %q", entry)
+		}
+		if r.minPort > r.maxPort {
+			return nil, errors.Errorf("rule %q: bad port range", entry)
+		}
+	}
+
+	return r, nil
+}
+
+func (r *rule) match(host string, ip net.IP, port int) bool {
+	if port < r.minPort || port > r.maxPort {
+		return false
+	}
+
+	if r.ipnet != nil {
+		return ip != nil && r.ipnet.Contains(ip)
+	}
+
+	ok, _ := path.Match(r.host, strings.ToLower(host))
+	return ok
+}
+
+// check reports why ip, resolved from host, may not be dialed on port.
+func (set *ruleset) check(host string, ip net.IP, port int) error {
+	switch {
+	case ip.IsUnspecified():
+		return errors.Annotatef(ErrDenied, "unspecified address %s", ip)
+	case ip.IsLoopback() && !set.config.AllowLoopback:
+		return errors.Annotatef(ErrDenied, "loopback address %s", ip)
+	case (ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()) && !set.config.AllowLinkLocal:
+		return errors.Annotatef(ErrDenied, "link-local address %s", ip)
+	}
+
+	for _, r := range set.deny {
+		if r.match(host, ip, port) {
+			return errors.Annotatef(ErrDenied, "%s port %d is in deny list", ip, port)
+		}
+	}
+
+	if len(set.allow) == 0 {
+		return nil
+	}
+	for _, r := range set.allow {
+		if r.match(host, ip, port) {
+			return nil
+		}
+	}
+	return errors.Annotatef(ErrDenied, "%s port %d is not in allow list", ip, port)
+}
+
+// Resolve checks network and address against policy and returns the
+// address, with its host resolved to a permitted IP, that should be dialed.
+// Dialing the IP instead of the name keeps DNS changes from bypassing the check.
+func (policy *Policy) Resolve(network, address string) (string, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return "", errors.Annotatef(ErrDenied, "network %q", network)
+	}
+
+	set := policy.ruleset()
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	port, err := net.LookupPort(network, portStr)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		lookup := set.config.LookupIP
+		if lookup == nil {
+			lookup = net.LookupIP
+		}
+		ips, err = lookup(host)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+	}
+
+	var last error
+	for _, ip := range ips {
+		if network == "tcp4" && ip.To4() == nil || network == "tcp6" && ip.To4() != nil {
+			continue
+		}
+
+		err := set.check(host, ip, port)
+		if err != nil {
+			last = err
+			continue
+		}
+
+		return net.JoinHostPort(ip.String(), strconv.Itoa(port)), nil
+	}
+
+	if last == nil {
+		last = errors.Errorf("no %s address for %q", network, host)
+	}
+	return "", errors.Annotatef(last, "%s", address)
+}
+
+// Dial connects to address if policy permits it.
+func (policy *Policy) Dial(network, address string) (net.Conn, error) {
+	resolved, err := policy.Resolve(network, address)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	conn, err := net.Dial(network, resolved)
+	return conn, errors.Trace(err)
+}
@@ -0,0 +1,157 @@
+package netacl
+
+import (
+	"net"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestPolicy_Resolve(t *testing.T) {
+	policy, err := New(Config{
+		Allow: []string{
+			"10.0.0.0/8:22",
+			"192.168.1.10:8000-8999",
+			"[fd00::/8]:*",
+			"*.example.test",
+		},
+		Deny: []string{
+			"10.0.0.13",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		address string
+		allow   bool
+	}{
+		{"10.1.2.3:22", true},
+		{"10.1.2.3:80", false},
+		{"10.0.0.13:22", false},
+		{"192.168.1.10:8080", true},
+		{"192.168.1.10:9000", false},
+		{"[fd00::1]:443", true},
+		{"[fe80::1]:443", false},
+		{"8.8.8.8:53", false},
+		{"127.0.0.1:22", false},
+		{"169.254.169.254:80", false},
+		{"0.0.0.0:22", false},
+	}
+	for _, c := range cases {
+		_, err := policy.Resolve("tcp", c.address)
+		if (err == nil) != c.allow {
+			t.Fatal(c.address, "want allow", c.allow, "got", err)
+		}
+		if err != nil && errors.Cause(err) != ErrDenied {
+			t.Fatal(c.address, errors.Cause(err), "want", ErrDenied)
+		}
+	}
+
+	_, err = policy.Resolve("udp", "10.1.2.3:22")
+	if errors.Cause(err) != ErrDenied {
+		t.Fatal(errors.Cause(err), "want", ErrDenied)
+	}
+}
+
+func TestPolicy_default(t *testing.T) {
+	_, err := Default.Resolve("tcp", "localhost:80")
+	if errors.Cause(err) != ErrDenied {
+		t.Fatal(errors.Cause(err), "want", ErrDenied)
+	}
+
+	resolved, err := Default.Resolve("tcp", "192.0.2.1:http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != "192.0.2.1:80" {
+		t.Fatal(resolved, "want", "192.0.2.1:80")
+	}
+
+	policy, err := New(Config{
+		AllowLoopback: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	conn, err := policy.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestNew(t *testing.T) {
+	for _, entry := range []string{
+		"10.0.0.0/33",
+		"[fd00::/8",
+		"host:abc",
+		"host:90-80",
+		"[abc",
+	} {
+		_, err := New(Config{
+			Allow: []string{entry},
+		})
+		if err == nil {
+			t.Fatal(entry, "expect err")
+		}
+	}
+}
+
+func TestPolicy_Replace(t *testing.T) {
+	policy, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = policy.Resolve("tcp", "192.0.2.1:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = policy.Replace(Config{
+		Deny: []string{"[bad"},
+	})
+	if err == nil {
+		t.Fatal("expect error got", nil)
+	}
+
+	err = policy.Replace(Config{
+		Deny: []string{"192.0.2.0/24"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = policy.Resolve("tcp", "192.0.2.1:22")
+	if errors.Cause(err) != ErrDenied {
+		t.Fatal(errors.Cause(err), "want", ErrDenied)
+	}
+}
+
+func TestPolicy_Set(t *testing.T) {
+	policy, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := New(Config{
+		Deny: []string{"192.0.2.0/24"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy.Set(next)
+	_, err = policy.Resolve("tcp", "192.0.2.1:22")
+	if errors.Cause(err) != ErrDenied {
+		t.Fatal("expect", ErrDenied, "got", errors.Cause(err))
+	}
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"encoding/json"
+	"net"
 
 	"github.com/juju/errors"
 	"github.com/service-exposer/exposer/protocal"
@@ -10,24 +11,75 @@ import (
 )
 
 const (
-	CMD_AUTH       = "auth"
-	CMD_AUTH_REPLY = "auth:reply"
+	CMD_AUTH           = "auth"
+	CMD_AUTH_CHALLENGE = "auth:challenge"
+	CMD_AUTH_RESPONSE  = "auth:response"
+	CMD_AUTH_REPLY     = "auth:reply"
 )
 
+// Versions of the CMD_AUTH handshake
+const (
+	// VersionLegacy sends the key in plaintext
+	VersionLegacy = 0
+	// VersionChallenge answers a server nonce with an HMAC of the key
+	VersionChallenge = 1
+)
+
+// DefaultIdentity is assumed for VersionChallenge clients that don't name one.
+const DefaultIdentity = "default"
+
 var (
-	ErrForbiddenKey = errors.New("forbidden key")
+	ErrForbiddenKey       = errors.New("forbidden key")
+	ErrLegacyAuthDisabled = errors.New("plaintext key auth is disabled")
+	ErrNotSupportedVer    = errors.New("not supported auth version")
 )
 
 type Reply struct {
 	OK  bool
 	Err string
+
+	// Proof proves to VersionChallenge clients that the server knows the key.
+	Proof string `json:",omitempty"`
 }
 
 type AuthReq struct {
-	Key string
+	Version  int    `json:",omitempty"`
+	Key      string `json:",omitempty"` // VersionLegacy only
+	Identity string `json:",omitempty"`
+	Nonce    string `json:",omitempty"`
+}
+
+type Config struct {
+	// Route configures the routes opened after authentication.
+	Route route.Config
+
+	// Authenticate returns the identity key belongs to.
+	// It serves VersionLegacy clients.
+	Authenticate func(key string) (identity string, allow bool)
+
+	// Key returns the key of identity. It serves VersionChallenge clients.
+	Key func(identity string) (key string, ok bool)
+
+	// AllowLegacy accepts VersionLegacy clients.
+	AllowLegacy bool
+
+	// Denied, when set, is told why a client was refused.
+	Denied func(err error)
 }
 
 func ServerSide(router *service.Router, authFn func(key string) (allow bool)) protocal.HandshakeHandleFunc {
+	return ServerSideWithConfig(&Config{
+		Route: route.Config{
+			Router: router,
+		},
+		Authenticate: func(key string) (string, bool) {
+			return "", authFn(key)
+		},
+		AllowLegacy: true,
+	})
+}
+
+func ServerSideWithConfig(config *Config) protocal.HandshakeHandleFunc {
 	return func(proto *protocal.Protocal, cmd string, details []byte) error {
 		switch cmd {
 		case CMD_AUTH:
@@ -37,32 +89,26 @@ func ServerSide(router *service.Router, authFn func(key string) (allow bool)) pr
 				return errors.Trace(err)
 			}
 
-			if !authFn(req.Key) {
-				proto.Reply(CMD_AUTH_REPLY, &Reply{
-					OK:  false,
-					Err: ErrForbiddenKey.Error(),
-				})
-
-				return errors.Annotate(err, "auth")
-			}
+			switch req.Version {
+			case VersionLegacy:
+				if !config.AllowLegacy {
+					return config.forbid(proto, ErrLegacyAuthDisabled)
+				}
 
-			err = proto.Reply(CMD_AUTH_REPLY, &Reply{
-				OK: true,
-			})
-			if err != nil {
-				return errors.Trace(err)
-			}
+				identity, allow := config.Authenticate(req.Key)
+				if !allow {
+					return config.forbid(proto, ErrForbiddenKey)
+				}
 
-			session := proto.Multiplex(false)
-			for {
-				conn, err := session.Accept()
-				if err != nil {
-					return errors.Trace(err)
+				return config.serve(proto, identity, "")
+			case VersionChallenge:
+				if req.Identity == "" {
+					req.Identity = DefaultIdentity
 				}
 
-				proto_next := protocal.NewProtocalWithParent(proto, conn)
-				proto_next.On = route.ServerSide(router)
-				go proto_next.Handle()
+				return config.challenge(proto, req)
+			default:
+				return config.forbid(proto, errors.Annotatef(ErrNotSupportedVer, "%d", req.Version))
 			}
 		}
 
@@ -70,11 +116,55 @@ func ServerSide(router *service.Router, authFn func(key string) (allow bool)) pr
 	}
 }
 
+func (config *Config) forbid(proto *protocal.Protocal, err error) error {
+	if config.Denied != nil {
+		config.Denied(err)
+	}
+
+	proto.Reply(CMD_AUTH_REPLY, &Reply{
+		OK:  false,
+		Err: err.Error(),
+	})
+
+	return errors.Annotate(err, "auth")
+}
+
+// serve accepts the routes of an authenticated identity.
+func (config *Config) serve(proto *protocal.Protocal, identity, proof string) error {
+	proto.SetIdentity(identity)
+
+	err := proto.Reply(CMD_AUTH_REPLY, &Reply{
+		OK:    true,
+		Proof: proof,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	session := proto.Multiplex(false)
+	for {
+		conn, err := session.Accept()
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		proto_next := protocal.NewProtocalWithParent(proto, conn)
+		proto_next.On = route.ServerSideWithConfig(&config.Route)
+		go proto_next.Handle()
+	}
+}
+
 type NextRoute struct {
 	Req        route.RouteReq
 	HandleFunc protocal.HandshakeHandleFunc
 	Cmd        string
 	Details    interface{}
+
+	// Detached routes fail alone instead of shutting the session down.
+	Detached bool
+	// Opened, when set, is called with the route's protocal and stream
+	// before its handshake starts.
+	Opened func(proto *protocal.Protocal, conn net.Conn)
 }
 
 func ClientSide(routes <-chan NextRoute) protocal.HandshakeHandleFunc {
@@ -100,8 +190,16 @@ func ClientSide(routes <-chan NextRoute) protocal.HandshakeHandleFunc {
 				}
 
 				r := nr
-				proto_next := protocal.NewProtocalWithParent(proto, conn)
+				var proto_next *protocal.Protocal
+				if r.Detached {
+					proto_next = protocal.NewProtocal(conn)
+				} else {
+					proto_next = protocal.NewProtocalWithParent(proto, conn)
+				}
 				proto_next.On = route.ClientSide(r.HandleFunc, r.Cmd, r.Details)
+				if r.Opened != nil {
+					r.Opened(proto_next, conn)
+				}
 				go proto_next.Request(route.CMD_ROUTE, &r.Req)
 			}
 
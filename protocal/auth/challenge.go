@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/protocal"
+)
+
+var (
+	ErrServerProof = errors.New("server failed to prove the key")
+)
+
+// Challenge is sent by the server in reply to a VersionChallenge CMD_AUTH.
+// The server proves it knows the key in Reply.Proof, only once the client
+// did, so the proof can't be used to guess the key offline.
+type Challenge struct {
+	Nonce string
+}
+
+// Response answers a Challenge.
+type Response struct {
+	MAC string
+}
+
+const (
+	labelClient = "exposer auth client"
+	labelServer = "exposer auth server"
+)
+
+func newNonce() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func mac(key, label, identity, nonce1, nonce2 string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	for _, s := range []string{label, identity, nonce1, nonce2} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (config *Config) challenge(proto *protocal.Protocal, req AuthReq) error {
+	var (
+		key   string
+		known bool
+	)
+	if config.Key != nil {
+		key, known = config.Key(req.Identity)
+	}
+	if !known {
+		// answer an unknown identity like a wrong key
+		key = newNonce()
+	}
+
+	nonce := newNonce()
+	err := proto.Reply(CMD_AUTH_CHALLENGE, &Challenge{
+		Nonce: nonce,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	proto.On = func(proto *protocal.Protocal, cmd string, details []byte) error {
+		switch cmd {
+		case CMD_AUTH_RESPONSE:
+			var resp Response
+			err := json.Unmarshal(details, &resp)
+			if err != nil {
+				return errors.Trace(err)
+			}
+
+			expect := mac(key, labelClient, req.Identity, nonce, req.Nonce)
+			if !known || !hmac.Equal([]byte(resp.MAC), []byte(expect)) {
+				return config.forbid(proto, ErrForbiddenKey)
+			}
+
+			return config.serve(proto, req.Identity, mac(key, labelServer, req.Identity, req.Nonce, nonce))
+		}
+
+		return errors.New("unknow cmd: " + cmd)
+	}
+	return nil
+}
+
+// Credential authenticates a client by challenge-response,
+// the key itself never leaves the client.
+type Credential struct {
+	Identity string
+	Key      string
+
+	// VerifyServer makes the client check that the server knows Key too.
+	VerifyServer bool
+}
+
+// ClientSideWithCredential returns the CMD_AUTH request that starts the
+// challenge-response handshake for cred and the handler that finishes it.
+func ClientSideWithCredential(cred Credential, routes <-chan NextRoute) (*AuthReq, protocal.HandshakeHandleFunc) {
+	req := &AuthReq{
+		Version:  VersionChallenge,
+		Identity: cred.Identity,
+		Nonce:    newNonce(),
+	}
+	if req.Identity == "" {
+		req.Identity = DefaultIdentity
+	}
+
+	var (
+		nonce       string
+		handleReply = ClientSide(routes)
+	)
+
+	return req, func(proto *protocal.Protocal, cmd string, details []byte) error {
+		switch cmd {
+		case CMD_AUTH_CHALLENGE:
+			var challenge Challenge
+			err := json.Unmarshal(details, &challenge)
+			if err != nil {
+				return errors.Trace(err)
+			}
+
+			nonce = challenge.Nonce
+			return proto.Reply(CMD_AUTH_RESPONSE, &Response{
+				MAC: mac(cred.Key, labelClient, req.Identity, challenge.Nonce, req.Nonce),
+			})
+		case CMD_AUTH_REPLY:
+			var reply Reply
+			err := json.Unmarshal(details, &reply)
+			if err != nil {
+				return errors.Trace(err)
+			}
+
+			if reply.OK && cred.VerifyServer {
+				expect := mac(cred.Key, labelServer, req.Identity, req.Nonce, nonce)
+				if nonce == "" || !hmac.Equal([]byte(reply.Proof), []byte(expect)) {
+					return errors.Trace(ErrServerProof)
+				}
+			}
+		}
+
+		return handleReply(proto, cmd, details)
+	}
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/listener"
+	"github.com/service-exposer/exposer/protocal"
+	"github.com/service-exposer/exposer/protocal/keepalive"
+	"github.com/service-exposer/exposer/protocal/route"
+	"github.com/service-exposer/exposer/service"
+)
+
+func Test_authChallenge(t *testing.T) {
+	ln, dial := listener.Pipe()
+
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.On = ServerSideWithConfig(&Config{
+			Route: route.Config{
+				Router: service.NewRouter(),
+			},
+			Authenticate: func(key string) (string, bool) {
+				return "legacy", key == "test"
+			},
+			Key: func(identity string) (string, bool) {
+				if identity == DefaultIdentity {
+					return "test", true
+				}
+				return "", false
+			},
+			AllowLegacy: false,
+		})
+		return proto
+	})
+
+	authenticate := func(cred Credential) error {
+		conn, err := dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		nextRoutes := make(chan NextRoute)
+
+		proto := protocal.NewProtocal(conn)
+		req, handlefn := ClientSideWithCredential(cred, nextRoutes)
+		proto.On = handlefn
+		go proto.Request(CMD_AUTH, req)
+
+		established := make(chan struct{})
+		go func() {
+			nextRoutes <- NextRoute{
+				Req: route.RouteReq{
+					Type: route.KeepAlive,
+				},
+				HandleFunc: keepalive.ClientSide(0, 0),
+				Cmd:        keepalive.CMD_PING,
+			}
+			close(established)
+		}()
+
+		select {
+		case <-established:
+			return nil
+		case <-waitChan(proto):
+			return proto.Wait()
+		}
+	}
+
+	err := authenticate(Credential{
+		Key:          "test",
+		VerifyServer: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = authenticate(Credential{
+		Key: "wrong",
+	})
+	if err == nil || !strings.Contains(err.Error(), ErrForbiddenKey.Error()) {
+		t.Fatal(err, "want", ErrForbiddenKey)
+	}
+
+	err = authenticate(Credential{
+		Identity: "nobody",
+		Key:      "test",
+	})
+	if err == nil || !strings.Contains(err.Error(), ErrForbiddenKey.Error()) {
+		t.Fatal(err, "want", ErrForbiddenKey)
+	}
+
+	// the server proves nothing before the client did
+	err = authenticate(Credential{
+		Key:          "wrong",
+		VerifyServer: true,
+	})
+	if err == nil || !strings.Contains(err.Error(), ErrForbiddenKey.Error()) {
+		t.Fatal(err, "want", ErrForbiddenKey)
+	}
+
+	// legacy clients are rejected unless Config.AllowLegacy
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto := protocal.NewProtocal(conn)
+	proto.On = ClientSide(make(chan NextRoute))
+	go proto.Request(CMD_AUTH, &AuthReq{
+		Key: "test",
+	})
+	err = proto.Wait()
+	if err == nil || !strings.Contains(err.Error(), ErrLegacyAuthDisabled.Error()) {
+		t.Fatal(err, "want", ErrLegacyAuthDisabled)
+	}
+}
+
+func Test_authChallenge_serverProof(t *testing.T) {
+	ln, dial := listener.Pipe()
+
+	// accepts any client without knowing the key
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.On = func(proto *protocal.Protocal, cmd string, details []byte) error {
+			switch cmd {
+			case CMD_AUTH:
+				return proto.Reply(CMD_AUTH_CHALLENGE, &Challenge{
+					Nonce: newNonce(),
+				})
+			case CMD_AUTH_RESPONSE:
+				return proto.Reply(CMD_AUTH_REPLY, &Reply{
+					OK:    true,
+					Proof: "forged",
+				})
+			}
+			return errors.New("unknow cmd: " + cmd)
+		}
+		return proto
+	})
+
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proto := protocal.NewProtocal(conn)
+	req, handlefn := ClientSideWithCredential(Credential{
+		Key:          "test",
+		VerifyServer: true,
+	}, make(chan NextRoute))
+	proto.On = handlefn
+	go proto.Request(CMD_AUTH, req)
+
+	err = proto.Wait()
+	if errors.Cause(err) != ErrServerProof {
+		t.Fatal("expect", ErrServerProof, "got", err)
+	}
+}
+
+func waitChan(proto *protocal.Protocal) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		proto.Wait()
+		close(done)
+	}()
+	return done
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const labelAPI = "exposer api"
+
+// APITokenMaxAge is how long, either way from the server clock, an API
+// token stays valid.
+const APITokenMaxAge = 5 * time.Minute
+
+// APIToken returns a token that authenticates the HTTP API request method
+// path with key at now, the key itself never leaves the client. The token
+// is good for this request only, see APITokens.
+func APIToken(key, method, path string, now time.Time) string {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	nonce := newNonce()
+	return ts + "." + nonce + "." + mac(key, labelAPI, method+" "+path, ts, nonce)
+}
+
+// VerifyAPIToken reports whether token was made by APIToken with key for
+// method path no more than APITokenMaxAge from now.
+func VerifyAPIToken(key, method, path, token string, now time.Time) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	ts, nonce, sum := parts[0], parts[1], parts[2]
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(unix, 0))
+	if age > APITokenMaxAge || age < -APITokenMaxAge {
+		return false
+	}
+
+	return hmac.Equal([]byte(sum), []byte(mac(key, labelAPI, method+" "+path, ts, nonce)))
+}
+
+// APITokens remembers the API tokens used until they expire, so none of
+// them is accepted twice.
+type APITokens struct {
+	mu   *sync.Mutex
+	used map[string]time.Time // token -> expiry
+}
+
+func NewAPITokens() *APITokens {
+	return &APITokens{
+		mu:   new(sync.Mutex),
+		used: make(map[string]time.Time),
+	}
+}
+
+// Use reports whether token, one VerifyAPIToken accepted, was not used
+// before, and remembers it.
+func (tokens *APITokens) Use(token string, now time.Time) bool {
+	tokens.mu.Lock()
+	defer tokens.mu.Unlock()
+
+	for used, expiry := range tokens.used {
+		if now.After(expiry) {
+			delete(tokens.used, used)
+		}
+	}
+	if _, ok := tokens.used[token]; ok {
+		return false
+	}
+
+	// a verified token is valid until at most twice the max age from now
+	tokens.used[token] = now.Add(2 * APITokenMaxAge)
+	return true
+}
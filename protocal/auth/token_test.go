@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyAPIToken(t *testing.T) {
+	now := time.Now()
+	token := APIToken("test", "GET", "/api/sessions", now)
+
+	if !VerifyAPIToken("test", "GET", "/api/sessions", token, now.Add(time.Minute)) {
+		t.Fatal("expect token accepted")
+	}
+	if other := APIToken("test", "GET", "/api/sessions", now); other == token {
+		t.Fatal("expect a new nonce for every token")
+	}
+
+	for _, c := range []struct {
+		key    string
+		method string
+		path   string
+		token  string
+		now    time.Time
+	}{
+		{"wrong", "GET", "/api/sessions", token, now},
+		{"test", "GET", "/api/sessions", "test", now},
+		{"test", "GET", "/api/sessions", token + "0", now},
+		{"test", "DELETE", "/api/sessions", token, now},
+		{"test", "GET", "/api/sessions/1", token, now},
+		{"test", "GET", "/api/sessions", token, now.Add(APITokenMaxAge + time.Second)},
+		{"test", "GET", "/api/sessions", token, now.Add(-APITokenMaxAge - time.Second)},
+	} {
+		if VerifyAPIToken(c.key, c.method, c.path, c.token, c.now) {
+			t.Fatal("expect", c.token, "refused with key", c.key, "for", c.method, c.path, "at", c.now)
+		}
+	}
+}
+
+func TestAPITokens_Use(t *testing.T) {
+	now := time.Now()
+	tokens := NewAPITokens()
+
+	if !tokens.Use("a", now) {
+		t.Fatal("expect first use accepted")
+	}
+	if tokens.Use("a", now.Add(APITokenMaxAge)) {
+		t.Fatal("expect second use refused")
+	}
+	if !tokens.Use("b", now) {
+		t.Fatal("expect other token accepted")
+	}
+
+	tokens.Use("c", now.Add(2*APITokenMaxAge+time.Second))
+	if len(tokens.used) != 1 {
+		t.Fatal("expect expired tokens forgotten got", tokens.used)
+	}
+}
@@ -3,6 +3,7 @@ package expose
 import (
 	"encoding/json"
 	"net"
+	"time"
 
 	"github.com/juju/errors"
 	"github.com/service-exposer/exposer/protocal"
@@ -14,17 +15,56 @@ const (
 	CMD_EXPOSE_REPLY = "expose:reply"
 )
 
+var (
+	ErrServiceRemoved = errors.New("service removed while exposing")
+)
+
 type Reply struct {
 	OK  bool
 	Err string
+
+	RemotePort int `json:",omitempty"` // port opened for ExposeReq.RemotePort
+
+	// Token reclaims the name while the daemon keeps it reserved after
+	// the session ends, see ExposeReq.Token.
+	Token string `json:",omitempty"`
 }
 
 type ExposeReq struct {
 	Name string
 	Attr service.Attribute
+
+	// Group, when set, joins the service group Name balanced by it
+	// instead of claiming Name exclusively.
+	Group service.Balance `json:",omitempty"`
+
+	// RemotePort, when set, asks the daemon to listen on this TCP port
+	// for the service, 0 picks a free port.
+	RemotePort *int `json:",omitempty"`
+
+	// Token, the one of an earlier Reply, reclaims Name while it is
+	// reserved for the session that ended.
+	Token string `json:",omitempty"`
+
+	// Limits bound the streams of the service, capped by the limits of
+	// the router. For a group the member that joined last sets them.
+	Limits *service.Limits `json:",omitempty"`
+}
+
+// limits returns the limits of the service of req.
+func (req *ExposeReq) limits() service.Limits {
+	var limits service.Limits
+	if req.Limits != nil {
+		limits = *req.Limits
+	}
+	return limits
 }
 
 func ServerSide(router *service.Router) protocal.HandshakeHandleFunc {
+	return ServerSideWithConfig(router, &Config{})
+}
+
+func ServerSideWithConfig(router *service.Router, config *Config) protocal.HandshakeHandleFunc {
 	return func(proto *protocal.Protocal, cmd string, details []byte) error {
 		switch cmd {
 		case CMD_EXPOSE:
@@ -34,7 +74,20 @@ func ServerSide(router *service.Router) protocal.HandshakeHandleFunc {
 				return errors.Trace(err)
 			}
 
-			err = router.Prepare(req.Name)
+			if req.Group != "" {
+				if req.RemotePort != nil {
+					err := errors.Trace(ErrRemotePortGroup)
+					proto.Reply(CMD_EXPOSE_REPLY, &Reply{
+						OK:  false,
+						Err: err.Error(),
+					})
+
+					return err
+				}
+				return exposeGroup(router, proto, &req)
+			}
+
+			token, err := router.Claim(req.Name, req.Token)
 			if err != nil {
 				proto.Reply(CMD_EXPOSE_REPLY, &Reply{
 					OK:  false,
@@ -43,14 +96,61 @@ func ServerSide(router *service.Router) protocal.HandshakeHandleFunc {
 
 				return errors.Trace(err)
 			}
-			defer router.Remove(req.Name)
+			// the name is only reserved once the exposer was told the token
+			grace := time.Duration(0)
+			defer func() {
+				router.Release(req.Name, token, grace)
+			}()
+
+			err = router.ClaimHostnames(req.Name, req.Attr.HTTP.Hostnames)
+			if err != nil {
+				proto.Reply(CMD_EXPOSE_REPLY, &Reply{
+					OK:  false,
+					Err: err.Error(),
+				})
+
+				return errors.Trace(err)
+			}
+
+			var (
+				remoteln   net.Listener
+				remotePort int
+			)
+			if req.RemotePort != nil {
+				remoteln, err = config.listen(*req.RemotePort)
+				if err != nil {
+					proto.Reply(CMD_EXPOSE_REPLY, &Reply{
+						OK:  false,
+						Err: err.Error(),
+					})
+
+					return errors.Trace(err)
+				}
+				defer remoteln.Close()
+				remotePort = remoteln.Addr().(*net.TCPAddr).Port
+			}
+			// the name may be removed by now, by the API or a shutdown
+			s := router.Get(req.Name)
+			if s == nil {
+				err := errors.Annotatef(ErrServiceRemoved, "%q", req.Name)
+				proto.Reply(CMD_EXPOSE_REPLY, &Reply{
+					OK:  false,
+					Err: err.Error(),
+				})
+
+				return errors.Trace(err)
+			}
+			s.SetLimits(req.limits())
 
 			err = proto.Reply(CMD_EXPOSE_REPLY, &Reply{
-				OK: true,
+				OK:         true,
+				RemotePort: remotePort,
+				Token:      token,
 			})
 			if err != nil {
 				return errors.Trace(err)
 			}
+			grace = config.Grace
 
 			session := proto.Multiplex(true)
 
@@ -58,10 +158,14 @@ func ServerSide(router *service.Router) protocal.HandshakeHandleFunc {
 			if !ok {
 				return errors.New("Router.Add failure")
 			}
-			router.Get(req.Name).Attribute().Update(func(attr *service.Attribute) error {
+			s.Attribute().Update(func(attr *service.Attribute) error {
 				*attr = req.Attr
+				attr.RemotePort = remotePort
 				return nil
 			})
+			if remoteln != nil {
+				go serveRemotePort(remoteln, router, req.Name)
+			}
 			defer func() {
 				service := router.Get(req.Name)
 				if service != nil {
@@ -78,6 +182,68 @@ func ServerSide(router *service.Router) protocal.HandshakeHandleFunc {
 
 	}
 }
+func exposeGroup(router *service.Router, proto *protocal.Protocal, req *ExposeReq) error {
+	var addr string
+	if remote := proto.RemoteAddr(); remote != nil {
+		addr = remote.String()
+	}
+
+	// groups have no remote port, whatever the exposer says
+	attr := req.Attr
+	attr.RemotePort = 0
+
+	id, err := router.JoinWithAttribute(req.Name, req.Group, addr, attr)
+	if err != nil {
+		proto.Reply(CMD_EXPOSE_REPLY, &Reply{
+			OK:  false,
+			Err: err.Error(),
+		})
+
+		return errors.Trace(err)
+	}
+	defer router.Leave(req.Name, id)
+
+	s := router.Get(req.Name)
+	if s == nil {
+		err := errors.Annotatef(ErrServiceRemoved, "%q", req.Name)
+		proto.Reply(CMD_EXPOSE_REPLY, &Reply{
+			OK:  false,
+			Err: err.Error(),
+		})
+
+		return errors.Trace(err)
+	}
+	s.SetLimits(req.limits())
+
+	err = router.ClaimHostnames(req.Name, req.Attr.HTTP.Hostnames)
+	if err != nil {
+		proto.Reply(CMD_EXPOSE_REPLY, &Reply{
+			OK:  false,
+			Err: err.Error(),
+		})
+
+		return errors.Trace(err)
+	}
+
+	err = proto.Reply(CMD_EXPOSE_REPLY, &Reply{
+		OK: true,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	session := proto.Multiplex(true)
+
+	ok := router.AddMember(req.Name, id, session.Open, session.Close)
+	if !ok {
+		return errors.New("Router.AddMember failure")
+	}
+
+	session.Wait()
+
+	return nil
+}
+
 func ClientSide(dial func() (net.Conn, error)) protocal.HandshakeHandleFunc {
 	return func(proto *protocal.Protocal, cmd string, details []byte) error {
 		switch cmd {
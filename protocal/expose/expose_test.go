@@ -1,11 +1,15 @@
 package expose
 
 import (
+	"encoding/json"
 	"io"
 	"net"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/juju/errors"
 	"github.com/service-exposer/exposer/listener"
 	"github.com/service-exposer/exposer/protocal"
 	"github.com/service-exposer/exposer/service"
@@ -80,3 +84,340 @@ func Test_expose(t *testing.T) {
 		return nil
 	})
 }
+
+func Test_exposeGroup(t *testing.T) {
+	router := service.NewRouter()
+	ln, dial := listener.Pipe()
+
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.On = ServerSide(router)
+		return proto
+	})
+
+	accept := make(chan int, 128)
+	for i := 0; i < 2; i++ {
+		conn, err := dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		member := i
+		proto := protocal.NewProtocal(conn)
+		proto.On = ClientSide(func() (net.Conn, error) {
+			accept <- member
+			c, _ := net.Pipe()
+			return c, nil
+		})
+		go proto.Request(CMD_EXPOSE, &ExposeReq{
+			Name:  "test",
+			Group: service.RoundRobin,
+			Attr: service.Attribute{
+				RemotePort: 1234,
+			},
+		})
+	}
+
+	members := make(map[int]bool)
+	for i := 0; i < 100 && len(members) < 2; i++ {
+		if s := router.Get("test"); s != nil {
+			s.Open()
+		}
+
+		select {
+		case member := <-accept:
+			members[member] = true
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+
+	if len(members) != 2 {
+		t.Fatal(members, "want both members opened")
+	}
+
+	info := router.Get("test").Info()
+	if info.Balance != service.RoundRobin || len(info.Members) != 2 {
+		t.Fatal(info)
+	}
+	router.Get("test").Attribute().View(func(attr service.Attribute) error {
+		if attr.RemotePort != 0 {
+			t.Fatal("expect", 0, "got", attr.RemotePort)
+		}
+		return nil
+	})
+}
+
+func Test_exposeHostnames(t *testing.T) {
+	router := service.NewRouter()
+	ln, dial := listener.Pipe()
+
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.On = ServerSide(router)
+		return proto
+	})
+
+	expose := func(name string) *protocal.Protocal {
+		conn, err := dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		attr := service.Attribute{}
+		attr.HTTP.Is = true
+		attr.HTTP.Hostnames = []string{"www.example.test"}
+
+		proto := protocal.NewProtocal(conn)
+		proto.On = ClientSide(func() (net.Conn, error) {
+			return nil, errors.New("no service")
+		})
+		go proto.Request(CMD_EXPOSE, &ExposeReq{
+			Name: name,
+			Attr: attr,
+		})
+		return proto
+	}
+
+	expose("first")
+	for i := 0; i < 100 && router.MatchHost("www.example.test", "", []string{"*"}) == nil; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if s := router.MatchHost("www.example.test", "", []string{"*"}); s.Name() != "first" {
+		t.Fatal("expect", "first", "got", s.Name())
+	}
+
+	err := expose("second").Wait()
+	if err == nil || !strings.Contains(err.Error(), service.ErrHostnameExist.Error()) {
+		t.Fatal("expect", service.ErrHostnameExist, "got", err)
+	}
+	for i := 0; i < 100 && router.Get("second") != nil; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if router.Get("second") != nil {
+		t.Fatal("expect second removed")
+	}
+}
+
+func Test_exposeRemotePort(t *testing.T) {
+	router := service.NewRouter()
+	ln, dial := listener.Pipe()
+
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.On = ServerSideWithConfig(router, &Config{
+			RemotePorts: PortRange{Min: 19770, Max: 19779},
+			RemoteHost:  "127.0.0.1",
+		})
+		return proto
+	})
+
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proto := protocal.NewProtocal(conn)
+	proto.On = ClientSide(func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go io.Copy(c1, c1)
+		return c2, nil
+	})
+	auto := 0
+	go proto.Request(CMD_EXPOSE, &ExposeReq{
+		Name:       "test",
+		RemotePort: &auto,
+	})
+
+	port := 0
+	for i := 0; i < 100 && port == 0; i++ {
+		if s := router.Get("test"); s != nil {
+			s.Attribute().View(func(attr service.Attribute) error {
+				port = attr.RemotePort
+				return nil
+			})
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if port < 19770 || port > 19779 {
+		t.Fatal("expect port in 19770-19779 got", port)
+	}
+
+	c, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Write([]byte("hello"))
+	data := make([]byte, 5)
+	_, err = io.ReadAtLeast(c, data, len(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatal("expect hello got", string(data))
+	}
+
+	conn.Close()
+	for i := 0; i < 100; i++ {
+		c, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			return
+		}
+		c.Close()
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatal("expect port", port, "released")
+}
+
+func Test_exposeReclaim(t *testing.T) {
+	router := service.NewRouter()
+	ln, dial := listener.Pipe()
+
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.On = ServerSideWithConfig(router, &Config{
+			Grace: time.Minute,
+		})
+		return proto
+	})
+
+	tokens := make(chan string, 1)
+	expose := func(token string) (net.Conn, *protocal.Protocal) {
+		conn, err := dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		handlefn := ClientSide(func() (net.Conn, error) {
+			c1, c2 := net.Pipe()
+			go io.Copy(c1, c1)
+			return c2, nil
+		})
+		proto := protocal.NewProtocal(conn)
+		proto.On = func(proto *protocal.Protocal, cmd string, details []byte) error {
+			var reply Reply
+			if json.Unmarshal(details, &reply) == nil && reply.OK {
+				tokens <- reply.Token
+			}
+			return handlefn(proto, cmd, details)
+		}
+		go proto.Request(CMD_EXPOSE, &ExposeReq{
+			Name:  "test",
+			Token: token,
+		})
+		return conn, proto
+	}
+
+	conn, _ := expose("")
+	token := <-tokens
+	if token == "" {
+		t.Fatal("expect token")
+	}
+	conn.Close()
+	for i := 0; i < 100 && !router.Get("test").Info().Reserved; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if !router.Get("test").Info().Reserved {
+		t.Fatal("expect test reserved")
+	}
+
+	_, proto := expose("")
+	err := proto.Wait()
+	if err == nil || !strings.Contains(err.Error(), service.ErrServiceReserved.Error()) {
+		t.Fatal("expect", service.ErrServiceReserved, "got", err)
+	}
+
+	expose(token)
+	if reclaimed := <-tokens; reclaimed != token {
+		t.Fatal("expect", token, "got", reclaimed)
+	}
+	c, err := router.Get("test").Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Write([]byte("hello"))
+	data := make([]byte, 5)
+	_, err = io.ReadAtLeast(c, data, len(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatal("expect hello got", string(data))
+	}
+}
+
+func Test_exposeLimits(t *testing.T) {
+	router := service.NewRouter()
+	router.CapLimits(service.Limits{MaxStreams: 1, StreamsPerSecond: 10})
+	ln, dial := listener.Pipe()
+
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.On = ServerSide(router)
+		return proto
+	})
+
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto := protocal.NewProtocal(conn)
+	proto.On = ClientSide(func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go io.Copy(c1, c1)
+		return c2, nil
+	})
+	go proto.Request(CMD_EXPOSE, &ExposeReq{
+		Name:   "test",
+		Limits: &service.Limits{MaxStreams: 5, ReadBytesPerSecond: 1000},
+	})
+
+	var c1 net.Conn
+	for i := 0; i < 100; i++ {
+		if s := router.Get("test"); s != nil {
+			if c1, err = s.Open(); err == nil {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if c1 == nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+
+	expect := service.Limits{MaxStreams: 1, StreamsPerSecond: 10, ReadBytesPerSecond: 1000}
+	if limits := router.Get("test").Info().Limits; limits == nil || *limits != expect {
+		t.Fatal("expect", expect, "got", limits)
+	}
+	_, err = router.Get("test").Open()
+	if errors.Cause(err) != service.ErrLimitExceeded {
+		t.Fatal("expect(error)", service.ErrLimitExceeded, "got", err)
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	for s, expect := range map[string]PortRange{
+		"":          {},
+		"8000":      {Min: 8000, Max: 8000},
+		"8000-8099": {Min: 8000, Max: 8099},
+	} {
+		r, err := ParsePortRange(s)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		if r != expect {
+			t.Fatal("expect", expect, "got", r)
+		}
+	}
+
+	for _, s := range []string{"0", "x", "9000-8000", "1-70000"} {
+		_, err := ParsePortRange(s)
+		if err == nil {
+			t.Fatal("expect error for", s)
+		}
+	}
+}
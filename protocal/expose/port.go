@@ -0,0 +1,139 @@
+package expose
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/protocal"
+	"github.com/service-exposer/exposer/service"
+)
+
+var (
+	ErrRemotePortDisabled = errors.New("remote port disabled")
+	ErrRemotePortRange    = errors.New("remote port out of range")
+	ErrRemotePortGroup    = errors.New("remote port is not supported by service group")
+)
+
+// PortRange is an inclusive range of TCP ports, the zero value is empty.
+type PortRange struct {
+	Min int
+	Max int
+}
+
+// ParsePortRange parses "PORT" or "MIN-MAX". An empty string is the
+// empty range.
+func ParsePortRange(s string) (PortRange, error) {
+	if s == "" {
+		return PortRange{}, nil
+	}
+
+	min, max := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		min, max = s[:i], s[i+1:]
+	}
+
+	var (
+		r   PortRange
+		err error
+	)
+	r.Min, err = strconv.Atoi(min)
+	if err != nil {
+		return PortRange{}, errors.Annotatef(err, "port range %q", s)
+	}
+	r.Max, err = strconv.Atoi(max)
+	if err != nil {
+		return PortRange{}, errors.Annotatef(err, "port range %q", s)
+	}
+	if r.Min < 1 || r.Max > 65535 || r.Min > r.Max {
+		return PortRange{}, errors.Errorf("port range %q: bad range", s)
+	}
+
+	return r, nil
+}
+
+func (r PortRange) Empty() bool {
+	return r.Min == 0 && r.Max == 0
+}
+
+func (r PortRange) Contains(port int) bool {
+	return !r.Empty() && r.Min <= port && port <= r.Max
+}
+
+// Config is the daemon side configuration of expose routes.
+type Config struct {
+	// RemotePorts are the ports ExposeReq.RemotePort may ask for,
+	// empty disables remote ports.
+	RemotePorts PortRange
+	// RemoteHost is the host remote ports listen on, all interfaces if empty.
+	RemoteHost string
+
+	// Grace is how long the name of an exposer whose session ended stays
+	// reserved for its token, 0 removes it at once.
+	Grace time.Duration
+}
+
+// listen opens the remote port asked for by port, 0 picks a free one
+// in config.RemotePorts.
+func (config *Config) listen(port int) (net.Listener, error) {
+	ln, err := config.RemotePorts.Listen(config.RemoteHost, port)
+	return ln, errors.Trace(err)
+}
+
+// Listen opens port on host, 0 picks a free one in ports.
+func (ports PortRange) Listen(host string, port int) (net.Listener, error) {
+	if ports.Empty() {
+		return nil, errors.Trace(ErrRemotePortDisabled)
+	}
+
+	if port != 0 {
+		if !ports.Contains(port) {
+			return nil, errors.Annotatef(ErrRemotePortRange, "%d not in %d-%d", port, ports.Min, ports.Max)
+		}
+
+		ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		return ln, errors.Trace(err)
+	}
+
+	n := ports.Max - ports.Min + 1
+	start := rand.Intn(n)
+	for i := 0; i < n; i++ {
+		port := ports.Min + (start+i)%n
+		ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err == nil {
+			return ln, nil
+		}
+	}
+
+	return nil, errors.Errorf("no free remote port in %d-%d", ports.Min, ports.Max)
+}
+
+// serveRemotePort pipes every connection accepted by ln into the service
+// name until ln is closed.
+func serveRemotePort(ln net.Listener, router *service.Router, name string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			s := router.Get(name)
+			if s == nil {
+				conn.Close()
+				return
+			}
+
+			remote, err := s.Open()
+			if err != nil {
+				conn.Close()
+				return
+			}
+
+			protocal.Forward(conn, remote)
+		}()
+	}
+}
@@ -0,0 +1,148 @@
+package forward
+
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/protocal"
+)
+
+const (
+	CMD_DIAL       = "dial"
+	CMD_DIAL_REPLY = "dial:reply"
+)
+
+var (
+	ErrForbidden     = errors.New("forbidden")
+	ErrDynamicTarget = errors.New("dynamic forward has a target")
+)
+
+// Target is a connection of a dynamic forward, it knows where it goes.
+type Target interface {
+	net.Conn
+
+	// Target is the destination, a hostname is resolved by the daemon.
+	Target() (network, address string)
+	// Dialed is told whether the daemon reached the destination, before
+	// any data is forwarded. If the daemon told why it failed, the
+	// errors.Cause of err is netacl.ErrDenied, ErrForbidden, ErrRefused,
+	// ErrUnreachable or ErrTimeout.
+	Dialed(err error) error
+}
+
+// serveDial dials the target of a dynamic stream and forwards it.
+func (config *Config) serveDial(proto *protocal.Protocal, identity string, details []byte) error {
+	var target Forward
+	err := json.Unmarshal(details, &target)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var conn net.Conn
+	if config.Authorize != nil && !config.Authorize(identity, target.Address) {
+		err = errors.Annotatef(ErrForbidden, "forward %q", target.Address)
+	} else {
+		conn, err = config.dial(target.Network, target.Address)
+	}
+	if config.Audit != nil {
+		config.Audit(identity, target, err)
+	}
+	if err != nil {
+		proto.Reply(CMD_DIAL_REPLY, &Reply{
+			OK:     false,
+			Err:    err.Error(),
+			Reason: reason(err),
+		})
+		return errors.Trace(err)
+	}
+
+	err = proto.Reply(CMD_DIAL_REPLY, &Reply{
+		OK: true,
+	})
+	if err != nil {
+		conn.Close()
+		return errors.Trace(err)
+	}
+
+	proto.Forward(conn)
+	return nil
+}
+
+// DynamicClientSide forwards every connection accepted by ln to its own
+// destination. Connections that are not a Target are closed.
+func DynamicClientSide(ln net.Listener) protocal.HandshakeHandleFunc {
+	return func(proto *protocal.Protocal, cmd string, details []byte) error {
+		switch cmd {
+		case CMD_FORWARD_REPLY:
+			var reply Reply
+			err := json.Unmarshal(details, &reply)
+			if err != nil {
+				return errors.Trace(err)
+			}
+
+			if !reply.OK {
+				return errors.New(reply.Err)
+			}
+
+			session := proto.Multiplex(true)
+
+			for {
+				local_conn, err := ln.Accept()
+				if err != nil {
+					return errors.Trace(err)
+				}
+
+				target, ok := local_conn.(Target)
+				if !ok {
+					local_conn.Close()
+					continue
+				}
+
+				remote_conn, err := session.Open()
+				if err != nil {
+					target.Dialed(err)
+					local_conn.Close()
+					return errors.Trace(err)
+				}
+
+				network, address := target.Target()
+				proto_dial := protocal.NewProtocal(remote_conn)
+				proto_dial.On = func(proto *protocal.Protocal, cmd string, details []byte) error {
+					switch cmd {
+					case CMD_DIAL_REPLY:
+						var reply Reply
+						err := json.Unmarshal(details, &reply)
+						if err == nil && !reply.OK {
+							err = replyErr(&reply)
+						}
+						if err == nil {
+							err = target.Dialed(nil)
+						} else {
+							target.Dialed(err)
+						}
+						if err != nil {
+							target.Close()
+							return errors.Trace(err)
+						}
+
+						proto.Forward(target)
+						return nil
+					}
+					target.Close()
+					return errors.New("unknow cmd: " + cmd)
+				}
+
+				go func() {
+					proto_dial.Request(CMD_DIAL, &Forward{
+						Network: network,
+						Address: address,
+					})
+					target.Close()
+				}()
+			}
+		default:
+			return errors.New("unknow cmd")
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 
 	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/netacl"
 	"github.com/service-exposer/exposer/protocal"
 )
 
@@ -16,14 +17,59 @@ const (
 type Reply struct {
 	OK  bool
 	Err string
+
+	// Reason is why a dial failed, one of the Reason constants or empty.
+	Reason string `json:",omitempty"`
 }
 
 type Forward struct {
 	Network string
 	Address string
+
+	// Dynamic, when set, takes the target of every stream from its
+	// CMD_DIAL handshake, Network and Address must be empty.
+	Dynamic bool `json:",omitempty"`
+}
+
+type Config struct {
+	// Policy decides which destinations may be dialed, nil dials anything.
+	Policy *netacl.Policy
+
+	// Dial connects to destinations permitted by Policy, net.Dial if nil.
+	Dial func(network, address string) (net.Conn, error)
+
+	// Audit, when set, is told the outcome of every forward request and
+	// of every stream.
+	Audit func(identity string, forward Forward, err error)
+
+	// Authorize, when set, decides which targets of dynamic streams
+	// identity may dial.
+	Authorize func(identity string, address string) bool
+}
+
+func (config *Config) dial(network, address string) (net.Conn, error) {
+	if config.Policy != nil {
+		resolved, err := config.Policy.Resolve(network, address)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		address = resolved
+	}
+
+	dial := config.Dial
+	if dial == nil {
+		dial = net.Dial
+	}
+
+	conn, err := dial(network, address)
+	return conn, errors.Trace(err)
 }
 
 func ServerSide() protocal.HandshakeHandleFunc {
+	return ServerSideWithConfig(&Config{})
+}
+
+func ServerSideWithConfig(config *Config) protocal.HandshakeHandleFunc {
 	return func(proto *protocal.Protocal, cmd string, details []byte) error {
 		switch cmd {
 		case CMD_FORWARD:
@@ -33,15 +79,32 @@ func ServerSide() protocal.HandshakeHandleFunc {
 				return errors.Trace(err)
 			}
 
-			conn, err := net.Dial(forward.Network, forward.Address)
-			if err != nil {
+			identity := proto.Identity()
+			if forward.Dynamic && (forward.Network != "" || forward.Address != "") {
+				err := errors.Annotatef(ErrDynamicTarget, "%s %q", forward.Network, forward.Address)
+				if config.Audit != nil {
+					config.Audit(identity, forward, err)
+				}
 				proto.Reply(CMD_FORWARD_REPLY, &Reply{
 					OK:  false,
 					Err: err.Error(),
 				})
 				return errors.Trace(err)
 			}
-			conn.Close()
+			if !forward.Dynamic {
+				conn, err := config.dial(forward.Network, forward.Address)
+				if config.Audit != nil {
+					config.Audit(identity, forward, err)
+				}
+				if err != nil {
+					proto.Reply(CMD_FORWARD_REPLY, &Reply{
+						OK:  false,
+						Err: err.Error(),
+					})
+					return errors.Trace(err)
+				}
+				conn.Close()
+			}
 
 			err = proto.Reply(CMD_FORWARD_REPLY, &Reply{
 				OK: true,
@@ -87,12 +150,29 @@ func ServerSide() protocal.HandshakeHandleFunc {
 			protocal.Serve(proto.Multiplex(false), func(conn net.Conn) protocal.ProtocalHandler {
 				proto := protocal.NewProtocal(conn)
 				proto.On = func(proto *protocal.Protocal, cmd string, details []byte) error {
+					// streams of a dynamic forward are only authorized
+					// by their CMD_DIAL handshake
+					if forward.Dynamic {
+						if cmd != CMD_DIAL {
+							return errors.New("dynamic forward stream without " + CMD_DIAL)
+						}
+						return config.serveDial(proto, identity, details)
+					}
+					if cmd == CMD_DIAL {
+						return errors.New("not a dynamic forward")
+					}
+
 					err := proto.Reply("", nil)
 					if err != nil {
 						return errors.Trace(err)
 					}
 
-					conn, err := net.Dial(forward.Network, forward.Address)
+					// resolved again for every stream, the name may
+					// point somewhere else by now
+					conn, err := config.dial(forward.Network, forward.Address)
+					if config.Audit != nil {
+						config.Audit(identity, forward, err)
+					}
 					if err != nil {
 						return errors.Trace(err)
 					}
@@ -134,6 +214,7 @@ func ClientSide(ln net.Listener) protocal.HandshakeHandleFunc {
 
 				remote_conn, err := session.Open()
 				if err != nil {
+					local_conn.Close()
 					return err
 				}
 
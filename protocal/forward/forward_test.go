@@ -4,9 +4,13 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strings"
 	"testing"
 
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/listener"
 	"github.com/service-exposer/exposer/listener/utils"
+	"github.com/service-exposer/exposer/netacl"
 	"github.com/service-exposer/exposer/protocal"
 )
 
@@ -82,3 +86,178 @@ func TestForward(t *testing.T) {
 		t.Fatal("expect", MESSAGE, "got", string(data))
 	}
 }
+
+func TestForward_policy(t *testing.T) {
+	ln, dial := listener.Pipe()
+
+	audits := make(chan error, 1)
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.On = ServerSideWithConfig(&Config{
+			Policy: netacl.Default,
+			Audit: func(identity string, forward Forward, err error) {
+				audits <- err
+			},
+		})
+		return proto
+	})
+
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local_ln, _ := listener.Pipe()
+
+	proto := protocal.NewProtocal(conn)
+	proto.On = ClientSide(local_ln)
+	go proto.Request(CMD_FORWARD, &Forward{
+		Network: "tcp",
+		Address: "127.0.0.1:22",
+	})
+
+	err = proto.Wait()
+	if err == nil || !strings.Contains(err.Error(), "loopback") {
+		t.Fatal(err, "want loopback denied")
+	}
+
+	audit := <-audits
+	if errors.Cause(audit) != netacl.ErrDenied {
+		t.Fatal(errors.Cause(audit), "want", netacl.ErrDenied)
+	}
+}
+
+func TestForward_rebinding(t *testing.T) {
+	remote_ln, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote_ln.Close()
+	go func() {
+		for {
+			conn, err := remote_ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("hello"))
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(remote_ln.Addr().String())
+
+	// rebind.test moves to a denied address after the forward is set up
+	resolved := make(chan string, 1)
+	resolved <- "127.0.0.2"
+	policy, err := netacl.New(netacl.Config{
+		Deny:          []string{"127.0.0.3"},
+		AllowLoopback: true,
+		LookupIP: func(host string) ([]net.IP, error) {
+			ip := <-resolved
+			resolved <- ip
+			return []net.IP{net.ParseIP(ip)}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, dial := listener.Pipe()
+	audits := make(chan error, 1)
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.On = ServerSideWithConfig(&Config{
+			Policy: policy,
+			Audit: func(identity string, forward Forward, err error) {
+				audits <- err
+			},
+		})
+		return proto
+	})
+
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	local_ln, local_dial := listener.Pipe()
+	proto := protocal.NewProtocal(conn)
+	proto.On = ClientSide(local_ln)
+	go proto.Request(CMD_FORWARD, &Forward{
+		Network: "tcp",
+		Address: net.JoinHostPort("rebind.test", port),
+	})
+
+	if audit := <-audits; audit != nil {
+		t.Fatal("expect forward allowed got", audit)
+	}
+
+	read := func() string {
+		local_conn, err := local_dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer local_conn.Close()
+
+		data, _ := ioutil.ReadAll(local_conn)
+		return string(data)
+	}
+
+	if data := read(); data != "hello" {
+		t.Fatal("expect", "hello", "got", data)
+	}
+	if audit := <-audits; audit != nil {
+		t.Fatal("expect stream allowed got", audit)
+	}
+
+	<-resolved
+	resolved <- "127.0.0.3"
+
+	if data := read(); data != "" {
+		t.Fatal("expect stream refused got", data)
+	}
+	audit := <-audits
+	if errors.Cause(audit) != netacl.ErrDenied {
+		t.Fatal("expect", netacl.ErrDenied, "got", errors.Cause(audit))
+	}
+}
+
+func Test_reason(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	_, refused := net.Dial("tcp", ln.Addr().String())
+	if refused == nil {
+		t.Fatal("expect connection refused")
+	}
+
+	for _, c := range []struct {
+		err    error
+		reason string
+		cause  error
+	}{
+		{errors.Annotate(netacl.ErrDenied, "loopback address 127.0.0.1"), ReasonDenied, netacl.ErrDenied},
+		{errors.Annotatef(ErrForbidden, "forward %q", "x:1"), ReasonForbidden, ErrForbidden},
+		{errors.Trace(refused), ReasonRefused, ErrRefused},
+		{errors.Trace(&net.DNSError{Err: "no such host", Name: "x.invalid", IsNotFound: true}), ReasonUnreachable, ErrUnreachable},
+		{errors.Trace(&net.OpError{Op: "dial", Net: "tcp", Err: ErrTimeout}), ReasonTimeout, ErrTimeout},
+		{errors.New("other"), "", nil},
+	} {
+		if reason := reason(c.err); reason != c.reason {
+			t.Fatal("expect", c.reason, "for", c.err, "got", reason)
+		}
+
+		err := replyErr(&Reply{
+			Err:    c.err.Error(),
+			Reason: c.reason,
+		})
+		if err.Error() != c.err.Error() {
+			t.Fatal("expect", c.err.Error(), "got", err.Error())
+		}
+		if c.cause != nil && errors.Cause(err) != c.cause {
+			t.Fatal("expect", c.cause, "got", errors.Cause(err))
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package forward
+
+import (
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/netacl"
+)
+
+// Reasons of a failed dial in Reply.Reason, so the client can tell them
+// apart without parsing Reply.Err.
+const (
+	ReasonDenied      = "denied"
+	ReasonForbidden   = "forbidden"
+	ReasonRefused     = "refused"
+	ReasonUnreachable = "unreachable"
+	ReasonTimeout     = "timeout"
+)
+
+var (
+	ErrRefused     = errors.New("connection refused")
+	ErrUnreachable = errors.New("destination unreachable")
+	// ErrTimeout is a net.Error that timed out.
+	ErrTimeout net.Error = &timeoutError{}
+)
+
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
+
+// reason tells why dialing failed with err, "" if it is not known.
+func reason(err error) string {
+	cause := errors.Cause(err)
+	switch cause {
+	case netacl.ErrDenied:
+		return ReasonDenied
+	case ErrForbidden:
+		return ReasonForbidden
+	}
+	if ne, ok := cause.(net.Error); ok && ne.Timeout() {
+		return ReasonTimeout
+	}
+
+	if oe, ok := cause.(*net.OpError); ok {
+		cause = oe.Err
+	}
+	if se, ok := cause.(*os.SyscallError); ok {
+		cause = se.Err
+	}
+	switch cause {
+	case syscall.ECONNREFUSED:
+		return ReasonRefused
+	case syscall.EHOSTUNREACH, syscall.ENETUNREACH:
+		return ReasonUnreachable
+	}
+	if _, ok := cause.(*net.DNSError); ok {
+		return ReasonUnreachable
+	}
+	return ""
+}
+
+// replyError is a failed dial as told by the daemon, its Cause is the
+// error of its reason.
+type replyError struct {
+	msg   string
+	cause error
+}
+
+func (err *replyError) Error() string {
+	return err.msg
+}
+
+func (err *replyError) Cause() error {
+	return err.cause
+}
+
+// replyErr rebuilds the error of a failed reply.
+func replyErr(reply *Reply) error {
+	var cause error
+	switch reply.Reason {
+	case ReasonDenied:
+		cause = netacl.ErrDenied
+	case ReasonForbidden:
+		cause = ErrForbidden
+	case ReasonRefused:
+		cause = ErrRefused
+	case ReasonUnreachable:
+		cause = ErrUnreachable
+	case ReasonTimeout:
+		cause = ErrTimeout
+	default:
+		return errors.New(reply.Err)
+	}
+	return &replyError{
+		msg:   reply.Err,
+		cause: cause,
+	}
+}
@@ -0,0 +1,102 @@
+package keepalive
+
+import (
+	"sync"
+	"time"
+)
+
+// Health is the state of a session as seen by its pings.
+type Health string
+
+const (
+	Healthy  Health = "healthy"
+	Degraded Health = "degraded"
+	Dead     Health = "dead"
+)
+
+// Status is the last measurement of a session. RTT is of the last ping,
+// Jitter the smoothed RTT variation of RFC 3550.
+type Status struct {
+	Health Health
+	RTT    time.Duration
+	Jitter time.Duration
+}
+
+// meter turns RTT samples into a Status.
+type meter struct {
+	degradedRTT    time.Duration
+	degradedJitter time.Duration
+
+	mu       *sync.Mutex
+	current  Status
+	sampled  bool
+	degraded int // degraded samples in a row
+}
+
+func newMeter(config *Config) *meter {
+	m := &meter{
+		degradedRTT:    config.DegradedRTT,
+		degradedJitter: config.DegradedJitter,
+
+		mu:       new(sync.Mutex),
+		current:  Status{Health: Healthy},
+		sampled:  false,
+		degraded: 0,
+	}
+	if m.degradedRTT == 0 {
+		m.degradedRTT = DefaultDegradedRTT
+	}
+	if m.degradedJitter == 0 {
+		m.degradedJitter = DefaultDegradedJitter
+	}
+	return m
+}
+
+func (m *meter) sample(rtt time.Duration) Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rtt < 0 {
+		rtt = 0
+	}
+	if m.sampled {
+		d := rtt - m.current.RTT
+		if d < 0 {
+			d = -d
+		}
+		m.current.Jitter += (d - m.current.Jitter) / 16
+	}
+	m.sampled = true
+	m.current.RTT = rtt
+
+	if rtt > m.degradedRTT || m.current.Jitter > m.degradedJitter {
+		m.current.Health = Degraded
+		m.degraded++
+	} else {
+		m.current.Health = Healthy
+		m.degraded = 0
+	}
+	return m.current
+}
+
+func (m *meter) status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.current
+}
+
+func (m *meter) degradedFor() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.degraded
+}
+
+func (m *meter) dead() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.current.Health = Dead
+	return m.current
+}
@@ -1,6 +1,7 @@
 package keepalive
 
 import (
+	"encoding/json"
 	"sync"
 	"time"
 
@@ -9,24 +10,78 @@ import (
 )
 
 var (
-	DefaultInterval = 20 * time.Second
-	DefaultTimeout  = 30 * time.Second
+	DefaultInterval       = 20 * time.Second
+	DefaultTimeout        = 30 * time.Second
+	DefaultDegradedRTT    = time.Second
+	DefaultDegradedJitter = 500 * time.Millisecond
 )
 
 var (
-	ErrTimeout = errors.New("timeout")
+	ErrTimeout   = errors.New("timeout")
+	ErrUnhealthy = errors.New("degraded for too long")
 )
 
 const (
-	CMD_PING = "ping"
-	CMD_PONG = "pong"
+	CMD_PING  = "ping"
+	CMD_PONG  = "pong"
+	CMD_DRAIN = "drain"
 )
 
 const (
 	EVENT_TIMEOUT = "event:timeout"
+	EVENT_DRAIN   = "event:drain"
 )
 
+// Ping is the details of CMD_PING, a ping without them is answered but
+// not measured. Echo and Held let the server measure the RTT too: Echo is
+// Pong.ServerTime of the last pong, Held how long the client kept it.
+type Ping struct {
+	Seq  uint64
+	Time int64 // unix nano of the client
+
+	Echo int64 `json:",omitempty"`
+	Held int64 `json:",omitempty"` // nanoseconds
+}
+
+// Pong is the details of CMD_PONG.
+type Pong struct {
+	Seq        uint64
+	Time       int64 // Ping.Time
+	ServerTime int64 // unix nano of the server
+}
+
+type Config struct {
+	Timeout  time.Duration // DefaultTimeout if 0
+	Interval time.Duration // client side, DefaultInterval if 0
+
+	// A session is degraded while its RTT or jitter is above these,
+	// DefaultDegradedRTT and DefaultDegradedJitter if 0.
+	DegradedRTT    time.Duration
+	DegradedJitter time.Duration
+	// MaxDegraded, on the client side, ends the session with ErrUnhealthy
+	// after this many degraded pings in a row, 0 never.
+	MaxDegraded int
+	// OnHealth is called with every measurement, and with Dead when the
+	// peer missed its pings.
+	OnHealth func(Status)
+
+	// Drain is closed by the daemon when it shuts down, the server side
+	// then tells the client with CMD_DRAIN.
+	Drain <-chan struct{}
+	// OnDrain is called on the client side when CMD_DRAIN arrives.
+	OnDrain func()
+	// OnTimeout is called when the peer missed its pings.
+	OnTimeout func()
+}
+
 func ServerSide(timeout time.Duration) protocal.HandshakeHandleFunc {
+	return ServerSideWithConfig(&Config{
+		Timeout: timeout,
+	})
+}
+
+func ServerSideWithConfig(config *Config) protocal.HandshakeHandleFunc {
+	timeout := config.Timeout
 	if timeout == 0 {
 		timeout = DefaultTimeout
 	}
@@ -34,6 +89,7 @@ func ServerSide(timeout time.Duration) protocal.HandshakeHandleFunc {
 	var (
 		mutex        = new(sync.Mutex)
 		lastPingTime = time.Now()
+		meter        = newMeter(config)
 	)
 
 	var once = new(sync.Once)
@@ -55,16 +111,57 @@ func ServerSide(timeout time.Duration) protocal.HandshakeHandleFunc {
 					}
 				}
 			}()
+
+			if config.Drain != nil {
+				go func() {
+					done := make(chan struct{})
+					go func() {
+						proto.Wait()
+						close(done)
+					}()
+
+					select {
+					case <-config.Drain:
+						proto.Emit(EVENT_DRAIN, nil)
+					case <-done:
+					}
+				}()
+			}
 		})
 
 		switch cmd {
 		case CMD_PING:
+			now := time.Now()
 			mutex.Lock()
-			lastPingTime = time.Now()
+			lastPingTime = now
 			mutex.Unlock()
 
-			return proto.Reply(CMD_PONG, nil)
+			// old clients send no details
+			var ping Ping
+			if len(details) > 0 {
+				err := json.Unmarshal(details, &ping)
+				if err != nil {
+					return errors.Trace(err)
+				}
+			}
+			if ping.Echo != 0 {
+				config.health(meter.sample(time.Duration(now.UnixNano() - ping.Echo - ping.Held)))
+			} else {
+				config.health(meter.status())
+			}
+
+			return proto.Reply(CMD_PONG, &Pong{
+				Seq:        ping.Seq,
+				Time:       ping.Time,
+				ServerTime: now.UnixNano(),
+			})
+		case EVENT_DRAIN:
+			return proto.Reply(CMD_DRAIN, nil)
 		case EVENT_TIMEOUT:
+			if config.OnTimeout != nil {
+				config.OnTimeout()
+			}
+			config.health(meter.dead())
 			return errors.Trace(ErrTimeout)
 		}
 
@@ -73,6 +170,14 @@ func ServerSide(timeout time.Duration) protocal.HandshakeHandleFunc {
 }
 
 func ClientSide(timeout, interval time.Duration) protocal.HandshakeHandleFunc {
+	return ClientSideWithConfig(&Config{
+		Timeout:  timeout,
+		Interval: interval,
+	})
+}
+
+func ClientSideWithConfig(config *Config) protocal.HandshakeHandleFunc {
+	timeout, interval := config.Timeout, config.Interval
 	if timeout == 0 {
 		timeout = DefaultTimeout
 	}
@@ -87,6 +192,9 @@ func ClientSide(timeout, interval time.Duration) protocal.HandshakeHandleFunc {
 	var (
 		mutex        = new(sync.Mutex)
 		lastPingTime = time.Now()
+		seq          = uint64(0)
+		lastPong     Pong
+		meter        = newMeter(config)
 	)
 
 	var once = new(sync.Once)
@@ -112,13 +220,61 @@ func ClientSide(timeout, interval time.Duration) protocal.HandshakeHandleFunc {
 
 		switch cmd {
 		case CMD_PONG:
+			now := time.Now()
+
+			// old daemons send no details
+			var pong Pong
+			if len(details) > 0 {
+				err := json.Unmarshal(details, &pong)
+				if err != nil {
+					return errors.Trace(err)
+				}
+			}
+
 			mutex.Lock()
-			lastPingTime = time.Now()
+			lastPingTime = now
+			lastPong = pong
+			measured := pong.Time != 0 && pong.Seq == seq
 			mutex.Unlock()
 
-			time.Sleep(interval)
-			return proto.Reply(CMD_PING, nil)
+			if measured {
+				status := meter.sample(now.Sub(time.Unix(0, pong.Time)))
+				config.health(status)
+				if config.MaxDegraded > 0 && meter.degradedFor() >= config.MaxDegraded {
+					config.health(meter.dead())
+					return errors.Trace(ErrUnhealthy)
+				}
+			}
+
+			// sleeping here would hold back CMD_DRAIN, a failed ping
+			// ends in EVENT_TIMEOUT
+			time.AfterFunc(interval, func() {
+				mutex.Lock()
+				seq++
+				sent := time.Now()
+				ping := &Ping{
+					Seq:  seq,
+					Time: sent.UnixNano(),
+				}
+				if lastPong.ServerTime != 0 {
+					ping.Echo = lastPong.ServerTime
+					ping.Held = int64(sent.Sub(now))
+				}
+				mutex.Unlock()
+
+				proto.Reply(CMD_PING, ping)
+			})
+			return nil
+		case CMD_DRAIN:
+			if config.OnDrain != nil {
+				config.OnDrain()
+			}
+			return nil
 		case EVENT_TIMEOUT:
+			if config.OnTimeout != nil {
+				config.OnTimeout()
+			}
+			config.health(meter.dead())
 			return errors.Trace(ErrTimeout)
 		}
 
@@ -126,3 +282,9 @@ func ClientSide(timeout, interval time.Duration) protocal.HandshakeHandleFunc {
 
 	}
 }
+
+func (config *Config) health(status Status) {
+	if config.OnHealth != nil {
+		config.OnHealth(status)
+	}
+}
@@ -125,3 +125,163 @@ func Test_keepalive(t *testing.T) {
 		}
 	}()
 }
+
+func Test_keepaliveDrain(t *testing.T) {
+	drain := make(chan struct{})
+
+	ln, dial := listener.Pipe()
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.On = ServerSideWithConfig(&Config{
+			Drain: drain,
+		})
+		return proto
+	})
+
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drained := make(chan struct{})
+	proto := protocal.NewProtocal(conn)
+	proto.On = ClientSideWithConfig(&Config{
+		Interval: time.Millisecond,
+		OnDrain: func() {
+			close(drained)
+		},
+	})
+	go proto.Request(CMD_PING, nil)
+
+	close(drain)
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("expect", CMD_DRAIN)
+	}
+}
+
+func Test_meter(t *testing.T) {
+	m := newMeter(&Config{
+		DegradedRTT:    100 * time.Millisecond,
+		DegradedJitter: 10 * time.Millisecond,
+	})
+
+	if status := m.status(); status.Health != Healthy {
+		t.Fatal("expect", Healthy, "got", status)
+	}
+	if status := m.sample(50 * time.Millisecond); status.Health != Healthy || status.RTT != 50*time.Millisecond || status.Jitter != 0 {
+		t.Fatal("expect healthy 50ms without jitter got", status)
+	}
+	if status := m.sample(210 * time.Millisecond); status.Health != Degraded || status.Jitter != 10*time.Millisecond {
+		t.Fatal("expect degraded with 10ms jitter got", status)
+	}
+	if status := m.sample(50 * time.Millisecond); status.Health != Degraded || status.Jitter <= 10*time.Millisecond {
+		t.Fatal("expect degraded by jitter got", status)
+	}
+	if n := m.degradedFor(); n != 2 {
+		t.Fatal("expect", 2, "got", n)
+	}
+	if status := m.dead(); status.Health != Dead {
+		t.Fatal("expect", Dead, "got", status)
+	}
+}
+
+func Test_keepaliveHealth(t *testing.T) {
+	var (
+		serverHealth = make(chan Status, 16)
+		clientHealth = make(chan Status, 16)
+	)
+	report := func(ch chan Status) func(Status) {
+		return func(status Status) {
+			select {
+			case ch <- status:
+			default:
+			}
+		}
+	}
+
+	ln, dial := listener.Pipe()
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.On = ServerSideWithConfig(&Config{
+			OnHealth: report(serverHealth),
+		})
+		return proto
+	})
+
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	proto := protocal.NewProtocal(conn)
+	proto.On = ClientSideWithConfig(&Config{
+		Interval: time.Millisecond,
+		OnHealth: report(clientHealth),
+	})
+	go proto.Request(CMD_PING, nil)
+
+	measured := func(name string, ch chan Status) {
+		timeout := time.After(time.Second)
+		for {
+			select {
+			case status := <-ch:
+				if status.Health != Healthy {
+					t.Fatal("expect", Healthy, "got", status)
+				}
+				if status.RTT > 0 {
+					return
+				}
+			case <-timeout:
+				t.Fatal("expect", name, "RTT")
+			}
+		}
+	}
+	measured("client", clientHealth)
+	measured("server", serverHealth)
+}
+
+func Test_keepaliveMaxDegraded(t *testing.T) {
+	ln, dial := listener.Pipe()
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.On = ServerSide(time.Second)
+		return proto
+	})
+
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var last Status
+	proto := protocal.NewProtocal(conn)
+	proto.On = ClientSideWithConfig(&Config{
+		Interval:    time.Millisecond,
+		DegradedRTT: time.Nanosecond,
+		MaxDegraded: 3,
+		OnHealth: func(status Status) {
+			last = status
+		},
+	})
+	go proto.Request(CMD_PING, nil)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- proto.Wait()
+	}()
+	select {
+	case err := <-done:
+		if errors.Cause(err) != ErrUnhealthy {
+			t.Fatal("expect", ErrUnhealthy, "got", err)
+		}
+		if last.Health != Dead {
+			t.Fatal("expect", Dead, "got", last)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect", ErrUnhealthy)
+	}
+}
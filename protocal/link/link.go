@@ -13,6 +13,9 @@ import (
 const (
 	CMD_LINK       = "link"
 	CMD_LINK_REPLY = "link:reply"
+	// CMD_LINK_STREAM answers a stream with whether the service was opened
+	// for it, see LinkReq.StreamReply.
+	CMD_LINK_STREAM = "link:stream"
 )
 
 var (
@@ -22,10 +25,25 @@ var (
 type Reply struct {
 	OK  bool
 	Err string
+
+	// StreamReply is set when the daemon agreed to LinkReq.StreamReply.
+	StreamReply bool `json:",omitempty"`
 }
 
 type LinkReq struct {
 	Name string
+
+	// StreamReply asks the daemon to reply CMD_LINK_STREAM on every stream
+	// before its data. A stream whose service can't be opened then fails
+	// alone instead of ending the route.
+	StreamReply bool `json:",omitempty"`
+}
+
+// Stream is a local conn that is told whether the service was opened for
+// it, before any data. Only daemons answering StreamReply tell.
+type Stream interface {
+	net.Conn
+	Opened(err error)
 }
 
 func ServerSide(router *service.Router) protocal.HandshakeHandleFunc {
@@ -49,7 +67,8 @@ func ServerSide(router *service.Router) protocal.HandshakeHandleFunc {
 			}
 
 			err = proto.Reply(CMD_LINK_REPLY, &Reply{
-				OK: true,
+				OK:          true,
+				StreamReply: req.StreamReply,
 			})
 			if err != nil {
 				return errors.Trace(err)
@@ -73,7 +92,17 @@ func ServerSide(router *service.Router) protocal.HandshakeHandleFunc {
 						return errors.Trace(err)
 					}
 
+					if req.StreamReply {
+						go serveStream(service, remote)
+						continue
+					}
+
 					local, err := service.Open()
+					if limited(err) {
+						// the link goes on, like the streams of StreamReply
+						remote.Close()
+						continue
+					}
 					if err != nil {
 						remote.Close()
 						return errors.Trace(err)
@@ -87,6 +116,81 @@ func ServerSide(router *service.Router) protocal.HandshakeHandleFunc {
 	}
 }
 
+// limited reports whether err is a limit of the service, which fails only
+// the stream.
+func limited(err error) bool {
+	return errors.Cause(err) == service.ErrLimitExceeded
+}
+
+// serveStream opens service for remote and replies how it went.
+func serveStream(service *service.Service, remote net.Conn) {
+	proto := protocal.NewProtocal(remote)
+
+	local, err := service.Open()
+	if err != nil {
+		proto.Reply(CMD_LINK_STREAM, &Reply{
+			OK:  false,
+			Err: err.Error(),
+		})
+		remote.Close()
+		return
+	}
+
+	err = proto.Reply(CMD_LINK_STREAM, &Reply{
+		OK: true,
+	})
+	if err != nil {
+		local.Close()
+		remote.Close()
+		return
+	}
+
+	protocal.Forward(remote, local)
+}
+
+// clientStream waits for the CMD_LINK_STREAM reply on remote, then
+// forwards local through it.
+func clientStream(remote, local net.Conn) {
+	replied := false
+
+	proto := protocal.NewProtocal(remote)
+	proto.On = func(proto *protocal.Protocal, cmd string, details []byte) error {
+		switch cmd {
+		case CMD_LINK_STREAM:
+			replied = true
+
+			var reply Reply
+			err := json.Unmarshal(details, &reply)
+			if err == nil && !reply.OK {
+				err = errors.New(reply.Err)
+			}
+			if stream, ok := local.(Stream); ok {
+				stream.Opened(err)
+			}
+			if err != nil {
+				local.Close()
+				return errors.Trace(err)
+			}
+
+			proto.Forward(local)
+			return nil
+		}
+		return errors.New("unknow cmd: " + cmd)
+	}
+	proto.Handle()
+
+	if !replied {
+		err := proto.Wait()
+		if err == nil {
+			err = errors.New("stream closed before reply")
+		}
+		if stream, ok := local.(Stream); ok {
+			stream.Opened(errors.Annotate(err, "link stream"))
+		}
+		local.Close()
+	}
+}
+
 func ClientSide(ln net.Listener) protocal.HandshakeHandleFunc {
 	return func(proto *protocal.Protocal, cmd string, details []byte) error {
 		switch cmd {
@@ -136,7 +240,11 @@ func ClientSide(ln net.Listener) protocal.HandshakeHandleFunc {
 						return
 					}
 
-					go protocal.Forward(remote, local)
+					if reply.StreamReply {
+						go clientStream(remote, local)
+					} else {
+						go protocal.Forward(remote, local)
+					}
 				}
 			}()
 
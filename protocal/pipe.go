@@ -0,0 +1,57 @@
+package protocal
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/service-exposer/exposer/listener"
+)
+
+const (
+	copyBufferSize = 32 * 1024
+)
+
+var copyBuffers = &sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, copyBufferSize)
+		return &b
+	},
+}
+
+// pipe copies src to dst and half-closes dst after EOF. It returns the
+// error of the copy, nil on EOF.
+func pipe(dst net.Conn, src io.Reader) error {
+	b := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(b)
+
+	_, err := io.CopyBuffer(dst, src, *b)
+	if err != nil {
+		return err
+	}
+	return listener.CloseWrite(dst)
+}
+
+// forward copies between c1, which is read through r1, and c2. A failed
+// direction tears down both at once.
+func forward(c1 net.Conn, r1 io.Reader, c2 net.Conn) {
+	closeBoth := func() {
+		c1.Close()
+		c2.Close()
+	}
+	defer closeBoth()
+
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if pipe(c1, c2) != nil {
+			closeBoth()
+		}
+	}()
+
+	if pipe(c2, r1) != nil {
+		closeBoth()
+	}
+	wg.Wait()
+}
@@ -5,7 +5,6 @@ import (
 	"io"
 	"net"
 	"sync"
-	"time"
 
 	"github.com/inconshreveable/muxado"
 	"github.com/juju/errors"
@@ -13,7 +12,9 @@ import (
 
 type HandshakeHandleFunc func(proto *Protocal, cmd string, details []byte) error
 type Protocal struct {
-	parent *Protocal
+	parent   *Protocal
+	detached bool
+	identity string
 
 	conn             net.Conn
 	isHandshakeDone  bool
@@ -34,7 +35,9 @@ type Protocal struct {
 
 func NewProtocal(conn net.Conn) *Protocal {
 	return &Protocal{
-		parent: nil,
+		parent:   nil,
+		detached: false,
+		identity: "",
 
 		conn:             conn,
 		isHandshakeDone:  false,
@@ -59,6 +62,35 @@ func NewProtocalWithParent(parent *Protocal, conn net.Conn) *Protocal {
 	return proto
 }
 
+// Detach keeps proto from shutting its parent down when it ends.
+func (proto *Protocal) Detach() {
+	proto.detached = true
+}
+
+// SetIdentity records the identity authenticated on proto.
+func (proto *Protocal) SetIdentity(identity string) {
+	proto.identity = identity
+}
+
+// Identity returns the identity authenticated on proto or on its nearest parent.
+func (proto *Protocal) Identity() string {
+	for p := proto; p != nil; p = p.parent {
+		if p.identity != "" {
+			return p.identity
+		}
+	}
+	return ""
+}
+
+// RemoteAddr returns the remote address of the conn the root protocal runs on.
+func (proto *Protocal) RemoteAddr() net.Addr {
+	p := proto
+	for p.parent != nil {
+		p = p.parent
+	}
+	return p.conn.RemoteAddr()
+}
+
 func (proto *Protocal) Reply(cmd string, details interface{}) error {
 	if proto.isHandshakeDone {
 		panic("protoport handshake is done, unexpect Reply call")
@@ -107,36 +139,16 @@ func (proto *Protocal) Multiplex(isClient bool) muxado.Session {
 	return muxado.Server(newReadWriteCloser(proto.handshakeDecoder.Buffered(), proto.conn), nil)
 }
 
-const (
-	wait_time_before_close = 8 * time.Second // wait other conn read data
-)
-
+// Forward copies between c1 and c2 until both directions are done, then
+// closes both. EOF on one side is passed on to the other with CloseWrite.
 func Forward(c1, c2 net.Conn) {
-	go func() {
-		io.Copy(c1, c2)
-		time.Sleep(wait_time_before_close)
-		c1.Close()
-	}()
-	io.Copy(c2, c1)
-	time.Sleep(wait_time_before_close)
-	c2.Close()
+	forward(c1, c1, c2)
 }
 
 func (proto *Protocal) Forward(conn net.Conn) {
 	proto.isHandshakeDone = true
 
-	go func() {
-		io.Copy(conn, io.MultiReader(proto.handshakeDecoder.Buffered(), proto.conn))
-		time.Sleep(wait_time_before_close)
-		conn.Close()
-	}()
-	io.Copy(proto.conn, conn)
-	time.Sleep(wait_time_before_close)
-	proto.conn.Close()
-	/*
-		go io.Copy(conn, io.MultiReader(proto.handshakeDecoder.Buffered(), proto.conn))
-		io.Copy(proto.conn, conn)
-	*/
+	forward(proto.conn, io.MultiReader(proto.handshakeDecoder.Buffered(), proto.conn), conn)
 }
 
 func (proto *Protocal) Request(cmd string, details interface{}) {
@@ -251,7 +263,7 @@ func (proto *Protocal) Shutdown(err error) {
 		proto.eventbusClosed = true
 		proto.eventbusClosedMutex.Unlock()
 
-		if proto.parent != nil {
+		if proto.parent != nil && !proto.detached {
 			proto.parent.Shutdown(err)
 		}
 	})
@@ -464,3 +464,144 @@ func TestNewProtocalWithParent(t *testing.T) {
 		}
 	}()
 }
+
+func TestProtocal_Identity(t *testing.T) {
+	conn, _ := net.Pipe()
+
+	parent_proto := NewProtocal(conn)
+	proto := NewProtocalWithParent(parent_proto, conn)
+	if proto.Identity() != "" {
+		t.Fatal(proto.Identity(), "want", "")
+	}
+
+	parent_proto.SetIdentity("test")
+	if proto.Identity() != "test" {
+		t.Fatal(proto.Identity(), "want", "test")
+	}
+
+	proto.SetIdentity("child")
+	if proto.Identity() != "child" {
+		t.Fatal(proto.Identity(), "want", "child")
+	}
+	if parent_proto.Identity() != "test" {
+		t.Fatal(parent_proto.Identity(), "want", "test")
+	}
+}
+
+func TestProtocal_Detach(t *testing.T) {
+	conn, _ := net.Pipe()
+
+	parent_proto := NewProtocal(conn)
+	proto := NewProtocalWithParent(parent_proto, conn)
+	proto.Detach()
+	proto.Shutdown(errors.New("child"))
+
+	if !proto.isShutdown() {
+		t.Fatal("expect child shutdown")
+	}
+	if parent_proto.isShutdown() {
+		t.Fatal("expect parent alive")
+	}
+
+	proto = NewProtocalWithParent(parent_proto, conn)
+	proto.Shutdown(errors.New("child"))
+	if !parent_proto.isShutdown() {
+		t.Fatal("expect parent shutdown")
+	}
+}
+
+// forwarder returns the address of a listener that Forwards every conn to
+// a backend answering "got:" and what it read until EOF.
+func forwarder(tb testing.TB) (addr string, close func()) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				data, _ := ioutil.ReadAll(conn)
+				conn.Write(append([]byte("got:"), data...))
+			}(conn)
+		}
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			remote, err := net.Dial("tcp", backend.Addr().String())
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go Forward(conn, remote)
+		}
+	}()
+
+	return ln.Addr().String(), func() {
+		ln.Close()
+		backend.Close()
+	}
+}
+
+func roundTrip(addr string, data []byte) ([]byte, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer conn.Close()
+
+	conn.Write(data)
+	conn.(*net.TCPConn).CloseWrite()
+
+	reply, err := ioutil.ReadAll(conn)
+	return reply, errors.Trace(err)
+}
+
+func TestForward_halfClose(t *testing.T) {
+	addr, close := forwarder(t)
+	defer close()
+
+	start := time.Now()
+	reply, err := roundTrip(addr, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "got:hello" {
+		t.Fatal("expect", "got:hello", "got", string(reply))
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("expect teardown right after both sides are done, took", time.Since(start))
+	}
+}
+
+func BenchmarkForward(b *testing.B) {
+	addr, close := forwarder(b)
+	defer close()
+
+	data := bytes.Repeat([]byte("x"), 1024)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := roundTrip(addr, data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "conns/s")
+}
@@ -0,0 +1,178 @@
+// Package remoteforward has the daemon listen on an address and send every
+// connection it accepts back over the session, like ssh -R.
+package remoteforward
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/protocal"
+	"github.com/service-exposer/exposer/protocal/expose"
+)
+
+const (
+	CMD_REMOTE_FORWARD       = "remote-forward"
+	CMD_REMOTE_FORWARD_REPLY = "remote-forward:reply"
+)
+
+var (
+	ErrNetwork = errors.New("not supported network")
+	ErrHost    = errors.New("host not allowed")
+)
+
+type Reply struct {
+	OK  bool
+	Err string
+
+	Address string `json:",omitempty"` // the address listened on
+}
+
+type RemoteForward struct {
+	Network string
+	// Address is the [host]:port the daemon listens on, port 0 picks a
+	// free one in Config.Ports.
+	Address string
+}
+
+// Config is the daemon side configuration of remote forward routes.
+type Config struct {
+	// Ports are the ports remote forwards may listen on, empty disables
+	// remote forwards.
+	Ports expose.PortRange
+	// Host, when set, is the only host remote forwards may listen on,
+	// otherwise they listen on the host they ask for.
+	Host string
+
+	// Audit, when set, is told the outcome of every remote forward request.
+	Audit func(identity string, forward RemoteForward, err error)
+}
+
+func (config *Config) listen(forward RemoteForward) (net.Listener, error) {
+	if forward.Network != "tcp" {
+		return nil, errors.Annotatef(ErrNetwork, "%q", forward.Network)
+	}
+
+	host, portStr, err := net.SplitHostPort(forward.Address)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, errors.Annotatef(err, "port %q", portStr)
+	}
+	if config.Host != "" {
+		if host != "" && host != config.Host {
+			return nil, errors.Annotatef(ErrHost, "%q", host)
+		}
+		host = config.Host
+	}
+
+	ln, err := config.Ports.Listen(host, port)
+	return ln, errors.Trace(err)
+}
+
+func ServerSideWithConfig(config *Config) protocal.HandshakeHandleFunc {
+	return func(proto *protocal.Protocal, cmd string, details []byte) error {
+		switch cmd {
+		case CMD_REMOTE_FORWARD:
+			var forward RemoteForward
+			err := json.Unmarshal(details, &forward)
+			if err != nil {
+				return errors.Trace(err)
+			}
+
+			ln, err := config.listen(forward)
+			if config.Audit != nil {
+				config.Audit(proto.Identity(), forward, err)
+			}
+			if err != nil {
+				proto.Reply(CMD_REMOTE_FORWARD_REPLY, &Reply{
+					OK:  false,
+					Err: err.Error(),
+				})
+
+				return errors.Trace(err)
+			}
+			defer ln.Close()
+
+			err = proto.Reply(CMD_REMOTE_FORWARD_REPLY, &Reply{
+				OK:      true,
+				Address: ln.Addr().String(),
+			})
+			if err != nil {
+				return errors.Trace(err)
+			}
+
+			session := proto.Multiplex(true)
+			defer session.Close()
+			go func() {
+				session.Wait()
+				ln.Close()
+			}()
+
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return nil
+				}
+
+				go func() {
+					remote, err := session.Open()
+					if err != nil {
+						conn.Close()
+						return
+					}
+
+					protocal.Forward(remote, conn)
+				}()
+			}
+		}
+
+		return errors.New("unknow cmd: " + cmd)
+	}
+}
+
+// ClientSide dials a local connection for every connection the daemon
+// accepted. listening, if set, is told the address the daemon listens on.
+func ClientSide(dial func() (net.Conn, error), listening func(address string)) protocal.HandshakeHandleFunc {
+	return func(proto *protocal.Protocal, cmd string, details []byte) error {
+		switch cmd {
+		case CMD_REMOTE_FORWARD_REPLY:
+			var reply Reply
+			err := json.Unmarshal(details, &reply)
+			if err != nil {
+				return errors.Trace(err)
+			}
+
+			if !reply.OK {
+				return errors.New(reply.Err)
+			}
+			if listening != nil {
+				listening(reply.Address)
+			}
+
+			session := proto.Multiplex(false)
+
+			for {
+				remote, err := session.Accept()
+				if err != nil {
+					return errors.Trace(err)
+				}
+
+				go func() {
+					local, err := dial()
+					if err != nil {
+						remote.Close()
+						return
+					}
+
+					protocal.Forward(remote, local)
+				}()
+			}
+		}
+
+		return errors.New("unknow cmd: " + cmd)
+	}
+}
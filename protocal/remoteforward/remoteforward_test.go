@@ -0,0 +1,141 @@
+package remoteforward
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/listener"
+	"github.com/service-exposer/exposer/protocal"
+	"github.com/service-exposer/exposer/protocal/expose"
+)
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func serve(config *Config) func() (net.Conn, error) {
+	ln, dial := listener.Pipe()
+
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.On = ServerSideWithConfig(config)
+		return proto
+	})
+
+	return dial
+}
+
+func Test_remoteForward(t *testing.T) {
+	port := freePort(t)
+	dial := serve(&Config{
+		Ports: expose.PortRange{Min: port, Max: port},
+	})
+
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	listening := make(chan string, 1)
+	proto := protocal.NewProtocal(conn)
+	proto.On = ClientSide(func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go func() {
+			io.Copy(c1, c1)
+			c1.Close()
+		}()
+		return c2, nil
+	}, func(address string) {
+		listening <- address
+	})
+	go proto.Request(CMD_REMOTE_FORWARD, &RemoteForward{
+		Network: "tcp",
+		Address: "127.0.0.1:0",
+	})
+
+	address := <-listening
+	if address != "127.0.0.1:"+strconv.Itoa(port) {
+		t.Fatal("expect", "127.0.0.1:"+strconv.Itoa(port), "got", address)
+	}
+
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte("hello"))
+		data := make([]byte, 5)
+		_, err = io.ReadFull(c, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "hello" {
+			t.Fatal("expect", "hello", "got", string(data))
+		}
+		c.Close()
+	}
+
+	// the daemon stops listening with the route
+	conn.Close()
+	proto.Wait()
+	for i := 0; ; i++ {
+		c, err := net.Dial("tcp", address)
+		if err != nil {
+			break
+		}
+		c.Close()
+		if i == 100 {
+			t.Fatal("expect", address, "closed")
+		}
+	}
+}
+
+func Test_remoteForward_denied(t *testing.T) {
+	port := freePort(t)
+	config := &Config{
+		Ports: expose.PortRange{Min: port, Max: port},
+		Host:  "127.0.0.1",
+	}
+	audited := make(chan error, 1)
+	config.Audit = func(identity string, forward RemoteForward, err error) {
+		audited <- err
+	}
+	dial := serve(config)
+
+	for address, expect := range map[string]error{
+		"0.0.0.0:" + strconv.Itoa(port): ErrHost,
+		":" + strconv.Itoa(port+1):      expose.ErrRemotePortRange,
+	} {
+		conn, err := dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		proto := protocal.NewProtocal(conn)
+		proto.On = ClientSide(func() (net.Conn, error) {
+			return nil, errors.New("not dialed")
+		}, nil)
+		go proto.Request(CMD_REMOTE_FORWARD, &RemoteForward{
+			Network: "tcp",
+			Address: address,
+		})
+
+		err = proto.Wait()
+		if err == nil || !strings.Contains(err.Error(), expect.Error()) {
+			t.Fatal("expect", expect, "got", err)
+		}
+		if err := <-audited; errors.Cause(err) != expect {
+			t.Fatal("expect audit", expect, "got", err)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/service-exposer/exposer/protocal/forward"
 	"github.com/service-exposer/exposer/protocal/keepalive"
 	"github.com/service-exposer/exposer/protocal/link"
+	"github.com/service-exposer/exposer/protocal/remoteforward"
 	"github.com/service-exposer/exposer/service"
 )
 
@@ -24,10 +25,17 @@ const (
 	Expose    Type = "expose"
 	Link      Type = "link"
 	Forward   Type = "forward"
+	// RemoteForward has the daemon listen and forward back to the client.
+	RemoteForward Type = "remote-forward"
+
+	// Hostname is no route, it is the type Authorizer.AllowTarget is
+	// asked with for every custom HTTP hostname of an Expose route.
+	Hostname Type = "hostname"
 )
 
 var (
 	ErrNotSupportedType = errors.New("not supported type")
+	ErrForbidden        = errors.New("forbidden")
 )
 
 type Reply struct {
@@ -39,11 +47,51 @@ type RouteReq struct {
 	Type Type
 }
 
+// Authorizer decides which routes an authenticated identity may open.
+type Authorizer interface {
+	// AllowRoute reports whether identity may open a route of type typ.
+	AllowRoute(identity string, typ Type) bool
+	// AllowTarget reports whether identity may use target on a route of
+	// type typ. target is the service name of Expose and Link routes, the
+	// address of Forward routes, the listen address of RemoteForward
+	// routes and a hostname for Hostname.
+	AllowTarget(identity string, typ Type, target string) bool
+}
+
+type Config struct {
+	Router *service.Router
+
+	// Authorizer is consulted for every route, nil allows everything.
+	Authorizer Authorizer
+
+	KeepAlive     keepalive.Config
+	Expose        expose.Config
+	Forward       forward.Config
+	RemoteForward remoteforward.Config
+
+	// Opened, when set, is called for every route accepted, proto ends
+	// with the route.
+	Opened func(proto *protocal.Protocal, typ Type)
+}
+
 func ServerSide(router *service.Router) protocal.HandshakeHandleFunc {
-	keepaliveFn := keepalive.ServerSide(0)
-	exposeFn := expose.ServerSide(router)
-	linkFn := link.ServerSide(router)
-	forwardFn := forward.ServerSide()
+	return ServerSideWithConfig(&Config{
+		Router: router,
+	})
+}
+
+func ServerSideWithConfig(config *Config) protocal.HandshakeHandleFunc {
+	keepaliveFn := keepalive.ServerSideWithConfig(&config.KeepAlive)
+	exposeFn := config.guard(Expose, expose.ServerSideWithConfig(config.Router, &config.Expose))
+	linkFn := config.guard(Link, link.ServerSide(config.Router))
+	forwardConfig := config.Forward
+	if config.Authorizer != nil && forwardConfig.Authorize == nil {
+		forwardConfig.Authorize = func(identity string, address string) bool {
+			return config.Authorizer.AllowTarget(identity, Forward, address)
+		}
+	}
+	forwardFn := config.guard(Forward, forward.ServerSideWithConfig(&forwardConfig))
+	remoteForwardFn := config.guard(RemoteForward, remoteforward.ServerSideWithConfig(&config.RemoteForward))
 
 	return func(proto *protocal.Protocal, cmd string, details []byte) error {
 		switch cmd {
@@ -54,6 +102,21 @@ func ServerSide(router *service.Router) protocal.HandshakeHandleFunc {
 				return errors.Trace(err)
 			}
 
+			// a keepalive failure ends the session, other routes end alone
+			if req.Type != KeepAlive {
+				proto.Detach()
+			}
+
+			if config.Authorizer != nil && !config.Authorizer.AllowRoute(proto.Identity(), req.Type) {
+				err := errors.Annotatef(ErrForbidden, "route %q", req.Type)
+				proto.Reply(CMD_ROUTE_REPLY, &Reply{
+					OK:  false,
+					Err: err.Error(),
+				})
+
+				return errors.Trace(err)
+			}
+
 			switch req.Type {
 			case KeepAlive:
 				err := proto.Reply(CMD_ROUTE_REPLY, &Reply{
@@ -91,6 +154,15 @@ func ServerSide(router *service.Router) protocal.HandshakeHandleFunc {
 				}
 
 				proto.On = forwardFn
+			case RemoteForward:
+				err := proto.Reply(CMD_ROUTE_REPLY, &Reply{
+					OK: true,
+				})
+				if err != nil {
+					return errors.Trace(err)
+				}
+
+				proto.On = remoteForwardFn
 			default:
 				err := errors.Annotatef(ErrNotSupportedType, "%q", req.Type)
 				proto.Reply(CMD_ROUTE_REPLY, &Reply{
@@ -101,6 +173,9 @@ func ServerSide(router *service.Router) protocal.HandshakeHandleFunc {
 				return errors.Trace(err)
 			}
 
+			if config.Opened != nil {
+				config.Opened(proto, req.Type)
+			}
 			return nil
 		}
 
@@ -108,6 +183,76 @@ func ServerSide(router *service.Router) protocal.HandshakeHandleFunc {
 	}
 }
 
+// guard checks the target of the first command on a route against
+// config.Authorizer before handing it to next.
+func (config *Config) guard(typ Type, next protocal.HandshakeHandleFunc) protocal.HandshakeHandleFunc {
+	if config.Authorizer == nil {
+		return next
+	}
+
+	return func(proto *protocal.Protocal, cmd string, details []byte) error {
+		var (
+			target    string
+			replyCmd  string
+			hostnames []string
+			err       error
+		)
+		switch cmd {
+		case expose.CMD_EXPOSE:
+			var req expose.ExposeReq
+			err = json.Unmarshal(details, &req)
+			target, replyCmd = req.Name, expose.CMD_EXPOSE_REPLY
+			hostnames = req.Attr.HTTP.Hostnames
+		case link.CMD_LINK:
+			var req link.LinkReq
+			err = json.Unmarshal(details, &req)
+			target, replyCmd = req.Name, link.CMD_LINK_REPLY
+		case forward.CMD_FORWARD:
+			var req forward.Forward
+			err = json.Unmarshal(details, &req)
+			target, replyCmd = req.Address, forward.CMD_FORWARD_REPLY
+			if err == nil && req.Dynamic {
+				// a dynamic forward has no target of its own, the
+				// CMD_DIAL of every stream is checked by
+				// forward.Config.Authorize
+				return next(proto, cmd, details)
+			}
+		case remoteforward.CMD_REMOTE_FORWARD:
+			var req remoteforward.RemoteForward
+			err = json.Unmarshal(details, &req)
+			target, replyCmd = req.Address, remoteforward.CMD_REMOTE_FORWARD_REPLY
+		default:
+			return next(proto, cmd, details)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		if !config.Authorizer.AllowTarget(proto.Identity(), typ, target) {
+			err := errors.Annotatef(ErrForbidden, "%s %q", typ, target)
+			proto.Reply(replyCmd, &Reply{
+				OK:  false,
+				Err: err.Error(),
+			})
+
+			return errors.Trace(err)
+		}
+		for _, hostname := range hostnames {
+			if !config.Authorizer.AllowTarget(proto.Identity(), Hostname, hostname) {
+				err := errors.Annotatef(ErrForbidden, "%s %q", Hostname, hostname)
+				proto.Reply(replyCmd, &Reply{
+					OK:  false,
+					Err: err.Error(),
+				})
+
+				return errors.Trace(err)
+			}
+		}
+
+		return next(proto, cmd, details)
+	}
+}
+
 func ClientSide(nextHandleFunc protocal.HandshakeHandleFunc, nextCmd string, nextDetails interface{}) protocal.HandshakeHandleFunc {
 	return func(proto *protocal.Protocal, cmd string, details []byte) error {
 		switch cmd {
@@ -1,8 +1,11 @@
 package route
 
 import (
+	"encoding/json"
 	"errors"
+	"io/ioutil"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,6 +15,7 @@ import (
 	"github.com/service-exposer/exposer/protocal/forward"
 	"github.com/service-exposer/exposer/protocal/keepalive"
 	"github.com/service-exposer/exposer/protocal/link"
+	"github.com/service-exposer/exposer/protocal/remoteforward"
 	"github.com/service-exposer/exposer/service"
 )
 
@@ -131,3 +135,189 @@ func Test_route(t *testing.T) {
 		}
 	}()
 }
+
+type testAuthorizer struct{}
+
+func (testAuthorizer) AllowRoute(identity string, typ Type) bool {
+	return typ != Forward
+}
+
+func (testAuthorizer) AllowTarget(identity string, typ Type, target string) bool {
+	return target == identity
+}
+
+func Test_routeAuthorizer(t *testing.T) {
+	ln, dial := listener.Pipe()
+
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.SetIdentity("test")
+		proto.On = ServerSideWithConfig(&Config{
+			Router:     service.NewRouter(),
+			Authorizer: testAuthorizer{},
+		})
+		return proto
+	})
+
+	request := func(typ Type, nextCmd string, nextDetails interface{}) error {
+		conn, err := dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		proto := protocal.NewProtocal(conn)
+		proto.On = ClientSide(func(proto *protocal.Protocal, cmd string, details []byte) error {
+			var reply Reply
+			json.Unmarshal(details, &reply)
+			if !reply.OK {
+				return errors.New(reply.Err)
+			}
+			return errors.New("ok")
+		}, nextCmd, nextDetails)
+		go proto.Request(CMD_ROUTE, &RouteReq{
+			Type: typ,
+		})
+
+		return proto.Wait()
+	}
+
+	err := request(Forward, forward.CMD_FORWARD, &forward.Forward{})
+	if !strings.Contains(err.Error(), ErrForbidden.Error()) {
+		t.Fatal(err, "want", ErrForbidden)
+	}
+
+	err = request(Expose, expose.CMD_EXPOSE, &expose.ExposeReq{
+		Name: "other",
+	})
+	if !strings.Contains(err.Error(), ErrForbidden.Error()) {
+		t.Fatal(err, "want", ErrForbidden)
+	}
+
+	req := &expose.ExposeReq{
+		Name: "test",
+	}
+	req.Attr.HTTP.Hostnames = []string{"other.example.test"}
+	err = request(Expose, expose.CMD_EXPOSE, req)
+	if !strings.Contains(err.Error(), ErrForbidden.Error()) || !strings.Contains(err.Error(), "other.example.test") {
+		t.Fatal(err, "want", ErrForbidden)
+	}
+
+	err = request(Link, link.CMD_LINK, &link.LinkReq{
+		Name: "test",
+	})
+	if !strings.Contains(err.Error(), link.ErrServiceIsNotExist.Error()) {
+		t.Fatal(err, "want", link.ErrServiceIsNotExist)
+	}
+
+	err = request(RemoteForward, remoteforward.CMD_REMOTE_FORWARD, &remoteforward.RemoteForward{
+		Network: "tcp",
+		Address: "0.0.0.0:2222",
+	})
+	if !strings.Contains(err.Error(), ErrForbidden.Error()) || !strings.Contains(err.Error(), "0.0.0.0:2222") {
+		t.Fatal(err, "want", ErrForbidden)
+	}
+}
+
+type forwardAuthorizer struct{}
+
+func (forwardAuthorizer) AllowRoute(identity string, typ Type) bool {
+	return true
+}
+
+func (forwardAuthorizer) AllowTarget(identity string, typ Type, target string) bool {
+	return target == "allowed:1"
+}
+
+func Test_routeDynamicForward(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("denied"))
+			conn.Close()
+		}
+	}()
+
+	ln, dial := listener.Pipe()
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		proto := protocal.NewProtocal(conn)
+		proto.SetIdentity("test")
+		proto.On = ServerSideWithConfig(&Config{
+			Router:     service.NewRouter(),
+			Authorizer: forwardAuthorizer{},
+		})
+		return proto
+	})
+
+	// a plain forward stream on a dynamic forward skips CMD_DIAL
+	read := func(req *forward.Forward) (string, error) {
+		conn, err := dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		local_ln, local_dial := listener.Pipe()
+		replied := make(chan struct{})
+		handlefn := forward.ClientSide(local_ln)
+		proto := protocal.NewProtocal(conn)
+		proto.On = ClientSide(func(proto *protocal.Protocal, cmd string, details []byte) error {
+			close(replied)
+			return handlefn(proto, cmd, details)
+		}, forward.CMD_FORWARD, req)
+		go proto.Request(CMD_ROUTE, &RouteReq{
+			Type: Forward,
+		})
+
+		done := make(chan error, 1)
+		go func() {
+			done <- proto.Wait()
+		}()
+		select {
+		case <-replied:
+		case err := <-done:
+			return "", err
+		}
+
+		local_conn, err := local_dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer local_conn.Close()
+		local_conn.SetReadDeadline(time.Now().Add(time.Second))
+
+		data, _ := ioutil.ReadAll(local_conn)
+		select {
+		case err := <-done:
+			return string(data), err
+		default:
+			return string(data), nil
+		}
+	}
+
+	data, err := read(&forward.Forward{
+		Network: "tcp",
+		Address: backend.Addr().String(),
+		Dynamic: true,
+	})
+	if data != "" {
+		t.Fatal("expect stream refused got", data)
+	}
+	if err == nil || !strings.Contains(err.Error(), forward.ErrDynamicTarget.Error()) {
+		t.Fatal("expect", forward.ErrDynamicTarget, "got", err)
+	}
+
+	data, _ = read(&forward.Forward{
+		Dynamic: true,
+	})
+	if data != "" {
+		t.Fatal("expect stream refused got", data)
+	}
+}
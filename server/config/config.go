@@ -0,0 +1,249 @@
+// Package config reads the daemon configuration file.
+package config
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"reflect"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/acl"
+	"github.com/service-exposer/exposer/netacl"
+	"github.com/service-exposer/exposer/protocal/expose"
+	"github.com/service-exposer/exposer/protocal/forward"
+	"github.com/service-exposer/exposer/protocal/remoteforward"
+	"github.com/service-exposer/exposer/server"
+	"github.com/service-exposer/exposer/service"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is a daemon configuration file:
+//
+//	listen: 0.0.0.0:9000
+//	tls:
+//	  cert: server.crt
+//	  key: server.key
+//	key: api-key
+//	identities:
+//	  - identity: alice
+//	    key: alice-key
+//	    actions: [keepalive, expose]
+//	    services: [alice-*]
+//	forward:
+//	  allow: [10.0.0.0/8:22]
+//	http:
+//	  domain: example.org
+//
+// Key, Identities, ACL, Forward and Limits are reloaded on SIGHUP, the
+// rest applies on restart, see Restart.
+type Config struct {
+	Listen        string `yaml:"listen"`
+	MetricsListen string `yaml:"metrics_listen,omitempty"`
+	TLS           TLS    `yaml:"tls,omitempty"`
+
+	// Key authenticates the API, and sessions if there are no identities.
+	Key             string `yaml:"key,omitempty"`
+	AllowLegacyAuth bool   `yaml:"allow_legacy_auth,omitempty"`
+	// Identities are the access rules of client sessions. ACL is a JSON
+	// file of them, used when Identities is empty.
+	Identities []acl.Rule `yaml:"identities,omitempty"`
+	ACL        string     `yaml:"acl,omitempty"`
+
+	Forward     Forward `yaml:"forward,omitempty"`
+	RemotePorts string  `yaml:"remote_ports,omitempty"` // port[-port], of expose and remote-forward
+	RemoteHost  string  `yaml:"remote_host,omitempty"`
+	HTTP        HTTP    `yaml:"http,omitempty"`
+	// Limits cap the limits exposers ask for their services, 0 is
+	// unlimited. A reload caps the services exposed already too.
+	Limits Limits `yaml:"limits,omitempty"`
+	// ExposeGrace is how long the name of a dropped exposer stays
+	// reserved for it, 0 frees it at once.
+	ExposeGrace time.Duration `yaml:"expose_grace,omitempty"`
+
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout,omitempty"`
+}
+
+type TLS struct {
+	Cert string `yaml:"cert,omitempty"`
+	Key  string `yaml:"key,omitempty"`
+}
+
+// Forward is the destinations clients may forward to, see netacl.Config.
+type Forward struct {
+	Allow          []string `yaml:"allow,omitempty"`
+	Deny           []string `yaml:"deny,omitempty"`
+	AllowLoopback  bool     `yaml:"allow_loopback,omitempty"`
+	AllowLinkLocal bool     `yaml:"allow_link_local,omitempty"`
+}
+
+// Limits are service.Limits.
+type Limits struct {
+	MaxStreams          int   `yaml:"max_streams,omitempty"`
+	StreamsPerSecond    int   `yaml:"streams_per_second,omitempty"`
+	ReadBytesPerSecond  int64 `yaml:"read_bytes_per_second,omitempty"` // from the service
+	WriteBytesPerSecond int64 `yaml:"write_bytes_per_second,omitempty"`
+}
+
+type HTTP struct {
+	Domain    string   `yaml:"domain,omitempty"`
+	Hostnames []string `yaml:"hostnames,omitempty"`
+}
+
+// Default is the configuration of a daemon started without a file.
+func Default() *Config {
+	return &Config{
+		Listen:          "0.0.0.0:9000",
+		ExposeGrace:     30 * time.Second,
+		ShutdownTimeout: 30 * time.Second,
+	}
+}
+
+func Load(filename string) (*Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	config, err := Parse(data)
+	return config, errors.Annotate(err, filename)
+}
+
+// Parse reads a configuration over Default.
+func Parse(data []byte) (*Config, error) {
+	config := Default()
+	err := yaml.UnmarshalStrict(data, config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	_, err = config.Rules()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return config, nil
+}
+
+// Rules returns the access rules of client sessions, nil allows
+// everything to Key.
+func (config *Config) Rules() ([]acl.Rule, error) {
+	rules := config.Identities
+	if len(rules) == 0 && config.ACL != "" {
+		var err error
+		rules, err = acl.LoadRules(config.ACL)
+		if err != nil {
+			return nil, errors.Annotate(err, "load ACL")
+		}
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	// check them the way Server.Reload will
+	_, err := acl.NewPolicy(rules)
+	return rules, errors.Annotate(err, "identities")
+}
+
+// ForwardPolicy returns the forward destinations of config, for
+// netacl.New on reload.
+func (config *Config) ForwardPolicy() netacl.Config {
+	return netacl.Config{
+		Allow:          config.Forward.Allow,
+		Deny:           config.Forward.Deny,
+		AllowLoopback:  config.Forward.AllowLoopback,
+		AllowLinkLocal: config.Forward.AllowLinkLocal,
+	}
+}
+
+// ServiceLimits returns the cap of the service limits.
+func (config *Config) ServiceLimits() service.Limits {
+	return service.Limits{
+		MaxStreams:          config.Limits.MaxStreams,
+		StreamsPerSecond:    config.Limits.StreamsPerSecond,
+		ReadBytesPerSecond:  config.Limits.ReadBytesPerSecond,
+		WriteBytesPerSecond: config.Limits.WriteBytesPerSecond,
+	}
+}
+
+// Restart returns the names of the fields that differ from running and
+// only apply on restart.
+func (config *Config) Restart(running *Config) []string {
+	var fields []string
+	changed := func(name string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			fields = append(fields, name)
+		}
+	}
+
+	changed("listen", config.Listen, running.Listen)
+	changed("metrics_listen", config.MetricsListen, running.MetricsListen)
+	changed("tls", config.TLS, running.TLS)
+	changed("allow_legacy_auth", config.AllowLegacyAuth, running.AllowLegacyAuth)
+	changed("remote_ports", config.RemotePorts, running.RemotePorts)
+	changed("remote_host", config.RemoteHost, running.RemoteHost)
+	changed("http", config.HTTP, running.HTTP)
+	changed("expose_grace", config.ExposeGrace, running.ExposeGrace)
+	changed("shutdown_timeout", config.ShutdownTimeout, running.ShutdownTimeout)
+	return fields
+}
+
+// Options returns the server options of config. The callers add hooks
+// like forward.Config.Audit.
+func (config *Config) Options() (server.Options, error) {
+	var opts server.Options
+
+	rules, err := config.Rules()
+	if err != nil {
+		return opts, errors.Trace(err)
+	}
+	policy := acl.AllowAll(config.Key)
+	if rules != nil {
+		policy, err = acl.NewPolicy(rules)
+		if err != nil {
+			return opts, errors.Annotate(err, "identities")
+		}
+	}
+
+	forwardPolicy, err := netacl.New(config.ForwardPolicy())
+	if err != nil {
+		return opts, errors.Annotate(err, "forward policy")
+	}
+
+	remotePorts, err := expose.ParsePortRange(config.RemotePorts)
+	if err != nil {
+		return opts, errors.Annotate(err, "remote ports")
+	}
+
+	var tlsConf *tls.Config
+	if config.TLS.Cert != "" || config.TLS.Key != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLS.Cert, config.TLS.Key)
+		if err != nil {
+			return opts, errors.Annotate(err, "LoadX509KeyPair")
+		}
+		tlsConf = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}
+	}
+
+	return server.Options{
+		Key:             config.Key,
+		Policy:          policy,
+		AllowLegacyAuth: config.AllowLegacyAuth,
+		Limits:          config.ServiceLimits(),
+		Expose: expose.Config{
+			RemotePorts: remotePorts,
+			RemoteHost:  config.RemoteHost,
+			Grace:       config.ExposeGrace,
+		},
+		Forward: forward.Config{
+			Policy: forwardPolicy,
+		},
+		RemoteForward: remoteforward.Config{
+			Ports: remotePorts,
+			Host:  config.RemoteHost,
+		},
+		HTTPDomain:    config.HTTP.Domain,
+		HTTPHostnames: config.HTTP.Hostnames,
+		TLSConfig:     tlsConf,
+	}, nil
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/service-exposer/exposer/protocal/route"
+)
+
+func TestParse(t *testing.T) {
+	config, err := Parse([]byte(`
+listen: 127.0.0.1:9001
+key: api-key
+identities:
+  - identity: alice
+    key: alice-key
+    actions: [keepalive, expose]
+    services: [alice-*]
+forward:
+  allow: [10.0.0.0/8:22]
+  allow_loopback: true
+remote_ports: 10000-10010
+http:
+  domain: example.org
+expose_grace: 1m
+limits:
+  max_streams: 3
+shutdown_timeout: 5s
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Listen != "127.0.0.1:9001" {
+		t.Fatal("expect", "127.0.0.1:9001", "got", config.Listen)
+	}
+	if config.ShutdownTimeout != 5*time.Second {
+		t.Fatal("expect", 5*time.Second, "got", config.ShutdownTimeout)
+	}
+
+	opts, err := config.Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Key != "api-key" || opts.HTTPDomain != "example.org" {
+		t.Fatal("expect key and domain got", opts.Key, opts.HTTPDomain)
+	}
+	if opts.Expose.RemotePorts.Min != 10000 || opts.Expose.RemotePorts.Max != 10010 {
+		t.Fatal("expect 10000-10010 got", opts.Expose.RemotePorts)
+	}
+	if opts.Expose.Grace != time.Minute {
+		t.Fatal("expect", time.Minute, "got", opts.Expose.Grace)
+	}
+	if opts.Limits.MaxStreams != 3 {
+		t.Fatal("expect", 3, "got", opts.Limits)
+	}
+	if !opts.Policy.AllowTarget("alice", route.Expose, "alice-web") {
+		t.Fatal("expect alice may expose alice-web")
+	}
+	if opts.Policy.AllowRoute("alice", route.Forward) {
+		t.Fatal("expect alice may not forward")
+	}
+	if _, ok := opts.Policy.Authenticate("api-key"); ok {
+		t.Fatal("expect API key not a session key with identities")
+	}
+}
+
+func TestParse_default(t *testing.T) {
+	config, err := Parse([]byte("key: k\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Listen != Default().Listen {
+		t.Fatal("expect", Default().Listen, "got", config.Listen)
+	}
+
+	rules, err := config.Rules()
+	if err != nil || rules != nil {
+		t.Fatal("expect no rules got", rules, err)
+	}
+}
+
+func TestParse_invalid(t *testing.T) {
+	for _, data := range []string{
+		"unknown: 1\n",
+		"identities:\n  - identity: alice\n",
+		"identities:\n  - {identity: a, key: k}\n  - {identity: b, key: k}\n",
+		"acl: /not/exist.json\n",
+	} {
+		_, err := Parse([]byte(data))
+		if err == nil {
+			t.Fatal("expect error for", data)
+		}
+	}
+}
+
+func TestConfig_Restart(t *testing.T) {
+	running, err := Parse([]byte("key: k\nforward:\n  deny: [10.0.0.0/8]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := Parse([]byte("key: k2\nremote_host: example.org\nforward:\n  allow_loopback: true\nhttp:\n  domain: example.org\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := config.Restart(running)
+	if strings.Join(fields, ",") != "remote_host,http" {
+		t.Fatal("expect", "remote_host,http", "got", fields)
+	}
+	if fields := running.Restart(running); len(fields) != 0 {
+		t.Fatal("expect", "none", "got", fields)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/service-exposer/exposer/listener"
+)
+
+// trackedConn calls onClose once when it is closed.
+type trackedConn struct {
+	net.Conn
+	once    *sync.Once
+	onClose func()
+}
+
+func newTrackedConn(conn net.Conn, onClose func()) net.Conn {
+	return &trackedConn{
+		Conn:    conn,
+		once:    new(sync.Once),
+		onClose: onClose,
+	}
+}
+
+func (conn *trackedConn) CloseWrite() error {
+	return listener.CloseWrite(conn.Conn)
+}
+
+func (conn *trackedConn) Close() error {
+	conn.once.Do(conn.onClose)
+	return conn.Conn.Close()
+}
+
+// countingConn adds the bytes it reads and writes to *received and *sent.
+type countingConn struct {
+	net.Conn
+	received *int64
+	sent     *int64
+}
+
+func (conn *countingConn) Read(b []byte) (int, error) {
+	n, err := conn.Conn.Read(b)
+	atomic.AddInt64(conn.received, int64(n))
+	return n, err
+}
+
+func (conn *countingConn) Write(b []byte) (int, error) {
+	n, err := conn.Conn.Write(b)
+	atomic.AddInt64(conn.sent, int64(n))
+	return n, err
+}
+
+func (conn *countingConn) CloseWrite() error {
+	return listener.CloseWrite(conn.Conn)
+}
+
+// wsAddr is the address of the websocket listener, which shares the
+// sockets of Serve.
+type wsAddr struct{}
+
+func (wsAddr) Network() string { return "websocket" }
+func (wsAddr) String() string  { return "server" }
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/service-exposer/exposer/service"
+)
+
+// EventsKeepAlive is how often /api/events writes a comment, so proxies
+// keep idle streams open.
+var EventsKeepAlive = 30 * time.Second
+
+// serveEvents streams the changes of the router as Server-Sent Events,
+// starting with an added event for every current service. The stream ends
+// when the client falls behind, it should connect again then.
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	events, cancel := s.router.Watch()
+	defer cancel()
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, svc := range s.router.All() {
+		writeEvent(w, service.Event{
+			Type: service.EventAdded,
+			Name: svc.Name(),
+			Info: svc.Info(),
+		})
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	ticker := time.NewTicker(EventsKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			writeEvent(w, event)
+		case <-ticker.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-s.drain:
+			return
+		}
+
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w io.Writer, event service.Event) {
+	data, err := json.Marshal(&event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net"
+
+	"github.com/service-exposer/exposer/listener"
+	"github.com/service-exposer/exposer/metrics"
+	"github.com/service-exposer/exposer/protocal"
+	"github.com/service-exposer/exposer/protocal/route"
+)
+
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	routes            *metrics.Vec
+	received          *metrics.Vec
+	sent              *metrics.Vec
+	openFailures      *metrics.Vec
+	authFailures      *metrics.Vec
+	keepaliveTimeouts *metrics.Vec
+	forwardDial       *metrics.Histogram
+}
+
+func newServerMetrics(s *Server) *serverMetrics {
+	r := metrics.NewRegistry()
+
+	r.GaugeFunc("exposer_sessions", "Connected client sessions.", nil, func(set func(float64, ...string)) {
+		s.mu.Lock()
+		n := len(s.sessions)
+		s.mu.Unlock()
+
+		set(float64(n))
+	})
+	r.GaugeFunc("exposer_service_streams", "Open streams to a service.", []string{"service"}, func(set func(float64, ...string)) {
+		for _, service := range s.router.All() {
+			set(float64(service.Active()), service.Name())
+		}
+	})
+
+	m := &serverMetrics{
+		registry: r,
+
+		routes:            r.Gauge("exposer_routes", "Open routes of client sessions by type.", "type"),
+		received:          r.Counter("exposer_service_received_bytes_total", "Bytes read from a service.", "service"),
+		sent:              r.Counter("exposer_service_sent_bytes_total", "Bytes written to a service.", "service"),
+		openFailures:      r.Counter("exposer_service_open_failures_total", "Failed opens of a service.", "service"),
+		authFailures:      r.Counter("exposer_auth_failures_total", "Refused client sessions."),
+		keepaliveTimeouts: r.Counter("exposer_keepalive_timeouts_total", "Client sessions that missed their pings."),
+		forwardDial: r.Histogram("exposer_forward_dial_seconds", "Time to connect to forward destinations.",
+			[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}),
+	}
+
+	// report 0 before the first event
+	m.authFailures.With()
+	m.keepaliveTimeouts.With()
+	return m
+}
+
+func (m *serverMetrics) routeOpened(proto *protocal.Protocal, typ route.Type) {
+	gauge := m.routes.With(string(typ))
+	gauge.Inc()
+	go func() {
+		proto.Wait()
+		gauge.Dec()
+	}()
+}
+
+// Opened and OpenFailed make serverMetrics a service.Observer.
+func (m *serverMetrics) Opened(name string, conn net.Conn) net.Conn {
+	return &meteredConn{
+		Conn:     conn,
+		received: m.received.With(name),
+		sent:     m.sent.With(name),
+	}
+}
+
+func (m *serverMetrics) OpenFailed(name string, err error) {
+	m.openFailures.With(name).Inc()
+}
+
+// meteredConn counts the bytes through a service conn.
+type meteredConn struct {
+	net.Conn
+	received *metrics.Value
+	sent     *metrics.Value
+}
+
+func (conn *meteredConn) Read(b []byte) (int, error) {
+	n, err := conn.Conn.Read(b)
+	conn.received.Add(int64(n))
+	return n, err
+}
+
+func (conn *meteredConn) Write(b []byte) (int, error) {
+	n, err := conn.Conn.Write(b)
+	conn.sent.Add(int64(n))
+	return n, err
+}
+
+func (conn *meteredConn) CloseWrite() error {
+	return listener.CloseWrite(conn.Conn)
+}
@@ -0,0 +1,539 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/acl"
+	"github.com/service-exposer/exposer/httpproxy"
+	"github.com/service-exposer/exposer/listener/utils"
+	"github.com/service-exposer/exposer/protocal"
+	"github.com/service-exposer/exposer/protocal/auth"
+	"github.com/service-exposer/exposer/protocal/expose"
+	"github.com/service-exposer/exposer/protocal/forward"
+	"github.com/service-exposer/exposer/protocal/keepalive"
+	"github.com/service-exposer/exposer/protocal/remoteforward"
+	"github.com/service-exposer/exposer/protocal/route"
+	"github.com/service-exposer/exposer/service"
+	"github.com/urfave/negroni"
+)
+
+var (
+	ErrServerClosed = errors.New("server closed")
+)
+
+// Options configures a Server. The zero value serves everything to
+// clients authenticated by Key.
+type Options struct {
+	// Key authenticates /api requests, and sessions if Policy is nil.
+	Key string
+	// Policy authenticates sessions and authorizes their routes,
+	// acl.AllowAll(Key) if nil.
+	Policy *acl.Policy
+	// AllowLegacyAuth accepts old clients that send the key in plaintext,
+	// to the API too.
+	AllowLegacyAuth bool
+	// Limits cap the limits exposers ask for their services.
+	Limits service.Limits
+
+	Expose        expose.Config
+	Forward       forward.Config
+	RemoteForward remoteforward.Config
+
+	// HTTPDomain routes requests for <name>.<HTTPDomain> to the HTTP
+	// service name. HTTPHostnames are the patterns of custom hostnames
+	// HTTP services may claim.
+	HTTPDomain    string
+	HTTPHostnames []string
+
+	// TLSConfig, if set, makes Serve speak HTTPS.
+	TLSConfig *tls.Config
+}
+
+// Server is the daemon side of exposer.
+type Server struct {
+	forwards int64  // atomic, open forward streams, first for 64-bit alignment
+	lastID   uint64 // atomic, of the last session
+
+	opts    Options
+	router  *service.Router
+	proxy   *httpproxy.Proxy
+	metrics *serverMetrics
+	wsln    net.Listener
+	handler http.Handler
+
+	apiTokens *auth.APITokens // used ones, each is accepted once
+
+	drain     chan struct{}
+	drainOnce *sync.Once
+
+	mu       *sync.Mutex
+	key      string // API key, changed by Reload
+	closed   bool
+	servers  map[*http.Server]struct{}
+	sessions map[net.Conn]*session
+}
+
+func New(opts Options) (*Server, error) {
+	if opts.Policy == nil {
+		opts.Policy = acl.AllowAll(opts.Key)
+	}
+
+	wsln, wsHandler, err := utils.WebsocketHandlerListener(wsAddr{})
+	if err != nil {
+		return nil, errors.Annotate(err, "listen ws")
+	}
+
+	s := &Server{
+		forwards: 0,
+		lastID:   0,
+
+		opts:    opts,
+		router:  service.NewRouter(),
+		proxy:   nil,
+		metrics: nil,
+		wsln:    wsln,
+		handler: nil,
+
+		apiTokens: auth.NewAPITokens(),
+
+		drain:     make(chan struct{}),
+		drainOnce: new(sync.Once),
+
+		mu:       new(sync.Mutex),
+		key:      opts.Key,
+		closed:   false,
+		servers:  make(map[*http.Server]struct{}),
+		sessions: make(map[net.Conn]*session),
+	}
+	s.router.CapLimits(opts.Limits)
+	s.proxy = httpproxy.New(s.router)
+	s.metrics = newServerMetrics(s)
+	s.router.SetObserver(s.metrics)
+	s.handler = s.newHandler(wsHandler)
+
+	dial := opts.Forward.Dial
+	if dial == nil {
+		dial = net.Dial
+	}
+	s.opts.Forward.Dial = func(network, address string) (net.Conn, error) {
+		start := time.Now()
+		conn, err := dial(network, address)
+		s.metrics.forwardDial.Observe(time.Since(start).Seconds())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		atomic.AddInt64(&s.forwards, 1)
+		return newTrackedConn(conn, func() {
+			atomic.AddInt64(&s.forwards, -1)
+		}), nil
+	}
+
+	go protocal.Serve(wsln, func(conn net.Conn) protocal.ProtocalHandler {
+		sess := s.track(conn)
+		policy := s.opts.Policy
+
+		// targets of dynamic forward streams are checked one by one but not
+		// kept as grants of the session, there is no end to them
+		forwardConfig := s.opts.Forward
+		forwardConfig.Authorize = func(identity string, address string) bool {
+			return policy.AllowTarget(identity, route.Forward, address)
+		}
+
+		proto := protocal.NewProtocal(sess.conn)
+		proto.On = auth.ServerSideWithConfig(&auth.Config{
+			Route: route.Config{
+				Router: s.router,
+				Authorizer: &authorizer{
+					policy: policy,
+					sess:   sess,
+				},
+				KeepAlive: keepalive.Config{
+					Drain: s.drain,
+					OnTimeout: func() {
+						s.metrics.keepaliveTimeouts.With().Inc()
+					},
+					OnHealth: sess.setHealth,
+				},
+				Expose:        s.opts.Expose,
+				Forward:       forwardConfig,
+				RemoteForward: s.opts.RemoteForward,
+				Opened: func(proto *protocal.Protocal, typ route.Type) {
+					s.metrics.routeOpened(proto, typ)
+					sess.routeOpened(proto, typ)
+				},
+			},
+			Authenticate: func(key string) (string, bool) {
+				identity, ok := policy.Authenticate(key)
+				if ok {
+					sess.authenticated(identity, key)
+				}
+				return identity, ok
+			},
+			Key: func(identity string) (string, bool) {
+				key, ok := policy.Key(identity)
+				if ok {
+					sess.authenticated(identity, key)
+				}
+				return key, ok
+			},
+			AllowLegacy: s.opts.AllowLegacyAuth,
+			Denied: func(err error) {
+				s.metrics.authFailures.With().Inc()
+			},
+		})
+		return proto
+	})
+
+	return s, nil
+}
+
+// Router is the services of the server.
+func (s *Server) Router() *service.Router {
+	return s.router
+}
+
+// Handler serves the API, the HTTP services and the client sessions,
+// for use with a http.Server of the caller.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// MetricsHandler serves the metrics of the server in the Prometheus text
+// format. Handler serves them too, at /metrics behind the API key.
+func (s *Server) MetricsHandler() http.Handler {
+	return s.metrics.registry
+}
+
+// Reload swaps in the API key and the rules of the policy, nil rules
+// allow everything to key. Sessions keep running unless their key, or a
+// route or target they were granted, is no longer allowed; those are
+// closed and counted in revoked. Invalid rules fail before anything
+// changed.
+func (s *Server) Reload(key string, rules []acl.Rule) (revoked int, err error) {
+	if rules == nil {
+		rules = []acl.Rule{acl.AllowAllRule(key)}
+	}
+	err = s.opts.Policy.Replace(rules)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	s.mu.Lock()
+	s.key = key
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		if !sess.allowed(s.opts.Policy) {
+			sess.conn.Close()
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// SetLimits replaces Options.Limits, the services exposed already are
+// capped by the new limits too.
+func (s *Server) SetLimits(limits service.Limits) {
+	s.router.CapLimits(limits)
+}
+
+// Sessions returns the authenticated client sessions, oldest first.
+func (s *Server) Sessions() []SessionInfo {
+	s.mu.Lock()
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		sess.mu.Lock()
+		authed := sess.authed
+		sess.mu.Unlock()
+		if authed {
+			infos = append(infos, sess.info())
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Start.Before(infos[j].Start)
+	})
+	return infos
+}
+
+// CloseSession closes the client session id and every route on it, it
+// reports whether there was such a session.
+func (s *Server) CloseSession(id string) bool {
+	s.mu.Lock()
+	var found *session
+	for _, sess := range s.sessions {
+		if sess.id == id {
+			found = sess
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if found == nil {
+		return false
+	}
+	found.conn.Close()
+	return true
+}
+
+// Serve accepts connections on ln until Shutdown, and always returns a
+// non-nil error.
+func (s *Server) Serve(ln net.Listener) error {
+	if s.opts.TLSConfig != nil {
+		ln = tls.NewListener(ln, s.opts.TLSConfig)
+	}
+
+	server := &http.Server{
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		Handler:      s.handler,
+		TLSConfig:    s.opts.TLSConfig,
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return errors.Trace(ErrServerClosed)
+	}
+	s.servers[server] = struct{}{}
+	s.mu.Unlock()
+
+	err := server.Serve(ln)
+	if err == http.ErrServerClosed {
+		err = ErrServerClosed
+	}
+	return errors.Trace(err)
+}
+
+// Shutdown stops accepting sessions, tells connected clients to drain
+// and waits until in-flight streams are done or ctx is, then closes the
+// router and every session left. It returns ctx.Err() if streams were
+// cut off.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	servers := make([]*http.Server, 0, len(s.servers))
+	for server := range s.servers {
+		servers = append(servers, server)
+	}
+	s.mu.Unlock()
+
+	s.wsln.Close()
+	s.drainOnce.Do(func() {
+		close(s.drain)
+	})
+
+	var err error
+	for _, server := range servers {
+		if e := server.Shutdown(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for err == nil && s.active() > 0 {
+		s.proxy.CloseIdleConnections()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	s.router.Close()
+
+	s.mu.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[net.Conn]*session)
+	s.mu.Unlock()
+
+	for conn := range sessions {
+		conn.Close()
+	}
+
+	return errors.Trace(err)
+}
+
+// active returns the number of in-flight streams.
+func (s *Server) active() int64 {
+	return s.router.Active() + atomic.LoadInt64(&s.forwards)
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+// track registers conn as a session until it is closed.
+func (s *Server) track(conn net.Conn) *session {
+	var tracked net.Conn
+	tracked = newTrackedConn(conn, func() {
+		s.mu.Lock()
+		delete(s.sessions, tracked)
+		s.mu.Unlock()
+	})
+	sess := newSession(strconv.FormatUint(atomic.AddUint64(&s.lastID, 1), 10), tracked)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		tracked.Close()
+		return sess
+	}
+	s.sessions[tracked] = sess
+	s.mu.Unlock()
+
+	return sess
+}
+
+func (s *Server) apiKey() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.key
+}
+
+func (s *Server) newHandler(wsHandler http.Handler) http.Handler {
+	r := mux.NewRouter()
+
+	r.Path("/api/services").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		services := s.router.All()
+
+		result := make(map[string]service.Info)
+		for _, s := range services {
+			result[s.Name()] = s.Info()
+		}
+
+		json.NewEncoder(w).Encode(&result)
+
+	}).Methods("GET")
+
+	r.Path("/api/services/{name}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if s.router.Get(name) == nil {
+			http.Error(w, "service is not exist", 404)
+			return
+		}
+
+		// closes the session of the exposer through Service.Close
+		s.router.Remove(name)
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("DELETE")
+
+	r.Path("/api/sessions").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(s.Sessions())
+	}).Methods("GET")
+
+	r.Path("/api/sessions/{id}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.CloseSession(mux.Vars(r)["id"]) {
+			http.Error(w, "session is not exist", 404)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("DELETE")
+
+	r.Path("/api/events").HandlerFunc(s.serveEvents).Methods("GET")
+
+	r.Path("/metrics").Handler(s.metrics.registry).Methods("GET")
+
+	r.PathPrefix("/service/{name}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		var (
+			name = vars["name"]
+		)
+
+		if s.router.Get(name) == nil {
+			http.Error(w, "service is not exist", 404)
+			return
+		}
+
+		if r.URL.Path == "/service/"+name {
+			http.Redirect(w, r, "/service/"+name+"/", 302)
+			return
+		}
+
+		s.proxy.ServeService(w, r, name, "/service/"+name)
+	})
+
+	n := negroni.New()
+
+	// HTTP services by Host header
+	n.UseFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		service := s.router.MatchHost(r.Host, s.opts.HTTPDomain, s.opts.HTTPHostnames)
+		if service == nil {
+			next(w, r)
+			return
+		}
+
+		s.proxy.ServeService(w, r, service.Name(), "")
+	})
+
+	// ws
+	n.UseFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if strings.HasPrefix(r.URL.Path, "/service/") {
+			next(w, r)
+			return
+		}
+
+		connection := r.Header.Get("Connection")
+		upgrade := r.Header.Get("Upgrade")
+		if connection == "Upgrade" && upgrade == "websocket" {
+			if s.isClosed() {
+				http.Error(w, ErrServerClosed.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			wsHandler.ServeHTTP(w, r)
+			return
+		}
+
+		next(w, r)
+	})
+
+	// auth
+	n.UseFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/metrics" {
+			next(w, r)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		key, now := s.apiKey(), time.Now()
+		if !(auth.VerifyAPIToken(key, r.Method, r.URL.Path, token, now) && s.apiTokens.Use(token, now)) &&
+			!(s.opts.AllowLegacyAuth && token == key) {
+			w.WriteHeader(401)
+			fmt.Fprintln(w, "Please set Header Authorization as a token of Key")
+			return
+		}
+
+		next(w, r)
+	})
+
+	n.UseHandler(r)
+	return n
+}
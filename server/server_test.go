@@ -0,0 +1,571 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/acl"
+	"github.com/service-exposer/exposer/client"
+	"github.com/service-exposer/exposer/protocal/auth"
+	"github.com/service-exposer/exposer/protocal/keepalive"
+	"github.com/service-exposer/exposer/protocal/route"
+	"github.com/service-exposer/exposer/service"
+	"github.com/service-exposer/exposer/socks5"
+)
+
+func serve(t *testing.T) (*Server, string) {
+	s, err := New(Options{
+		Key: "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+
+	return s, "ws://" + ln.Addr().String()
+}
+
+func echo(conn net.Conn) {
+	io.Copy(conn, conn)
+	conn.Close()
+}
+
+// link exposes an echo service and returns a connection to it.
+func link(t *testing.T, ctx context.Context, url string) (net.Conn, *client.Client) {
+	exposer, err := client.Dial(ctx, url, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = exposer.Expose(ctx, "echo", service.Attribute{}, func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go echo(c1)
+		return c2, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	linker, err := client.Dial(ctx, url, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnel, err := linker.Link(ctx, "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tunnel.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Write([]byte("hello"))
+	data := make([]byte, 5)
+	_, err = io.ReadAtLeast(conn, data, len(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatal("expect hello got", string(data))
+	}
+
+	return conn, exposer
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	s, url := serve(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, exposer := link(t, ctx, url)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(ctx)
+	}()
+
+	select {
+	case <-exposer.Draining():
+	case <-time.After(time.Second):
+		t.Fatal("expect exposer draining")
+	}
+
+	select {
+	case err := <-shutdown:
+		t.Fatal("expect Shutdown waiting for the open stream, got", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	_, err := client.Dial(ctx, url, "test")
+	if err == nil {
+		t.Fatal("expect no new session after Shutdown")
+	}
+
+	conn.Close()
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect Shutdown done after the stream closed")
+	}
+
+	if len(s.Router().All()) != 0 {
+		t.Fatal("expect", 0, "got", len(s.Router().All()))
+	}
+	select {
+	case <-exposer.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expect exposer session closed")
+	}
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	s, url := serve(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _ := link(t, ctx, url)
+	defer conn.Close()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer shutdownCancel()
+
+	err := s.Shutdown(shutdownCtx)
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Fatal("expect", context.DeadlineExceeded, "got", err)
+	}
+
+	data := make([]byte, 1)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(data)
+	if err == nil {
+		t.Fatal("expect stream cut off")
+	}
+	if ne, ok := errors.Cause(err).(net.Error); ok && ne.Timeout() {
+		t.Fatal("expect stream closed, got", err)
+	}
+}
+
+func TestServer_metrics(t *testing.T) {
+	s, url := serve(t)
+	defer s.Shutdown(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.Dial(ctx, url, "bad")
+	if err == nil {
+		t.Fatal("expect auth failure")
+	}
+
+	conn, _ := link(t, ctx, url)
+	defer conn.Close()
+
+	metrics_url := "http" + strings.TrimPrefix(url, "ws") + "/metrics"
+	resp, err := http.Get(metrics_url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal("expect", http.StatusUnauthorized, "got", resp.StatusCode)
+	}
+
+	// the key itself is refused, it would travel in plaintext
+	req, _ := http.NewRequest("GET", metrics_url, nil)
+	req.Header.Set("Authorization", "Bearer test")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatal("expect", http.StatusUnauthorized, "got", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest("GET", metrics_url, nil)
+	req.Header.Set("Authorization", "Bearer "+auth.APIToken("test", req.Method, req.URL.Path, time.Now()))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+
+	for _, line := range []string{
+		"exposer_sessions 2",
+		`exposer_routes{type="expose"} 1`,
+		`exposer_routes{type="link"} 1`,
+		`exposer_service_streams{service="echo"} 1`,
+		`exposer_service_received_bytes_total{service="echo"} 5`,
+		`exposer_service_sent_bytes_total{service="echo"} 5`,
+		"exposer_auth_failures_total 1",
+	} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Fatal("expect", line, "in", string(data))
+		}
+	}
+}
+
+func TestServer_events(t *testing.T) {
+	s, url := serve(t)
+	s.Router().Prepare("old")
+
+	req, _ := http.NewRequest("GET", "http"+strings.TrimPrefix(url, "ws")+"/api/events", nil)
+	req.Header.Set("Authorization", "Bearer "+auth.APIToken("test", req.Method, req.URL.Path, time.Now()))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatal("expect", "text/event-stream", "got", resp.Header.Get("Content-Type"))
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	expect := func(typ service.EventType, name string) {
+		line, _ := reader.ReadString('\n')
+		if line != "event: "+string(typ)+"\n" {
+			t.Fatal("expect event", typ, "got", line)
+		}
+		line, _ = reader.ReadString('\n')
+		var event service.Event
+		err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event)
+		if err != nil {
+			t.Fatal(err, line)
+		}
+		if event.Type != typ || event.Name != name {
+			t.Fatal("expect", typ, name, "got", event.Type, event.Name)
+		}
+		reader.ReadString('\n')
+	}
+
+	expect(service.EventAdded, "old")
+	s.Router().Prepare("new")
+	expect(service.EventAdded, "new")
+	s.Router().Remove("old")
+	expect(service.EventRemoved, "old")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = s.Shutdown(ctx)
+	if err != nil {
+		t.Fatal("expect event streams end on Shutdown, got", err)
+	}
+}
+
+func TestServer_sessions(t *testing.T) {
+	s, url := serve(t)
+	defer s.Shutdown(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, exposer := link(t, ctx, url)
+	defer conn.Close()
+	defer exposer.Close()
+
+	api := func(method, path string, v interface{}) int {
+		req, _ := http.NewRequest(method, "http"+strings.TrimPrefix(url, "ws")+path, nil)
+		req.Header.Set("Authorization", "Bearer "+auth.APIToken("test", req.Method, req.URL.Path, time.Now()))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if v != nil {
+			err := json.NewDecoder(resp.Body).Decode(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var sessions []SessionInfo
+	api("GET", "/api/sessions", &sessions)
+	if len(sessions) != 2 {
+		t.Fatal("expect", 2, "got", sessions)
+	}
+	first := sessions[0]
+	if first.Identity != auth.DefaultIdentity || first.RemoteAddr == "" || first.Received == 0 || first.Sent == 0 {
+		t.Fatal("expect identity, remote address and bytes got", first)
+	}
+	if first.Health != keepalive.Healthy {
+		t.Fatal("expect", keepalive.Healthy, "got", first.Health)
+	}
+	routes := func(sess SessionInfo) string {
+		types := make([]string, 0, len(sess.Routes))
+		for _, typ := range sess.Routes {
+			types = append(types, string(typ))
+		}
+		return strings.Join(types, ",")
+	}
+	if routes(sessions[0]) != "expose,keepalive" || routes(sessions[1]) != "keepalive,link" {
+		t.Fatal("expect", "expose,keepalive keepalive,link", "got", routes(sessions[0]), routes(sessions[1]))
+	}
+
+	// tokens are good for one request only
+	req, _ := http.NewRequest("GET", "http"+strings.TrimPrefix(url, "ws")+"/api/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+auth.APIToken("test", req.Method, req.URL.Path, time.Now()))
+	for i, expect := range []int{200, 401} {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expect {
+			t.Fatal("expect", expect, "for use", i+1, "got", resp.StatusCode)
+		}
+	}
+	req, _ = http.NewRequest("DELETE", "http"+strings.TrimPrefix(url, "ws")+"/api/sessions/"+sessions[1].ID, nil)
+	req.Header.Set("Authorization", "Bearer "+auth.APIToken("test", "GET", "/api/sessions", time.Now()))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 401 {
+		t.Fatal("expect", 401, "got", resp.StatusCode)
+	}
+
+	if code := api("DELETE", "/api/sessions/none", nil); code != 404 {
+		t.Fatal("expect", 404, "got", code)
+	}
+	if code := api("DELETE", "/api/sessions/"+sessions[1].ID, nil); code != 204 {
+		t.Fatal("expect", 204, "got", code)
+	}
+	select {
+	case <-exposer.Done():
+		t.Fatal("expect other sessions kept")
+	case <-time.After(100 * time.Millisecond):
+	}
+	api("GET", "/api/sessions", &sessions)
+	if len(sessions) != 1 || sessions[0].ID != first.ID {
+		t.Fatal("expect", first.ID, "got", sessions)
+	}
+
+	if code := api("DELETE", "/api/services/none", nil); code != 404 {
+		t.Fatal("expect", 404, "got", code)
+	}
+	if code := api("DELETE", "/api/services/echo", nil); code != 204 {
+		t.Fatal("expect", 204, "got", code)
+	}
+	if s.Router().Get("echo") != nil {
+		t.Fatal("expect echo removed")
+	}
+}
+
+func TestServer_SetLimits(t *testing.T) {
+	s, url := serve(t)
+	defer s.Shutdown(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, exposer := link(t, ctx, url)
+	defer conn.Close()
+	defer exposer.Close()
+
+	// the exposed service is capped in place
+	s.SetLimits(service.Limits{MaxStreams: 1})
+	limits := s.Router().Get("echo").Info().Limits
+	if limits == nil || limits.MaxStreams != 1 {
+		t.Fatal("expect", 1, "got", limits)
+	}
+	_, err := s.Router().Get("echo").Open()
+	if errors.Cause(err) != service.ErrLimitExceeded {
+		t.Fatal("expect(error)", service.ErrLimitExceeded, "got", err)
+	}
+
+	s.SetLimits(service.Limits{})
+	if limits := s.Router().Get("echo").Info().Limits; limits != nil {
+		t.Fatal("expect unlimited got", limits)
+	}
+}
+
+func TestServer_Reload(t *testing.T) {
+	s, url := serve(t)
+	defer s.Shutdown(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rules := []acl.Rule{
+		{Identity: "alice", Key: "alice-key", Actions: []route.Type{acl.Any}, Services: []string{"alice-*"}},
+		{Identity: "bob", Key: "bob-key", Actions: []route.Type{acl.Any}, Services: []string{"bob-*"}},
+	}
+	_, err := s.Reload("test", rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dial := func(identity, key, name string) *client.Client {
+		c, err := client.DialWithCredential(ctx, url, auth.Credential{
+			Identity: identity,
+			Key:      key,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.Expose(ctx, name, service.Attribute{}, func() (net.Conn, error) {
+			return nil, errors.New("no service")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	alice := dial("alice", "alice-key", "alice-web")
+	defer alice.Close()
+	bob := dial("bob", "bob-key", "bob-web")
+	defer bob.Close()
+
+	// bob may no longer expose bob-web, alice is unchanged
+	rules[1].Services = []string{"bob-db"}
+	revoked, err := s.Reload("new", rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked != 1 {
+		t.Fatal("expect", 1, "got", revoked)
+	}
+
+	select {
+	case <-bob.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expect revoked session closed")
+	}
+	select {
+	case <-alice.Done():
+		t.Fatal("expect unchanged session kept")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	req, _ := http.NewRequest("GET", "http"+strings.TrimPrefix(url, "ws")+"/api/services", nil)
+	req.Header.Set("Authorization", "Bearer "+auth.APIToken("new", req.Method, req.URL.Path, time.Now()))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("expect new API key accepted, got", resp.StatusCode)
+	}
+
+	_, err = s.Reload("new", []acl.Rule{{Identity: "alice"}})
+	if err == nil {
+		t.Fatal("expect error for a rule without key")
+	}
+}
+
+func TestServer_forwardDynamic(t *testing.T) {
+	s, url := serve(t)
+	defer s.Shutdown(context.Background())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go echo(conn)
+		}
+	}()
+
+	_, err = s.Reload("test", []acl.Rule{
+		{Identity: "alice", Key: "alice-key", Actions: []route.Type{acl.Any}, Forwards: []string{ln.Addr().String()}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := client.DialWithCredential(ctx, url, auth.Credential{
+		Identity: "alice",
+		Key:      "alice-key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	socksln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.ForwardDynamicWithListener(ctx, socks5.NewListener(socksln))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connect := func(address string) (net.Conn, byte) {
+		conn, err := net.Dial("tcp", socksln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		host, port, _ := net.SplitHostPort(address)
+		p, _ := strconv.Atoi(port)
+		req := []byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x01}
+		req = append(req, net.ParseIP(host).To4()...)
+		req = append(req, byte(p>>8), byte(p))
+		conn.Write(req)
+
+		reply := make([]byte, 12)
+		_, err = io.ReadFull(conn, reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, reply[3]
+	}
+
+	conn, rep := connect(ln.Addr().String())
+	if rep != 0x00 {
+		t.Fatal("expect", 0x00, "got", rep)
+	}
+	conn.Close()
+
+	conn, rep = connect("127.0.0.1:1")
+	conn.Close()
+	if rep != 0x02 {
+		t.Fatal("expect not allowed", 0x02, "got", rep)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sess := range s.sessions {
+		sess.mu.Lock()
+		for g := range sess.grants {
+			if g.target != "" {
+				t.Fatal("expect no grant kept for dynamic streams got", g)
+			}
+		}
+		sess.mu.Unlock()
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/service-exposer/exposer/acl"
+	"github.com/service-exposer/exposer/protocal"
+	"github.com/service-exposer/exposer/protocal/keepalive"
+	"github.com/service-exposer/exposer/protocal/route"
+)
+
+type grant struct {
+	typ    route.Type
+	target string // empty for the route itself
+}
+
+// SessionInfo is the public description of a client session.
+type SessionInfo struct {
+	ID         string
+	RemoteAddr string
+	Identity   string
+	Routes     []route.Type // open routes, one entry per route
+	Start      time.Time
+
+	// Health, RTT and Jitter are of the keepalive pings, Health is empty
+	// before the first one.
+	Health keepalive.Health `json:",omitempty"`
+	RTT    time.Duration    `json:",omitempty"`
+	Jitter time.Duration    `json:",omitempty"`
+
+	Received int64 // bytes read from the client
+	Sent     int64 // bytes written to the client
+}
+
+// session is a client session and what the policy granted it, rechecked
+// when the policy is reloaded.
+type session struct {
+	received int64 // atomic, first for 64-bit alignment
+	sent     int64 // atomic
+
+	id    string
+	start time.Time
+	conn  net.Conn // counts received and sent
+
+	mu       *sync.Mutex
+	authed   bool
+	identity string
+	key      string
+	grants   map[grant]bool
+	routes   map[route.Type]int
+	health   keepalive.Status
+}
+
+func newSession(id string, conn net.Conn) *session {
+	sess := &session{
+		received: 0,
+		sent:     0,
+
+		id:    id,
+		start: time.Now(),
+		conn:  nil,
+
+		mu:       new(sync.Mutex),
+		authed:   false,
+		identity: "",
+		key:      "",
+		grants:   make(map[grant]bool),
+		routes:   make(map[route.Type]int),
+		health:   keepalive.Status{},
+	}
+	sess.conn = &countingConn{
+		Conn:     conn,
+		received: &sess.received,
+		sent:     &sess.sent,
+	}
+	return sess
+}
+
+func (sess *session) info() SessionInfo {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	info := SessionInfo{
+		ID:       sess.id,
+		Identity: sess.identity,
+		Routes:   []route.Type{},
+		Start:    sess.start,
+
+		Health: sess.health.Health,
+		RTT:    sess.health.RTT,
+		Jitter: sess.health.Jitter,
+
+		Received: atomic.LoadInt64(&sess.received),
+		Sent:     atomic.LoadInt64(&sess.sent),
+	}
+	if addr := sess.conn.RemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
+	}
+	for typ, n := range sess.routes {
+		for i := 0; i < n; i++ {
+			info.Routes = append(info.Routes, typ)
+		}
+	}
+	sort.Slice(info.Routes, func(i, j int) bool {
+		return info.Routes[i] < info.Routes[j]
+	})
+	return info
+}
+
+func (sess *session) setHealth(status keepalive.Status) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	sess.health = status
+}
+
+// routeOpened counts the route typ of sess until proto ends.
+func (sess *session) routeOpened(proto *protocal.Protocal, typ route.Type) {
+	sess.mu.Lock()
+	sess.routes[typ]++
+	sess.mu.Unlock()
+
+	go func() {
+		proto.Wait()
+
+		sess.mu.Lock()
+		defer sess.mu.Unlock()
+
+		sess.routes[typ]--
+		if sess.routes[typ] <= 0 {
+			delete(sess.routes, typ)
+		}
+	}()
+}
+
+func (sess *session) authenticated(identity, key string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	sess.authed, sess.identity, sess.key = true, identity, key
+}
+
+func (sess *session) granted(g grant) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	sess.grants[g] = true
+}
+
+// allowed reports whether policy still grants everything sess got.
+func (sess *session) allowed(policy *acl.Policy) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if !sess.authed {
+		return true
+	}
+
+	key, ok := policy.Key(sess.identity)
+	if !ok || key != sess.key {
+		return false
+	}
+	for g := range sess.grants {
+		if g.target == "" && !policy.AllowRoute(sess.identity, g.typ) {
+			return false
+		}
+		if g.target != "" && !policy.AllowTarget(sess.identity, g.typ, g.target) {
+			return false
+		}
+	}
+	return true
+}
+
+// authorizer records the routes and targets policy allows to sess.
+type authorizer struct {
+	policy *acl.Policy
+	sess   *session
+}
+
+func (a *authorizer) AllowRoute(identity string, typ route.Type) bool {
+	ok := a.policy.AllowRoute(identity, typ)
+	if ok {
+		a.sess.granted(grant{typ: typ})
+	}
+	return ok
+}
+
+func (a *authorizer) AllowTarget(identity string, typ route.Type, target string) bool {
+	ok := a.policy.AllowTarget(identity, typ, target)
+	if ok {
+		a.sess.granted(grant{typ: typ, target: target})
+	}
+	return ok
+}
@@ -10,18 +10,41 @@ type Attribute struct {
 	HTTP struct {
 		Is   bool   `json:",omitempty"`
 		Host string `json:",omitempty"`
+
+		// Hostnames are extra request hosts the daemon routes to the service.
+		Hostnames []string `json:",omitempty"`
 	} `json:",omitempty"`
+
+	RemotePort int `json:",omitempty"` // daemon TCP port of the service
+}
+
+func (attr *Attribute) equal(other *Attribute) bool {
+	if attr.HTTP.Is != other.HTTP.Is || attr.HTTP.Host != other.HTTP.Host ||
+		attr.RemotePort != other.RemotePort ||
+		len(attr.HTTP.Hostnames) != len(other.HTTP.Hostnames) {
+		return false
+	}
+	for i := range attr.HTTP.Hostnames {
+		if attr.HTTP.Hostnames[i] != other.HTTP.Hostnames[i] {
+			return false
+		}
+	}
+	return true
 }
 
 type SafedAttribute struct {
 	mu   *sync.RWMutex
 	attr Attribute
+
+	onUpdate func() // called after every Update, without the lock
 }
 
 func NewSafedAttribute(attr *Attribute) *SafedAttribute {
 	return &SafedAttribute{
 		mu:   new(sync.RWMutex),
 		attr: *attr,
+
+		onUpdate: nil,
 	}
 }
 
@@ -33,6 +56,10 @@ func (safed *SafedAttribute) View(fn func(attr Attribute) error) error {
 }
 
 func (safed *SafedAttribute) Update(fn func(attr *Attribute) error) error {
+	if safed.onUpdate != nil {
+		defer safed.onUpdate()
+	}
+
 	safed.mu.Lock()
 	defer safed.mu.Unlock()
 
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"math/rand"
+	"net"
+	"sync/atomic"
+
+	"github.com/juju/errors"
+)
+
+// Balance is the way a service group picks a member for each Open.
+type Balance string
+
+const (
+	RoundRobin Balance = "round-robin"
+	LeastConn  Balance = "least-conn"
+	Random     Balance = "random"
+)
+
+var (
+	ErrNotSupportedBalance = errors.New("not supported balance")
+)
+
+func (balance Balance) valid() bool {
+	switch balance {
+	case RoundRobin, LeastConn, Random:
+		return true
+	}
+	return false
+}
+
+// Member is a snapshot of one member of a service group.
+type Member struct {
+	ID    int
+	Addr  string `json:",omitempty"`
+	Conns int64
+}
+
+type member struct {
+	counters counters // first for 64-bit alignment
+
+	id      int
+	addr    string
+	openFn  func() (net.Conn, error)
+	closeFn func() error
+}
+
+func (m *member) open() (net.Conn, error) {
+	conn, err := m.openFn()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return newCountedConn(conn, &m.counters), nil
+}
+
+type group struct {
+	balance Balance
+	cursor  uint32 // atomic
+	lastID  int
+	members []*member
+}
+
+func newGroup(balance Balance) *group {
+	return &group{
+		balance: balance,
+		cursor:  0,
+		lastID:  0,
+		members: nil,
+	}
+}
+
+func (g *group) join(addr string) int {
+	g.lastID++
+	g.members = append(g.members, &member{
+		id:   g.lastID,
+		addr: addr,
+	})
+	return g.lastID
+}
+
+func (g *group) get(id int) *member {
+	for _, m := range g.members {
+		if m.id == id {
+			return m
+		}
+	}
+	return nil
+}
+
+func (g *group) leave(id int) *member {
+	for i, m := range g.members {
+		if m.id == id {
+			g.members = append(g.members[:i:i], g.members[i+1:]...)
+			return m
+		}
+	}
+	return nil
+}
+
+// pick returns a ready member by g.balance, nil if there is none.
+func (g *group) pick() *member {
+	ready := make([]*member, 0, len(g.members))
+	for _, m := range g.members {
+		if m.openFn != nil {
+			ready = append(ready, m)
+		}
+	}
+	if len(ready) == 0 {
+		return nil
+	}
+
+	switch g.balance {
+	case LeastConn:
+		least := ready[0]
+		for _, m := range ready[1:] {
+			if atomic.LoadInt64(&m.counters.active) < atomic.LoadInt64(&least.counters.active) {
+				least = m
+			}
+		}
+		return least
+	case Random:
+		return ready[rand.Intn(len(ready))]
+	default:
+		n := atomic.AddUint32(&g.cursor, 1)
+		return ready[int(n-1)%len(ready)]
+	}
+}
+
+func (g *group) snapshot() []Member {
+	members := make([]Member, 0, len(g.members))
+	for _, m := range g.members {
+		members = append(members, Member{
+			ID:    m.id,
+			Addr:  m.addr,
+			Conns: atomic.LoadInt64(&m.counters.active),
+		})
+	}
+	return members
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"net"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+func TestRouter_Join(t *testing.T) {
+	r := NewRouter()
+
+	_, err := r.Join("test", "unknown", "")
+	if errors.Cause(err) != ErrNotSupportedBalance {
+		t.Fatal(errors.Cause(err), "want", ErrNotSupportedBalance)
+	}
+
+	err = r.Prepare("single")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Join("single", RoundRobin, "")
+	if errors.Cause(err) != ErrServiceExist {
+		t.Fatal(errors.Cause(err), "want", ErrServiceExist)
+	}
+
+	id1, err := r.Join("test", RoundRobin, "addr1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := r.Join("test", RoundRobin, "addr2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Prepare("test")
+	if errors.Cause(err) != ErrServiceExist {
+		t.Fatal(errors.Cause(err), "want", ErrServiceExist)
+	}
+
+	_, err = r.Get("test").Open()
+	if err == nil {
+		t.Fatal("expect not ready err")
+	}
+
+	opened := make(map[int]int)
+	closed := make(map[int]bool)
+	add := func(id int) {
+		ok := r.AddMember("test", id, func() (net.Conn, error) {
+			opened[id]++
+			c, _ := net.Pipe()
+			return c, nil
+		}, func() error {
+			closed[id] = true
+			return nil
+		})
+		if !ok {
+			t.Fatal("expect ok got !ok")
+		}
+	}
+	add(id1)
+	add(id2)
+
+	if r.AddMember("test", 100, func() (net.Conn, error) {
+		return nil, nil
+	}, func() error {
+		return nil
+	}) {
+		t.Fatal("expect !ok got ok")
+	}
+
+	for i := 0; i < 4; i++ {
+		conn, err := r.Get("test").Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	if opened[id1] != 2 || opened[id2] != 2 {
+		t.Fatal(opened, "want round-robin")
+	}
+
+	info := r.Get("test").Info()
+	if info.Balance != RoundRobin || len(info.Members) != 2 || info.Members[1].Addr != "addr2" {
+		t.Fatal(info)
+	}
+
+	r.Leave("test", id1)
+	if !closed[id1] {
+		t.Fatal("expect member closed")
+	}
+	if r.Get("test") == nil {
+		t.Fatal("expect group exist")
+	}
+
+	r.Leave("test", id2)
+	if r.Get("test") != nil {
+		t.Fatal("expect group removed with last member")
+	}
+}
+
+func TestRouter_JoinWithAttribute(t *testing.T) {
+	r := NewRouter()
+
+	var web Attribute
+	web.HTTP.Is = true
+	web.HTTP.Hostnames = []string{"www.example.test"}
+
+	_, err := r.JoinWithAttribute("web", RoundRobin, "", web)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Get("web").Attribute().View(func(attr Attribute) error {
+		if !attr.HTTP.Is || len(attr.HTTP.Hostnames) != 1 {
+			t.Fatal("expect attribute of the first member got", attr)
+		}
+		return nil
+	})
+
+	same := web
+	same.HTTP.Hostnames = []string{"www.example.test"}
+	_, err = r.JoinWithAttribute("web", RoundRobin, "", same)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := web
+	other.HTTP.Hostnames = []string{"evil.example.test"}
+	_, err = r.JoinWithAttribute("web", RoundRobin, "", other)
+	if errors.Cause(err) != ErrAttributeConflict {
+		t.Fatal("expect", ErrAttributeConflict, "got", err)
+	}
+	_, err = r.Join("web", RoundRobin, "")
+	if errors.Cause(err) != ErrAttributeConflict {
+		t.Fatal("expect", ErrAttributeConflict, "got", err)
+	}
+
+	if n := len(r.Get("web").Info().Members); n != 2 {
+		t.Fatal("expect", 2, "got", n)
+	}
+}
+
+func TestGroup_pickLeastConn(t *testing.T) {
+	r := NewRouter()
+
+	var ids []int
+	for i := 0; i < 3; i++ {
+		id, err := r.Join("test", LeastConn, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+
+		r.AddMember("test", id, func() (net.Conn, error) {
+			c, _ := net.Pipe()
+			return c, nil
+		}, func() error {
+			return nil
+		})
+	}
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := r.Get("test").Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+
+	for _, m := range r.Get("test").Info().Members {
+		if m.Conns != 1 {
+			t.Fatal(m, "want 1 conn each")
+		}
+	}
+
+	conns[1].Close()
+	conns[1].Close()
+
+	conn, err := r.Get("test").Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	members := r.Get("test").Info().Members
+	if members[1].Conns != 1 {
+		t.Fatal(members, "want the least connected member picked")
+	}
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/listener"
+)
+
+var (
+	ErrLimitExceeded = errors.New("service limit exceeded")
+)
+
+// Limits bound the streams of a service, 0 is unlimited. Read is from
+// the service, Write to it, both shared by all streams.
+type Limits struct {
+	MaxStreams          int   `json:",omitempty"`
+	StreamsPerSecond    int   `json:",omitempty"`
+	ReadBytesPerSecond  int64 `json:",omitempty"`
+	WriteBytesPerSecond int64 `json:",omitempty"`
+}
+
+// Unlimited reports whether l bounds nothing.
+func (l Limits) Unlimited() bool {
+	return l == Limits{}
+}
+
+// Cap returns l with every limit at most the one of max.
+func (l Limits) Cap(max Limits) Limits {
+	if max.MaxStreams > 0 && (l.MaxStreams <= 0 || l.MaxStreams > max.MaxStreams) {
+		l.MaxStreams = max.MaxStreams
+	}
+	if max.StreamsPerSecond > 0 && (l.StreamsPerSecond <= 0 || l.StreamsPerSecond > max.StreamsPerSecond) {
+		l.StreamsPerSecond = max.StreamsPerSecond
+	}
+	if max.ReadBytesPerSecond > 0 && (l.ReadBytesPerSecond <= 0 || l.ReadBytesPerSecond > max.ReadBytesPerSecond) {
+		l.ReadBytesPerSecond = max.ReadBytesPerSecond
+	}
+	if max.WriteBytesPerSecond > 0 && (l.WriteBytesPerSecond <= 0 || l.WriteBytesPerSecond > max.WriteBytesPerSecond) {
+		l.WriteBytesPerSecond = max.WriteBytesPerSecond
+	}
+	return l
+}
+
+// bucket is a token bucket of rate tokens per second, which holds at
+// most a second of them.
+type bucket struct {
+	mu     *sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate int64) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &bucket{
+		mu:     new(sync.Mutex),
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// refill adds the tokens since the last call, b.mu must be held.
+func (b *bucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+// take removes n tokens and returns how long to wait until they were
+// there. The bucket goes into debt, so waiting takers queue up.
+func (b *bucket) take(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// allow takes a token if there is one.
+func (b *bucket) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// limiter enforces Limits on the streams of a service. Its limits may
+// change while streams are open, they are counted all along.
+type limiter struct {
+	mu      *sync.Mutex
+	asked   Limits // by the owner of the service
+	max     Limits // by the router
+	limits  Limits // asked capped by max
+	streams int
+
+	opens  *bucket
+	reads  *bucket
+	writes *bucket
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		mu:      new(sync.Mutex),
+		asked:   Limits{},
+		max:     Limits{},
+		limits:  Limits{},
+		streams: 0,
+
+		opens:  nil,
+		reads:  nil,
+		writes: nil,
+	}
+}
+
+// set changes the limits of open and new streams to asked capped by max.
+func (l *limiter) set(asked, max Limits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.update(asked, max)
+}
+
+// capTo caps the asked limits by max instead.
+func (l *limiter) capTo(max Limits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.update(l.asked, max)
+}
+
+// update is set with l.mu held, the buckets of rates that did not change
+// keep their tokens.
+func (l *limiter) update(asked, max Limits) {
+	limits := asked.Cap(max)
+	if limits.StreamsPerSecond != l.limits.StreamsPerSecond {
+		l.opens = newBucket(int64(limits.StreamsPerSecond))
+	}
+	if limits.ReadBytesPerSecond != l.limits.ReadBytesPerSecond {
+		l.reads = newBucket(limits.ReadBytesPerSecond)
+	}
+	if limits.WriteBytesPerSecond != l.limits.WriteBytesPerSecond {
+		l.writes = newBucket(limits.WriteBytesPerSecond)
+	}
+	l.asked, l.max, l.limits = asked, max, limits
+}
+
+func (l *limiter) get() Limits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.limits
+}
+
+// acquire counts a new stream, release must be called once it is closed.
+func (l *limiter) acquire() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limits.MaxStreams > 0 && l.streams >= l.limits.MaxStreams {
+		return errors.Annotatef(ErrLimitExceeded, "%d concurrent streams", l.limits.MaxStreams)
+	}
+	if l.opens != nil && !l.opens.allow() {
+		return errors.Annotatef(ErrLimitExceeded, "%d new streams per second", l.limits.StreamsPerSecond)
+	}
+	l.streams++
+	return nil
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.streams--
+}
+
+// bandwidth returns the bucket of reads or writes, nil if unlimited, and
+// the most bytes to move at once.
+func (l *limiter) bandwidth(write bool) (*bucket, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if write {
+		return l.writes, int(l.limits.WriteBytesPerSecond)
+	}
+	return l.reads, int(l.limits.ReadBytesPerSecond)
+}
+
+// limitedConn is a stream of a service with a limiter.
+type limitedConn struct {
+	net.Conn
+	once    *sync.Once
+	limiter *limiter
+}
+
+func (l *limiter) wrap(conn net.Conn) net.Conn {
+	return &limitedConn{
+		Conn:    conn,
+		once:    new(sync.Once),
+		limiter: l,
+	}
+}
+
+func (conn *limitedConn) Read(b []byte) (int, error) {
+	reads, max := conn.limiter.bandwidth(false)
+	if reads != nil && len(b) > max {
+		b = b[:max]
+	}
+	n, err := conn.Conn.Read(b)
+	if reads != nil && n > 0 {
+		time.Sleep(reads.take(n))
+	}
+	return n, err
+}
+
+func (conn *limitedConn) Write(b []byte) (int, error) {
+	writes, max := conn.limiter.bandwidth(true)
+	if writes == nil {
+		return conn.Conn.Write(b)
+	}
+
+	var written int
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > max {
+			chunk = chunk[:max]
+		}
+		time.Sleep(writes.take(len(chunk)))
+
+		n, err := conn.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (conn *limitedConn) CloseWrite() error {
+	return listener.CloseWrite(conn.Conn)
+}
+
+func (conn *limitedConn) Close() error {
+	conn.once.Do(conn.limiter.release)
+	return conn.Conn.Close()
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+func TestLimits_Cap(t *testing.T) {
+	limits := Limits{MaxStreams: 10, ReadBytesPerSecond: 100}.Cap(Limits{MaxStreams: 5, StreamsPerSecond: 2, ReadBytesPerSecond: 1000})
+	expect := Limits{MaxStreams: 5, StreamsPerSecond: 2, ReadBytesPerSecond: 100}
+	if limits != expect {
+		t.Fatal("expect", expect, "got", limits)
+	}
+	if limits := (Limits{MaxStreams: 3}).Cap(Limits{}); limits != (Limits{MaxStreams: 3}) {
+		t.Fatal("expect", Limits{MaxStreams: 3}, "got", limits)
+	}
+}
+
+func TestService_limits(t *testing.T) {
+	service := newService("test")
+	service.setOpenFunc(func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go io.Copy(c1, c1)
+		return c2, nil
+	})
+
+	service.SetLimits(Limits{MaxStreams: 2})
+	c1, err := service.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := service.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = service.Open()
+	if errors.Cause(err) != ErrLimitExceeded {
+		t.Fatal("expect(error)", ErrLimitExceeded, "got", err)
+	}
+	c1.Close()
+	c1.Close()
+	c3, err := service.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.Close()
+	c3.Close()
+
+	service.SetLimits(Limits{StreamsPerSecond: 2})
+	for i := 0; i < 2; i++ {
+		conn, err := service.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	_, err = service.Open()
+	if errors.Cause(err) != ErrLimitExceeded {
+		t.Fatal("expect(error)", ErrLimitExceeded, "got", err)
+	}
+	if info := service.Info(); info.Limits == nil || info.Limits.StreamsPerSecond != 2 {
+		t.Fatal("expect", 2, "got", info.Limits)
+	}
+}
+
+func TestService_SetLimits(t *testing.T) {
+	service := newService("test")
+	service.setOpenFunc(func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go io.Copy(c1, c1)
+		return c2, nil
+	})
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		conn, err := service.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	service.SetLimits(Limits{MaxStreams: 3})
+	_, err := service.Open()
+	if errors.Cause(err) != ErrLimitExceeded {
+		t.Fatal("expect(error)", ErrLimitExceeded, "got", err)
+	}
+
+	service.SetLimits(Limits{MaxStreams: 3, StreamsPerSecond: 10})
+	_, err = service.Open()
+	if errors.Cause(err) != ErrLimitExceeded {
+		t.Fatal("expect(error)", ErrLimitExceeded, "got", err)
+	}
+
+	conns[0].Close()
+	conn, err := service.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestRouter_CapLimits(t *testing.T) {
+	router := NewRouter()
+	router.CapLimits(Limits{MaxStreams: 2})
+	for _, name := range []string{"a", "b"} {
+		if err := router.Prepare(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router.Get("a").SetLimits(Limits{MaxStreams: 5})
+
+	limits := func(name string) Limits {
+		if l := router.Get(name).Info().Limits; l != nil {
+			return *l
+		}
+		return Limits{}
+	}
+	for _, c := range []struct {
+		max  Limits
+		a, b Limits
+	}{
+		{Limits{MaxStreams: 2}, Limits{MaxStreams: 2}, Limits{MaxStreams: 2}},
+		{Limits{MaxStreams: 10}, Limits{MaxStreams: 5}, Limits{MaxStreams: 10}},
+		{Limits{}, Limits{MaxStreams: 5}, Limits{}},
+	} {
+		router.CapLimits(c.max)
+		if a, b := limits("a"), limits("b"); a != c.a || b != c.b {
+			t.Fatal("expect", c.a, c.b, "got", a, b)
+		}
+	}
+}
+
+func TestService_bandwidth(t *testing.T) {
+	service := newService("test")
+	service.setOpenFunc(func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go io.Copy(c1, c1)
+		return c2, nil
+	})
+	service.SetLimits(Limits{WriteBytesPerSecond: 1000})
+
+	conn, err := service.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go io.Copy(io.Discard, conn)
+
+	start := time.Now()
+	conn.Write(make([]byte, 1500)) // a second of tokens at start
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatal("expect about", 500*time.Millisecond, "got", elapsed)
+	}
+}
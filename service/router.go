@@ -1,27 +1,84 @@
 package service
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"net"
+	"path"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/juju/errors"
 )
 
 var (
-	ErrServiceExist = errors.New("service name exist")
+	ErrServiceExist  = errors.New("service name exist")
+	ErrRouterClosed  = errors.New("router closed")
+	ErrHostnameExist = errors.New("hostname claimed by another service")
+
+	ErrAttributeConflict = errors.New("attribute differs from the service group's")
+	ErrServiceReserved   = errors.New("service name reserved for its owner")
 )
 
+// Observer is told about every Service.Open on the services of a router.
+type Observer interface {
+	// Opened returns the conn handed to the caller in place of conn.
+	Opened(name string, conn net.Conn) net.Conn
+	OpenFailed(name string, err error)
+}
+
 type Router struct {
-	mu     *sync.Mutex
-	routes map[string]*Service
+	mu       *sync.Mutex
+	closed   bool
+	routes   map[string]*Service
+	hosts    map[string]string // claimed hostname -> service name
+	observer Observer
+	watchers *watchers
+	limits   Limits // cap of the limits of every service
 }
 
 func NewRouter() *Router {
 	return &Router{
-		mu:     new(sync.Mutex),
-		routes: make(map[string]*Service),
+		mu:       new(sync.Mutex),
+		closed:   false,
+		routes:   make(map[string]*Service),
+		hosts:    make(map[string]string),
+		observer: nil,
+		watchers: newWatchers(),
+		limits:   Limits{},
+	}
+}
+
+// adopt makes service report to r.
+func (r *Router) adopt(service *Service) *Service {
+	service.router = r
+	service.limiter.capTo(r.limits)
+	service.attr.onUpdate = func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.routes[service.name] == service {
+			r.emit(EventUpdated, service)
+		}
 	}
+	return service
+}
+
+func (r *Router) SetObserver(observer Observer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.observer = observer
+}
+
+func (r *Router) getObserver() Observer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.observer
 }
 
 func (r *Router) Prepare(name string) error {
@@ -29,16 +86,153 @@ func (r *Router) Prepare(name string) error {
 		return errors.Annotatef(ErrServiceExist, "Prepare %q", name)
 	}
 
+	var service *Service
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	defer func() {
+		if service != nil {
+			r.emit(EventAdded, service)
+		}
+	}()
+
+	if r.closed {
+		return errors.Annotatef(ErrRouterClosed, "Prepare %q", name)
+	}
 	if _, exist := r.routes[name]; exist {
 		return errors.Annotatef(ErrServiceExist, "Prepare %q", name)
 	}
 
-	r.routes[name] = newService(name)
+	service = r.adopt(newService(name))
+	r.routes[name] = service
 	return nil
 }
+
+// Claim is Prepare for an owner: it returns a token that reclaims name
+// while it is reserved by Release. A token of the reserved name reclaims
+// it, other tokens fail with ErrServiceReserved.
+func (r *Router) Claim(name, token string) (string, error) {
+	if name == "" {
+		return "", errors.Annotatef(ErrServiceExist, "Claim %q", name)
+	}
+
+	var service *Service
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	defer func() {
+		if service != nil {
+			r.emit(EventAdded, service)
+		}
+	}()
+
+	if r.closed {
+		return "", errors.Annotatef(ErrRouterClosed, "Claim %q", name)
+	}
+	if exist, ok := r.routes[name]; ok {
+		exist.mu.Lock()
+		defer exist.mu.Unlock()
+
+		if exist.reserved == nil || exist.reserved.timer == nil {
+			return "", errors.Annotatef(ErrServiceExist, "Claim %q", name)
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(exist.token)) != 1 {
+			return "", errors.Annotatef(ErrServiceReserved, "Claim %q", name)
+		}
+
+		// Open keeps waiting until the owner is back with Add, the owner
+		// claims its hostnames again
+		exist.reserved.timer.Stop()
+		exist.reserved.timer = nil
+		r.releaseHostnames(name)
+		return exist.token, nil
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	service = r.adopt(newService(name))
+	service.token = token
+	r.routes[name] = service
+	return token, nil
+}
+
+// Release removes name once its owner, the holder of token, is gone.
+// With a grace period the name stays reserved to token for grace, Open
+// waits for the owner meanwhile. Release leaves alone a name claimed by
+// someone else since.
+func (r *Router) Release(name, token string, grace time.Duration) {
+	var (
+		service *Service
+		event   EventType
+	)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	defer func() {
+		if service != nil {
+			r.emit(event, service)
+		}
+	}()
+
+	s := r.routes[name]
+	if s == nil || s.group != nil {
+		return
+	}
+
+	s.mu.Lock()
+	owned := token != "" && s.token == token
+	s.mu.Unlock()
+	if !owned {
+		return
+	}
+
+	if grace <= 0 {
+		service, event = s, EventRemoved
+		r.remove(name, s)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reserved != nil && s.reserved.timer != nil {
+		return
+	}
+
+	res := &reservation{}
+	res.timer = time.AfterFunc(grace, func() {
+		r.expire(name, s, res)
+	})
+	s.reserved = res
+	s.openFn, s.closeFn = nil, nil
+	s.ready = make(chan struct{})
+	service, event = s, EventUpdated
+}
+
+// expire removes service once its reservation res ran out.
+func (r *Router) expire(name string, service *Service, res *reservation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.routes[name] != service {
+		return
+	}
+	service.mu.Lock()
+	current := service.reserved == res && res.timer != nil
+	service.mu.Unlock()
+	if !current {
+		return
+	}
+
+	defer r.emit(EventRemoved, service)
+	r.remove(name, service)
+}
+
 func (r *Router) Add(name string, openFn func() (net.Conn, error),
 	closeFn func() error) bool {
 	if openFn == nil {
@@ -48,10 +242,18 @@ func (r *Router) Add(name string, openFn func() (net.Conn, error),
 		panic("paramater closeFn func() error is nil")
 	}
 
+	var service *Service
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	service := r.routes[name]
+	defer func() {
+		if service != nil {
+			r.emit(EventUpdated, service)
+		}
+	}()
+
+	service = r.routes[name]
 	if service == nil {
 		return false
 	}
@@ -61,6 +263,195 @@ func (r *Router) Add(name string, openFn func() (net.Conn, error),
 	return true
 }
 
+// Join reserves a member slot in the service group name, creating the
+// group on first join. Like Prepare, the slot is usable after AddMember.
+func (r *Router) Join(name string, balance Balance, addr string) (id int, err error) {
+	return r.JoinWithAttribute(name, balance, addr, Attribute{})
+}
+
+// JoinWithAttribute is Join for a member serving attr. The member creating
+// the group sets its attribute, later members must serve the same one or
+// fail with ErrAttributeConflict.
+func (r *Router) JoinWithAttribute(name string, balance Balance, addr string, attr Attribute) (id int, err error) {
+	if name == "" {
+		return 0, errors.Annotatef(ErrServiceExist, "Join %q", name)
+	}
+	if !balance.valid() {
+		return 0, errors.Annotatef(ErrNotSupportedBalance, "Join %q %q", name, balance)
+	}
+
+	var (
+		service *Service
+		event   EventType
+	)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	defer func() {
+		if event != "" {
+			r.emit(event, service)
+		}
+	}()
+
+	if r.closed {
+		return 0, errors.Annotatef(ErrRouterClosed, "Join %q", name)
+	}
+	service, exist := r.routes[name]
+	event = EventUpdated
+	if !exist {
+		service = newGroupService(name, balance)
+		service.attr = NewSafedAttribute(&attr)
+		r.adopt(service)
+		r.routes[name] = service
+		event = EventAdded
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if service.group == nil {
+		event = ""
+		return 0, errors.Annotatef(ErrServiceExist, "Join %q", name)
+	}
+	if exist {
+		var same bool
+		service.attr.View(func(group Attribute) error {
+			same = group.equal(&attr)
+			return nil
+		})
+		if !same {
+			event = ""
+			return 0, errors.Annotatef(ErrAttributeConflict, "Join %q", name)
+		}
+	}
+	return service.group.join(addr), nil
+}
+
+func (r *Router) AddMember(name string, id int, openFn func() (net.Conn, error),
+	closeFn func() error) bool {
+	if openFn == nil {
+		panic("paramater openFn func() (net.Conn,error) is nil")
+	}
+	if closeFn == nil {
+		panic("paramater closeFn func() error is nil")
+	}
+
+	var ok bool
+	var service *Service
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	defer func() {
+		if ok {
+			r.emit(EventUpdated, service)
+		}
+	}()
+
+	service = r.routes[name]
+	if service == nil {
+		return false
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if service.group == nil {
+		return false
+	}
+	m := service.group.get(id)
+	if m == nil {
+		return false
+	}
+
+	m.openFn = openFn
+	m.closeFn = closeFn
+	ok = true
+	return ok
+}
+
+// Leave removes member id from the service group name and the group
+// itself after its last member.
+func (r *Router) Leave(name string, id int) {
+	var (
+		service *Service
+		event   EventType
+	)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	defer func() {
+		if event != "" {
+			r.emit(event, service)
+		}
+	}()
+
+	service = r.routes[name]
+	if service == nil {
+		return
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if service.group == nil {
+		return
+	}
+
+	m := service.group.leave(id)
+	if m != nil {
+		event = EventUpdated
+		if m.closeFn != nil {
+			m.closeFn()
+		}
+	}
+
+	if len(service.group.members) == 0 {
+		delete(r.routes, name)
+		r.releaseHostnames(name)
+		event = EventRemoved
+	}
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// ClaimHostnames reserves hostnames to the service name until it is
+// removed. Members of a service group share its claims. A hostname
+// claimed by another service fails with ErrHostnameExist and nothing
+// is claimed.
+func (r *Router) ClaimHostnames(name string, hostnames []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exist := r.routes[name]; !exist {
+		return errors.Errorf("ClaimHostnames %q: no such service", name)
+	}
+
+	for _, hostname := range hostnames {
+		owner, claimed := r.hosts[normalizeHost(hostname)]
+		if claimed && owner != name {
+			return errors.Annotatef(ErrHostnameExist, "%q", hostname)
+		}
+	}
+	for _, hostname := range hostnames {
+		r.hosts[normalizeHost(hostname)] = name
+	}
+	return nil
+}
+
+// releaseHostnames drops the claims of name, r.mu must be held.
+func (r *Router) releaseHostnames(name string) {
+	for host, owner := range r.hosts {
+		if owner == name {
+			delete(r.hosts, host)
+		}
+	}
+}
+
 func (r *Router) Get(name string) *Service {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -69,19 +460,72 @@ func (r *Router) Get(name string) *Service {
 }
 
 func (r *Router) Remove(name string) {
+	var service *Service
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	defer func() {
+		if service != nil {
+			r.emit(EventRemoved, service)
+		}
+	}()
+
 	service, exist := r.routes[name]
 	if !exist {
 		return
 	}
 
+	r.remove(name, service)
+}
+
+// remove drops service, r.mu must be held.
+func (r *Router) remove(name string, service *Service) {
 	delete(r.routes, name)
+	r.releaseHostnames(name)
+
+	service.unreserve()
+	service.Close()
+}
+
+// Active returns the number of open connections to all services.
+// CapLimits caps the limits of every service by max, now and for the
+// services added later. Each keeps the limits it asked for below max.
+func (r *Router) CapLimits(max Limits) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.limits = max
+	for _, service := range r.routes {
+		service.limiter.capTo(max)
+	}
+}
+
+func (r *Router) Active() int64 {
+	var n int64
+	for _, s := range r.All() {
+		n += s.Active()
+	}
+	return n
+}
+
+// Close removes and closes every service. Later Prepare and Join fail
+// with ErrRouterClosed.
+func (r *Router) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	routes := r.routes
+	r.closed = true
+	r.routes = make(map[string]*Service)
+	r.hosts = make(map[string]string)
 
-	if service != nil && service.closeFn != nil {
+	for _, service := range routes {
+		service.unreserve()
 		service.Close()
+		r.emit(EventRemoved, service)
 	}
+	return nil
 }
 
 func (r *Router) All() []*Service {
@@ -103,3 +547,51 @@ func (r *Router) All() []*Service {
 
 	return services
 }
+
+// MatchHost returns the HTTP service a request for host should be routed
+// to: the service name when host is name.domain, otherwise the service
+// that claimed host with ClaimHostnames. Claims are only honoured when
+// host matches one of the path.Match patterns in allowed, so clients
+// cannot claim the daemon's own host. domain may be empty.
+func (r *Router) MatchHost(host, domain string, allowed []string) *Service {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = normalizeHost(host)
+	domain = normalizeHost(domain)
+
+	if domain != "" && strings.HasSuffix(host, "."+domain) {
+		name := host[:len(host)-len(domain)-1]
+		if s := r.Get(name); s != nil && s.isHTTP() {
+			return s
+		}
+	}
+
+	var ok bool
+	for _, pattern := range allowed {
+		if match, _ := path.Match(strings.ToLower(pattern), host); match {
+			ok = true
+		}
+	}
+	if !ok {
+		return nil
+	}
+
+	r.mu.Lock()
+	s := r.routes[r.hosts[host]]
+	r.mu.Unlock()
+
+	if s != nil && s.isHTTP() {
+		return s
+	}
+	return nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return hex.EncodeToString(b), nil
+}
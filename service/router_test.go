@@ -3,6 +3,7 @@ package service
 import (
 	"net"
 	"testing"
+	"time"
 
 	"github.com/juju/errors"
 )
@@ -156,6 +157,100 @@ func TestRouter_Remove(t *testing.T) {
 	r.Remove("")
 }
 
+func TestRouter_Close(t *testing.T) {
+	r := NewRouter()
+	err := r.Prepare("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closeCalled := false
+	r.Add("test", func() (net.Conn, error) {
+		c1, _ := net.Pipe()
+		return c1, nil
+	}, func() error {
+		closeCalled = true
+		return nil
+	})
+
+	conn, err := r.Get("test").Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Active() != 1 {
+		t.Fatal("expect", 1, "got", r.Active())
+	}
+	conn.Close()
+	conn.Close()
+	if r.Active() != 0 {
+		t.Fatal("expect", 0, "got", r.Active())
+	}
+
+	r.Close()
+	if !closeCalled {
+		t.Fatal("expect closeCalled got !closeCalled")
+	}
+	if r.Get("test") != nil {
+		t.Fatal("expect service removed")
+	}
+
+	err = r.Prepare("test")
+	if errors.Cause(err) != ErrRouterClosed {
+		t.Fatal("expect", ErrRouterClosed, "got", err)
+	}
+	_, err = r.Join("group", RoundRobin, "")
+	if errors.Cause(err) != ErrRouterClosed {
+		t.Fatal("expect", ErrRouterClosed, "got", err)
+	}
+}
+
+type testObserver struct {
+	opened []string
+	failed []string
+}
+
+func (o *testObserver) Opened(name string, conn net.Conn) net.Conn {
+	o.opened = append(o.opened, name)
+	return conn
+}
+
+func (o *testObserver) OpenFailed(name string, err error) {
+	o.failed = append(o.failed, name)
+}
+
+func TestRouter_SetObserver(t *testing.T) {
+	r := NewRouter()
+	observer := &testObserver{}
+	r.SetObserver(observer)
+
+	r.Prepare("ok")
+	r.Add("ok", func() (net.Conn, error) {
+		c1, _ := net.Pipe()
+		return c1, nil
+	}, func() error { return nil })
+	r.Prepare("fail")
+	r.Add("fail", func() (net.Conn, error) {
+		return nil, errors.New("fail")
+	}, func() error { return nil })
+
+	conn, err := r.Get("ok").Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	_, err = r.Get("fail").Open()
+	if err == nil {
+		t.Fatal("expect err")
+	}
+
+	if len(observer.opened) != 1 || observer.opened[0] != "ok" {
+		t.Fatal("expect", []string{"ok"}, "got", observer.opened)
+	}
+	if len(observer.failed) != 1 || observer.failed[0] != "fail" {
+		t.Fatal("expect", []string{"fail"}, "got", observer.failed)
+	}
+}
+
 func TestRouter_All(t *testing.T) {
 	must := func(err error) {
 		if err != nil {
@@ -193,3 +288,197 @@ func TestRouter_All(t *testing.T) {
 
 	}()
 }
+
+func TestRouter_MatchHost(t *testing.T) {
+	r := NewRouter()
+	for _, name := range []string{"web", "tcp", "custom"} {
+		if err := r.Prepare(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Get("web").Attribute().Update(func(attr *Attribute) error {
+		attr.HTTP.Is = true
+		return nil
+	})
+	r.Get("custom").Attribute().Update(func(attr *Attribute) error {
+		attr.HTTP.Is = true
+		attr.HTTP.Hostnames = []string{"app.example.org"}
+		return nil
+	})
+	if err := r.ClaimHostnames("custom", []string{"App.example.org."}); err != nil {
+		t.Fatal(err)
+	}
+	// listing a hostname in the attribute claims nothing
+	r.Get("web").Attribute().Update(func(attr *Attribute) error {
+		attr.HTTP.Hostnames = []string{"web.example.org"}
+		return nil
+	})
+
+	for host, expect := range map[string]string{
+		"web.example.test":      "web",
+		"WEB.example.test:8080": "web",
+		"tcp.example.test":      "",
+		"none.example.test":     "",
+		"example.test":          "",
+		"app.example.org":       "custom",
+		"app.example.org:443":   "custom",
+		"web.example.org":       "",
+	} {
+		name := ""
+		if s := r.MatchHost(host, "example.test", []string{"*.example.org"}); s != nil {
+			name = s.Name()
+		}
+		if name != expect {
+			t.Fatal(host, "expect", expect, "got", name)
+		}
+	}
+
+	if s := r.MatchHost("web.example.test", "", nil); s != nil {
+		t.Fatal("expect nil got", s.Name())
+	}
+	if s := r.MatchHost("app.example.org", "", nil); s != nil {
+		t.Fatal("expect nil got", s.Name())
+	}
+}
+
+func TestRouter_ClaimHostnames(t *testing.T) {
+	r := NewRouter()
+	for _, name := range []string{"a", "b"} {
+		if err := r.Prepare(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.ClaimHostnames("a", []string{"a.example.org", "www.example.org"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ClaimHostnames("a", []string{"a.example.org"}); err != nil {
+		t.Fatal("expect a claim again got", err)
+	}
+
+	err := r.ClaimHostnames("b", []string{"b.example.org", "WWW.example.org"})
+	if errors.Cause(err) != ErrHostnameExist {
+		t.Fatal("expect", ErrHostnameExist, "got", err)
+	}
+	// nothing claimed by the failed call
+	if err := r.ClaimHostnames("a", []string{"b.example.org"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.ClaimHostnames("none", []string{"none.example.org"}); err == nil {
+		t.Fatal("expect error for a missing service")
+	}
+
+	r.Remove("a")
+	if err := r.ClaimHostnames("b", []string{"www.example.org"}); err != nil {
+		t.Fatal("expect hostnames released with a, got", err)
+	}
+}
+
+func TestRouter_Claim(t *testing.T) {
+	r := NewRouter()
+
+	token, err := r.Claim("test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == "" {
+		t.Fatal("expect token")
+	}
+	if _, err := r.Claim("test", token); errors.Cause(err) != ErrServiceExist {
+		t.Fatal("expect(error)", ErrServiceExist, "got", errors.Cause(err))
+	}
+	r.Add("test", func() (net.Conn, error) {
+		c1, _ := net.Pipe()
+		return c1, nil
+	}, func() error {
+		return nil
+	})
+
+	r.Release("test", "other", time.Minute)
+	if r.Get("test").Info().Reserved {
+		t.Fatal("expect !reserved by another token")
+	}
+	r.Release("test", token, time.Minute)
+	if !r.Get("test").Info().Reserved {
+		t.Fatal("expect reserved")
+	}
+	if _, err := r.Claim("test", "other"); errors.Cause(err) != ErrServiceReserved {
+		t.Fatal("expect(error)", ErrServiceReserved, "got", errors.Cause(err))
+	}
+	if err := r.Prepare("test"); errors.Cause(err) != ErrServiceExist {
+		t.Fatal("expect(error)", ErrServiceExist, "got", errors.Cause(err))
+	}
+
+	reclaimed, err := r.Claim("test", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed != token {
+		t.Fatal("expect", token, "got", reclaimed)
+	}
+
+	// Open waits for the owner to add the service again
+	opened := make(chan error, 1)
+	go func() {
+		conn, err := r.Get("test").Open()
+		if err == nil {
+			conn.Close()
+		}
+		opened <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	r.Add("test", func() (net.Conn, error) {
+		c1, _ := net.Pipe()
+		return c1, nil
+	}, func() error {
+		return nil
+	})
+	if err := <-opened; err != nil {
+		t.Fatal(err)
+	}
+	if r.Get("test").Info().Reserved {
+		t.Fatal("expect !reserved")
+	}
+
+	r.Release("test", token, 0)
+	if r.Get("test") != nil {
+		t.Fatal("expect removed")
+	}
+}
+
+func TestRouter_Release(t *testing.T) {
+	defer func(wait time.Duration) {
+		ReservedWait = wait
+	}(ReservedWait)
+	ReservedWait = 10 * time.Millisecond
+
+	r := NewRouter()
+	token, err := r.Claim("test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.ClaimHostnames("test", []string{"test.example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Release("test", token, 50*time.Millisecond)
+	if _, err := r.Get("test").Open(); err == nil {
+		t.Fatal("expect error while the owner is away")
+	}
+	r.mu.Lock()
+	owner := r.hosts["test.example.org"]
+	r.mu.Unlock()
+	if owner != "test" {
+		t.Fatal("expect hostname kept while reserved")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if r.Get("test") != nil {
+		t.Fatal("expect reservation expired")
+	}
+	if _, err := r.Claim("test", token); err != nil {
+		t.Fatal("expect name free after expiry got", err)
+	}
+}
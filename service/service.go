@@ -3,26 +3,148 @@ package service
 import (
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/juju/errors"
 )
 
 type Service struct {
+	counters counters // first for 64-bit alignment
+
 	mu      *sync.RWMutex
 	name    string
 	attr    *SafedAttribute
 	openFn  func() (net.Conn, error)
 	closeFn func() error
+
+	group  *group  // nil unless the service is a group
+	router *Router // nil unless the service is routed
+
+	token    string        // of the owner, set by Router.Claim
+	reserved *reservation  // set while the name waits for its owner
+	ready    chan struct{} // closed once openFn is set
+
+	lastOpenErr string
+	limiter     *limiter
+}
+
+// ReservedWait bounds how long Open waits for the owner of a reserved
+// service to come back.
+var ReservedWait = 5 * time.Second
+
+// reservation keeps a service for its owner until timer fires, timer is
+// nil once the owner reclaimed it.
+type reservation struct {
+	timer *time.Timer
 }
 
 func newService(name string) *Service {
 	return &Service{
+		counters: counters{},
+
 		mu:      new(sync.RWMutex),
 		name:    name,
 		attr:    NewSafedAttribute(new(Attribute)),
 		openFn:  nil,
 		closeFn: nil,
+
+		group:  nil,
+		router: nil,
+
+		token:    "",
+		reserved: nil,
+		ready:    make(chan struct{}),
+
+		lastOpenErr: "",
+		limiter:     newLimiter(),
+	}
+}
+
+func newGroupService(name string, balance Balance) *Service {
+	s := newService(name)
+	s.group = newGroup(balance)
+	return s
+}
+
+// Info is the public description of a service.
+type Info struct {
+	Attribute
+	Balance Balance  `json:",omitempty"`
+	Members []Member `json:",omitempty"`
+
+	// Reserved is set while the service waits for its owner to come back.
+	Reserved bool `json:",omitempty"`
+
+	Stats  Stats
+	Limits *Limits `json:",omitempty"`
+}
+
+func (s *Service) Info() Info {
+	var info Info
+	s.Attribute().View(func(attr Attribute) error {
+		info.Attribute = attr
+		return nil
+	})
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.group != nil {
+		info.Balance = s.group.balance
+		info.Members = s.group.snapshot()
+	}
+	info.Reserved = s.reserved != nil
+	info.Stats = s.stats()
+	if limits := s.limiter.get(); !limits.Unlimited() {
+		info.Limits = &limits
 	}
+	return info
+}
+
+// SetLimits bounds the streams of s, the open ones count against the
+// new limits. The limits of a routed service are capped by the router.
+func (s *Service) SetLimits(limits Limits) {
+	if s == nil {
+		panic("service is nil")
+	}
+
+	var max Limits
+	if r := s.router; r != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		max = r.limits
+	}
+	s.limiter.set(limits, max)
+}
+
+// Stats returns the stream counters of s, of all members for a group.
+func (s *Service) Stats() Stats {
+	if s == nil {
+		return Stats{}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.stats()
+}
+
+// stats is Stats, s.mu must be held.
+func (s *Service) stats() Stats {
+	stats := s.counters.snapshot()
+	stats.LastOpenError = s.lastOpenErr
+	return stats
+}
+
+func (s *Service) isHTTP() bool {
+	var is bool
+	s.Attribute().View(func(attr Attribute) error {
+		is = attr.HTTP.Is
+		return nil
+	})
+	return is
 }
 
 func (s *Service) Name() string {
@@ -51,14 +173,112 @@ func (s *Service) Open() (net.Conn, error) {
 		return nil, errors.NotFoundf("service")
 	}
 
+	var observer Observer
+	if s.router != nil {
+		observer = s.router.getObserver()
+	}
+
+	s.waitOwner()
+	conn, err := s.open()
+	if err != nil {
+		s.mu.Lock()
+		s.lastOpenErr = err.Error()
+		s.mu.Unlock()
+	}
+	if observer != nil {
+		if err != nil {
+			observer.OpenFailed(s.name, err)
+			return nil, err
+		}
+		conn = observer.Opened(s.name, conn)
+	}
+	return conn, err
+}
+
+// waitOwner blocks while s is reserved for its owner, at most ReservedWait.
+func (s *Service) waitOwner() {
+	s.mu.RLock()
+	reserved, ready := s.reserved != nil, s.ready
+	s.mu.RUnlock()
+
+	if !reserved {
+		return
+	}
+
+	timer := time.NewTimer(ReservedWait)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+	case <-timer.C:
+	}
+}
+
+// setReady wakes up waitOwner, s.mu must be held.
+func (s *Service) setReady() {
+	select {
+	case <-s.ready:
+	default:
+		close(s.ready)
+	}
+}
+
+// unreserve ends the reservation of a removed s and wakes up waitOwner.
+func (s *Service) unreserve() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reserved != nil && s.reserved.timer != nil {
+		s.reserved.timer.Stop()
+	}
+	s.reserved = nil
+	s.setReady()
+}
+
+func (s *Service) open() (net.Conn, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if s.openFn == nil {
-		return nil, errors.Errorf("service %q is not ready", s.Name())
+	if s.group == nil && s.openFn == nil {
+		return nil, errors.Errorf("service %q is not ready", s.name)
+	}
+	err := s.limiter.acquire()
+	if err != nil {
+		return nil, errors.Annotatef(err, "Open %q", s.name)
 	}
+
+	conn, err := s.openStream()
+	if err != nil {
+		s.limiter.release()
+		return nil, err
+	}
+	return newCountedConn(s.limiter.wrap(conn), &s.counters), nil
+}
+
+// openStream opens a stream of s or of a member of its group, s.mu must
+// be held.
+func (s *Service) openStream() (net.Conn, error) {
+	if s.group != nil {
+		m := s.group.pick()
+		if m == nil {
+			return nil, errors.Errorf("service %q is not ready", s.name)
+		}
+		conn, err := m.open()
+		return conn, errors.Annotatef(err, "Open %q member %d", s.name, m.id)
+	}
+
 	conn, err := s.openFn()
-	return conn, errors.Annotatef(err, "Open %q", s.Name())
+	return conn, errors.Annotatef(err, "Open %q", s.name)
+}
+
+// Active returns the number of connections opened by Open and not yet
+// closed.
+func (s *Service) Active() int64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&s.counters.active)
 }
 func (s *Service) Close() error {
 	if s == nil {
@@ -68,6 +288,19 @@ func (s *Service) Close() error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	if s.group != nil {
+		var err error
+		for _, m := range s.group.members {
+			if m.closeFn == nil {
+				continue
+			}
+			if e := m.closeFn(); e != nil && err == nil {
+				err = e
+			}
+		}
+		return errors.Annotatef(err, "Close %q", s.name)
+	}
+
 	if s.closeFn == nil {
 		return errors.Errorf("service %q is not ready", s.Name())
 	}
@@ -87,6 +320,8 @@ func (s *Service) setOpenFunc(fn func() (net.Conn, error)) {
 	defer s.mu.Unlock()
 
 	s.openFn = fn
+	s.reserved = nil
+	s.setReady()
 }
 
 func (s *Service) setCloseFunc(fn func() error) {
@@ -2,8 +2,10 @@ package service
 
 import (
 	"errors"
+	"io"
 	"net"
 	"testing"
+	"time"
 )
 
 func TestService(t *testing.T) {
@@ -58,3 +60,46 @@ func TestService(t *testing.T) {
 		t.Fatal("expect", "openCalled", "got", "!openCalled")
 	}
 }
+
+func TestService_Stats(t *testing.T) {
+	service := newService("test")
+	_, err := service.Open()
+	if err == nil {
+		t.Fatal("expect err")
+	}
+	if stats := service.Stats(); stats.LastOpenError != err.Error() {
+		t.Fatal("expect", err.Error(), "got", stats.LastOpenError)
+	}
+
+	service.setOpenFunc(func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go func() {
+			buf := make([]byte, 5)
+			io.ReadFull(c1, buf)
+			c1.Write([]byte("hi"))
+			c1.Close()
+		}()
+		return c2, nil
+	})
+
+	conn, err := service.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello"))
+	io.ReadAll(conn)
+
+	stats := service.Stats()
+	if stats.Active != 1 || stats.Total != 1 || stats.Sent != 5 || stats.Received != 2 {
+		t.Fatal("expect", "1 1 5 2", "got", stats.Active, stats.Total, stats.Sent, stats.Received)
+	}
+	if stats.LastActivity == nil || time.Since(*stats.LastActivity) > time.Second {
+		t.Fatal("expect recent activity got", stats.LastActivity)
+	}
+
+	conn.Close()
+	conn.Close()
+	if stats := service.Info().Stats; stats.Active != 0 || stats.Total != 1 {
+		t.Fatal("expect", "0 1", "got", stats.Active, stats.Total)
+	}
+}
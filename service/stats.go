@@ -0,0 +1,96 @@
+package service
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/service-exposer/exposer/listener"
+)
+
+// Stats is a snapshot of the streams of a service. Received and Sent are
+// the bytes read from and written to the service.
+type Stats struct {
+	Active   int64
+	Total    int64
+	Received int64
+	Sent     int64
+
+	LastActivity  *time.Time `json:",omitempty"` // nil before the first stream
+	LastOpenError string     `json:",omitempty"`
+}
+
+// counters are the stream counters of a service or a group member.
+type counters struct {
+	active       int64 // atomic, all of them
+	total        int64
+	received     int64
+	sent         int64
+	lastActivity int64 // unix nano
+}
+
+func (c *counters) touch() {
+	atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
+}
+
+func (c *counters) snapshot() Stats {
+	stats := Stats{
+		Active:   atomic.LoadInt64(&c.active),
+		Total:    atomic.LoadInt64(&c.total),
+		Received: atomic.LoadInt64(&c.received),
+		Sent:     atomic.LoadInt64(&c.sent),
+	}
+	if last := atomic.LoadInt64(&c.lastActivity); last != 0 {
+		t := time.Unix(0, last)
+		stats.LastActivity = &t
+	}
+	return stats
+}
+
+// countedConn keeps its counters up to date while it is open.
+type countedConn struct {
+	net.Conn
+	once     *sync.Once
+	counters *counters
+}
+
+func newCountedConn(conn net.Conn, c *counters) net.Conn {
+	atomic.AddInt64(&c.active, 1)
+	atomic.AddInt64(&c.total, 1)
+	c.touch()
+	return &countedConn{
+		Conn:     conn,
+		once:     new(sync.Once),
+		counters: c,
+	}
+}
+
+func (conn *countedConn) Read(b []byte) (int, error) {
+	n, err := conn.Conn.Read(b)
+	if n > 0 {
+		atomic.AddInt64(&conn.counters.received, int64(n))
+		conn.counters.touch()
+	}
+	return n, err
+}
+
+func (conn *countedConn) Write(b []byte) (int, error) {
+	n, err := conn.Conn.Write(b)
+	if n > 0 {
+		atomic.AddInt64(&conn.counters.sent, int64(n))
+		conn.counters.touch()
+	}
+	return n, err
+}
+
+func (conn *countedConn) CloseWrite() error {
+	return listener.CloseWrite(conn.Conn)
+}
+
+func (conn *countedConn) Close() error {
+	conn.once.Do(func() {
+		atomic.AddInt64(&conn.counters.active, -1)
+	})
+	return conn.Conn.Close()
+}
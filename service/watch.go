@@ -0,0 +1,85 @@
+package service
+
+import (
+	"sync"
+)
+
+type EventType string
+
+const (
+	EventAdded   EventType = "added"
+	EventUpdated EventType = "updated"
+	EventRemoved EventType = "removed"
+)
+
+// WatchBuffer is how many events a watcher may fall behind before it is
+// dropped.
+var WatchBuffer = 64
+
+// Event is a change of a service in a router. Info is the state after the
+// change, the last one known for EventRemoved.
+type Event struct {
+	Type EventType
+	Name string
+	Info Info
+}
+
+type watchers struct {
+	mu   *sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+func newWatchers() *watchers {
+	return &watchers{
+		mu:   new(sync.Mutex),
+		subs: make(map[chan Event]struct{}),
+	}
+}
+
+// Watch returns the changes of the services in r from now on, until
+// cancel is called. The channel is closed when the watcher falls more
+// than WatchBuffer events behind, the watcher should read the current
+// state again then.
+func (r *Router) Watch() (events <-chan Event, cancel func()) {
+	ch := make(chan Event, WatchBuffer)
+
+	r.watchers.mu.Lock()
+	r.watchers.subs[ch] = struct{}{}
+	r.watchers.mu.Unlock()
+
+	return ch, func() {
+		r.watchers.mu.Lock()
+		defer r.watchers.mu.Unlock()
+
+		if _, exist := r.watchers.subs[ch]; exist {
+			delete(r.watchers.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// emit must be called holding r.mu, so watchers get the changes in the
+// order they were made, and without the lock of s. The mutations of r
+// defer it right after deferring r.mu.Unlock to run it between the two.
+func (r *Router) emit(typ EventType, s *Service) {
+	r.watchers.mu.Lock()
+	defer r.watchers.mu.Unlock()
+
+	if len(r.watchers.subs) == 0 {
+		return
+	}
+
+	event := Event{
+		Type: typ,
+		Name: s.Name(),
+		Info: s.Info(),
+	}
+	for ch := range r.watchers.subs {
+		select {
+		case ch <- event:
+		default:
+			delete(r.watchers.subs, ch)
+			close(ch)
+		}
+	}
+}
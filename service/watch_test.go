@@ -0,0 +1,141 @@
+package service
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestRouter_Watch(t *testing.T) {
+	r := NewRouter()
+	events, cancel := r.Watch()
+
+	expect := func(typ EventType, name string) Event {
+		select {
+		case event := <-events:
+			if event.Type != typ || event.Name != name {
+				t.Fatal("expect", typ, name, "got", event.Type, event.Name)
+			}
+			return event
+		default:
+			t.Fatal("expect", typ, name, "got nothing")
+		}
+		return Event{}
+	}
+
+	r.Prepare("web")
+	expect(EventAdded, "web")
+
+	r.Get("web").Attribute().Update(func(attr *Attribute) error {
+		attr.HTTP.Is = true
+		return nil
+	})
+	event := expect(EventUpdated, "web")
+	if !event.Info.HTTP.Is {
+		t.Fatal("expect", true, "got", event.Info.HTTP.Is)
+	}
+
+	r.Add("web", func() (net.Conn, error) {
+		return nil, nil
+	}, func() error { return nil })
+	expect(EventUpdated, "web")
+
+	r.Remove("web")
+	event = expect(EventRemoved, "web")
+	if !event.Info.HTTP.Is {
+		t.Fatal("expect last Info in", EventRemoved)
+	}
+
+	id, _ := r.Join("group", RoundRobin, "")
+	expect(EventAdded, "group")
+	r.Join("group", RoundRobin, "")
+	expect(EventUpdated, "group")
+	r.Leave("group", id)
+	expect(EventUpdated, "group")
+
+	cancel()
+	cancel()
+	if _, ok := <-events; ok {
+		t.Fatal("expect closed after cancel")
+	}
+}
+
+func TestRouter_WatchSlow(t *testing.T) {
+	r := NewRouter()
+	events, cancel := r.Watch()
+	defer cancel()
+
+	for i := 0; i <= WatchBuffer; i++ {
+		r.Prepare("test")
+		r.Remove("test")
+	}
+
+	n := 0
+	for range events {
+		n++
+	}
+	if n != WatchBuffer {
+		t.Fatal("expect", WatchBuffer, "got", n)
+	}
+}
+
+func TestRouter_WatchOrder(t *testing.T) {
+	defer func(n int) { WatchBuffer = n }(WatchBuffer)
+	WatchBuffer = 1 << 16
+
+	r := NewRouter()
+	events, cancel := r.Watch()
+	defer cancel()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if r.Prepare("web") == nil {
+					r.Get("web").Attribute().Update(func(attr *Attribute) error {
+						attr.HTTP.Is = true
+						return nil
+					})
+					r.Remove("web")
+				}
+
+				id, err := r.Join("group", RoundRobin, "")
+				if err == nil {
+					r.Leave("group", id)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	exist := make(map[string]bool)
+	for {
+		var (
+			event Event
+			ok    bool
+		)
+		select {
+		case event, ok = <-events:
+			if !ok {
+				t.Fatal("expect watcher kept")
+			}
+		default:
+			return
+		}
+
+		switch event.Type {
+		case EventAdded:
+			if exist[event.Name] {
+				t.Fatal("expect", event.Name, "added once")
+			}
+			exist[event.Name] = true
+		case EventUpdated, EventRemoved:
+			if !exist[event.Name] {
+				t.Fatal("expect", event.Name, "added before", event.Type)
+			}
+			exist[event.Name] = event.Type == EventUpdated
+		}
+	}
+}
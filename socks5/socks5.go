@@ -0,0 +1,199 @@
+// Package socks5 is the server side of SOCKS5 (RFC 1928) CONNECT without
+// authentication. It only negotiates, the caller dials the target.
+package socks5
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/listener"
+	"github.com/service-exposer/exposer/netacl"
+	"github.com/service-exposer/exposer/protocal/forward"
+	"github.com/service-exposer/exposer/protocal/route"
+)
+
+const (
+	version = 0x05
+
+	methodNoAuth       = 0x00
+	methodNoAcceptable = 0xff
+
+	cmdConnect = 0x01
+
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+
+	repSucceeded           = 0x00
+	repGeneralFailure      = 0x01
+	repNotAllowed          = 0x02
+	repHostUnreachable     = 0x04
+	repConnectionRefused   = 0x05
+	repCommandNotSupported = 0x07
+	repAddressNotSupported = 0x08
+)
+
+var (
+	// HandshakeTimeout bounds the negotiation of every connection.
+	HandshakeTimeout = 10 * time.Second
+)
+
+var (
+	ErrVersion             = errors.New("not SOCKS5")
+	ErrNoAcceptableMethod  = errors.New("no acceptable authentication method")
+	ErrCommandNotSupported = errors.New("command not supported")
+	ErrAddressNotSupported = errors.New("address type not supported")
+)
+
+// Conn is a client connection that asked to CONNECT to its target.
+type Conn struct {
+	net.Conn
+	address string
+
+	mu      *sync.Mutex
+	replied bool
+}
+
+// Target returns the destination asked for, hostnames are not resolved.
+func (conn *Conn) Target() (network, address string) {
+	return "tcp", conn.address
+}
+
+// Dialed answers the request, err is nil if the target was reached.
+func (conn *Conn) Dialed(err error) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.replied {
+		return nil
+	}
+	conn.replied = true
+
+	rep := byte(repSucceeded)
+	if err != nil {
+		rep = replyCode(err)
+	}
+	return errors.Trace(reply(conn.Conn, rep))
+}
+
+// CloseWrite half-closes the client connection if it supports it.
+func (conn *Conn) CloseWrite() error {
+	return listener.CloseWrite(conn.Conn)
+}
+
+// replyCode is the SOCKS reply of err, a failed dial of the daemon.
+func replyCode(err error) byte {
+	switch errors.Cause(err) {
+	case netacl.ErrDenied, forward.ErrForbidden, route.ErrForbidden:
+		return repNotAllowed
+	case forward.ErrRefused:
+		return repConnectionRefused
+	case forward.ErrUnreachable:
+		return repHostUnreachable
+	}
+	return repGeneralFailure
+}
+
+func reply(w io.Writer, rep byte) error {
+	// bound address is not known, 0.0.0.0:0
+	_, err := w.Write([]byte{version, rep, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// Handshake negotiates with a client on conn and reads its request.
+func Handshake(conn net.Conn) (*Conn, error) {
+	var header [2]byte
+	_, err := io.ReadFull(conn, header[:])
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if header[0] != version {
+		return nil, errors.Trace(ErrVersion)
+	}
+
+	methods := make([]byte, header[1])
+	_, err = io.ReadFull(conn, methods)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	method := byte(methodNoAcceptable)
+	for _, m := range methods {
+		if m == methodNoAuth {
+			method = methodNoAuth
+		}
+	}
+	_, err = conn.Write([]byte{version, method})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if method == methodNoAcceptable {
+		return nil, errors.Trace(ErrNoAcceptableMethod)
+	}
+
+	var req [4]byte
+	_, err = io.ReadFull(conn, req[:])
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if req[0] != version {
+		return nil, errors.Trace(ErrVersion)
+	}
+
+	var host string
+	switch req[3] {
+	case atypIPv4, atypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if req[3] == atypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		_, err = io.ReadFull(conn, ip)
+		host = ip.String()
+	case atypDomain:
+		var n [1]byte
+		_, err = io.ReadFull(conn, n[:])
+		if err == nil {
+			domain := make([]byte, n[0])
+			_, err = io.ReadFull(conn, domain)
+			host = string(domain)
+		}
+	default:
+		reply(conn, repAddressNotSupported)
+		return nil, errors.Annotatef(ErrAddressNotSupported, "%#x", req[3])
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var port [2]byte
+	_, err = io.ReadFull(conn, port[:])
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if req[1] != cmdConnect {
+		reply(conn, repCommandNotSupported)
+		return nil, errors.Annotatef(ErrCommandNotSupported, "%#x", req[1])
+	}
+
+	return &Conn{
+		Conn:    conn,
+		address: net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))),
+
+		mu:      new(sync.Mutex),
+		replied: false,
+	}, nil
+}
+
+// NewListener returns a listener that negotiates with every client of ln
+// and accepts the ones that made a CONNECT request, as *Conn.
+func NewListener(ln net.Listener) net.Listener {
+	return listener.Handshake(ln, HandshakeTimeout, func(conn net.Conn) (net.Conn, error) {
+		return Handshake(conn)
+	})
+}
@@ -0,0 +1,116 @@
+package socks5
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/netacl"
+	"github.com/service-exposer/exposer/protocal/forward"
+)
+
+func TestHandshake(t *testing.T) {
+	for req, expect := range map[string]string{
+		"\x05\x01\x00\x01\x0a\x00\x00\x02\x00\x16":                 "10.0.0.2:22",
+		"\x05\x01\x00\x03\x0bexample.org\x01\xbb":                  "example.org:443",
+		"\x05\x01\x00\x04" + string(net.IPv6loopback) + "\x00\x50": "[::1]:80",
+	} {
+		c1, c2 := net.Pipe()
+		go func() {
+			c2.Write([]byte("\x05\x02\x02\x00"))
+			c2.Write([]byte(req))
+		}()
+		go io.Copy(io.Discard, c2)
+
+		conn, err := Handshake(c1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		network, address := conn.Target()
+		if network != "tcp" || address != expect {
+			t.Fatal("expect", expect, "got", network, address)
+		}
+		c1.Close()
+	}
+}
+
+func TestHandshake_reply(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	go func() {
+		conn, err := Handshake(c1)
+		if err != nil {
+			return
+		}
+		conn.Dialed(errors.Annotate(forward.ErrRefused, "dial tcp 10.0.0.2:22"))
+	}()
+
+	c2.Write([]byte("\x05\x01\x00"))
+	method := make([]byte, 2)
+	_, err := io.ReadFull(c2, method)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(method, []byte{0x05, 0x00}) {
+		t.Fatal("expect no authentication got", method)
+	}
+
+	c2.Write([]byte("\x05\x01\x00\x01\x0a\x00\x00\x02\x00\x16"))
+	data := make([]byte, 10)
+	_, err = io.ReadFull(c2, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte{0x05, repConnectionRefused, 0x00, 0x01, 0, 0, 0, 0, 0, 0}) {
+		t.Fatal("expect connection refused reply got", data)
+	}
+}
+
+func Test_replyCode(t *testing.T) {
+	for err, expect := range map[error]byte{
+		errors.Annotate(netacl.ErrDenied, "loopback address 127.0.0.1"): repNotAllowed,
+		errors.Annotate(forward.ErrForbidden, "forward \"x:1\""):        repNotAllowed,
+		errors.Trace(forward.ErrRefused):                                repConnectionRefused,
+		errors.Trace(forward.ErrUnreachable):                            repHostUnreachable,
+		errors.New("connection refused"):                                repGeneralFailure,
+	} {
+		if code := replyCode(err); code != expect {
+			t.Fatal("expect", expect, "for", err, "got", code)
+		}
+	}
+}
+
+func TestHandshake_unsupported(t *testing.T) {
+	for req, expect := range map[string]byte{
+		"\x05\x02\x00\x01\x0a\x00\x00\x02\x00\x35": repCommandNotSupported, // BIND
+		"\x05\x01\x00\x05\x00\x00":                 repAddressNotSupported,
+	} {
+		c1, c2 := net.Pipe()
+		go func() {
+			c2.Write([]byte("\x05\x01\x00"))
+			c2.Write([]byte(req))
+		}()
+
+		errch := make(chan error, 1)
+		go func() {
+			_, err := Handshake(c1)
+			errch <- err
+		}()
+
+		data := make([]byte, 4)
+		_, err := io.ReadFull(c2, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data[3] != expect {
+			t.Fatal("expect", expect, "got", data[3])
+		}
+		go io.Copy(io.Discard, c2)
+		if <-errch == nil {
+			t.Fatal("expect error for", []byte(req))
+		}
+		c1.Close()
+	}
+}
@@ -0,0 +1,156 @@
+// Package stdio links a pair of streams, like the stdin and stdout of
+// a process, as one link stream.
+package stdio
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/client"
+	"github.com/service-exposer/exposer/listener"
+)
+
+// ErrUnclean is returned by Conn.Wait when the stream was closed before
+// the peer finished sending.
+var ErrUnclean = errors.New("stream closed by daemon")
+
+// Conn is a net.Conn reading in and writing out.
+type Conn struct {
+	in  io.Reader
+	out io.WriteCloser
+
+	mu          *sync.Mutex
+	closedWrite bool
+	openErr     error
+
+	done      chan struct{}
+	closeOnce *sync.Once
+}
+
+func NewConn(in io.Reader, out io.WriteCloser) *Conn {
+	return &Conn{
+		in:  in,
+		out: out,
+
+		mu:          new(sync.Mutex),
+		closedWrite: false,
+		openErr:     nil,
+
+		done:      make(chan struct{}),
+		closeOnce: new(sync.Once),
+	}
+}
+
+func (conn *Conn) Read(b []byte) (int, error) {
+	return conn.in.Read(b)
+}
+
+func (conn *Conn) Write(b []byte) (int, error) {
+	return conn.out.Write(b)
+}
+
+// CloseWrite closes stdout, the peer is done sending.
+func (conn *Conn) CloseWrite() error {
+	conn.mu.Lock()
+	conn.closedWrite = true
+	conn.mu.Unlock()
+
+	return errors.Trace(conn.out.Close())
+}
+
+// Opened records why the service could not be opened, see link.Stream.
+func (conn *Conn) Opened(err error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	conn.openErr = err
+}
+
+// Wait blocks until conn or r, the link route of conn, is closed. It
+// returns nil if the peer finished sending before conn was closed, the
+// error of opening the service or of r otherwise.
+func (conn *Conn) Wait(r *client.Route) error {
+	select {
+	case <-conn.done:
+	case <-r.Done():
+		err := r.Wait()
+		if err == nil {
+			err = ErrUnclean
+		}
+		return errors.Annotate(err, "link closed")
+	}
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.openErr != nil {
+		return errors.Annotate(conn.openErr, "open service")
+	}
+	if !conn.closedWrite {
+		return errors.Trace(ErrUnclean)
+	}
+	return nil
+}
+
+// Close marks conn done, stdin is left to the process exit since a
+// blocked read on it can't be interrupted.
+func (conn *Conn) Close() error {
+	conn.closeOnce.Do(func() {
+		close(conn.done)
+	})
+	return nil
+}
+
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string { return "stdio" }
+func (stdioAddr) String() string  { return "stdio" }
+
+func (conn *Conn) LocalAddr() net.Addr                { return stdioAddr{} }
+func (conn *Conn) RemoteAddr() net.Addr               { return stdioAddr{} }
+func (conn *Conn) SetDeadline(t time.Time) error      { return nil }
+func (conn *Conn) SetReadDeadline(t time.Time) error  { return nil }
+func (conn *Conn) SetWriteDeadline(t time.Time) error { return nil }
+
+// stdioListener accepts conn once, then blocks until closed.
+type stdioListener struct {
+	accepts chan net.Conn
+
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+// NewListener accepts conn once, for a link route.
+func NewListener(conn net.Conn) net.Listener {
+	ln := &stdioListener{
+		accepts: make(chan net.Conn, 1),
+
+		closed:    make(chan struct{}),
+		closeOnce: new(sync.Once),
+	}
+	ln.accepts <- conn
+	return ln
+}
+
+func (ln *stdioListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.accepts:
+		return conn, nil
+	case <-ln.closed:
+		return nil, errors.Annotate(listener.ErrListenerClosed, "stdio")
+	}
+}
+
+func (ln *stdioListener) Close() error {
+	ln.closeOnce.Do(func() {
+		close(ln.closed)
+	})
+	return nil
+}
+
+func (ln *stdioListener) Addr() net.Addr {
+	return stdioAddr{}
+}
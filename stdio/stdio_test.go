@@ -0,0 +1,261 @@
+package stdio
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/service-exposer/exposer/client"
+	"github.com/service-exposer/exposer/listener/utils"
+	"github.com/service-exposer/exposer/protocal"
+	"github.com/service-exposer/exposer/protocal/auth"
+	"github.com/service-exposer/exposer/protocal/route"
+	"github.com/service-exposer/exposer/service"
+)
+
+// daemon serves router and closes every session on close.
+type daemon struct {
+	url string
+
+	mu    *sync.Mutex
+	conns []net.Conn
+}
+
+func serve(t *testing.T, router *service.Router) *daemon {
+	ln, err := utils.WebsocketListener("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &daemon{
+		url: "ws://" + ln.Addr().String(),
+
+		mu:    new(sync.Mutex),
+		conns: nil,
+	}
+
+	go protocal.Serve(ln, func(conn net.Conn) protocal.ProtocalHandler {
+		d.mu.Lock()
+		d.conns = append(d.conns, conn)
+		d.mu.Unlock()
+
+		proto := protocal.NewProtocal(conn)
+		proto.On = auth.ServerSideWithConfig(&auth.Config{
+			Route: route.Config{
+				Router: router,
+			},
+			Key: func(identity string) (string, bool) {
+				return "test", identity == auth.DefaultIdentity
+			},
+		})
+		return proto
+	})
+
+	return d
+}
+
+func (d *daemon) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, conn := range d.conns {
+		conn.Close()
+	}
+}
+
+// output is the stdout of a test.
+type output struct {
+	mu     *sync.Mutex
+	buf    *bytes.Buffer
+	closed bool
+}
+
+func newOutput() *output {
+	return &output{
+		mu:     new(sync.Mutex),
+		buf:    new(bytes.Buffer),
+		closed: false,
+	}
+}
+
+func (out *output) Write(b []byte) (int, error) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	return out.buf.Write(b)
+}
+
+func (out *output) Close() error {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	out.closed = true
+	return nil
+}
+
+func (out *output) isClosed() bool {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	return out.closed
+}
+
+func (out *output) String() string {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	return out.buf.String()
+}
+
+func dial(t *testing.T, ctx context.Context, d *daemon) *client.Client {
+	c, err := client.Dial(ctx, d.url, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func wait(t *testing.T, conn *Conn, r *client.Route) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- conn.Wait(r)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect conn closed")
+	}
+	return nil
+}
+
+func TestConn_clean(t *testing.T) {
+	router := service.NewRouter()
+	d := serve(t, router)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := dial(t, ctx, d)
+	defer c.Close()
+
+	_, err := c.Expose(ctx, "echo", service.Attribute{}, func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go func() {
+			io.Copy(c1, c1)
+			c1.Close()
+		}()
+		return c2, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := newOutput()
+	conn := NewConn(strings.NewReader("hello"), out)
+	r, err := c.LinkWithListener(ctx, "echo", NewListener(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	err = wait(t, conn, r)
+	if err != nil {
+		t.Fatal("expect", nil, "got", err)
+	}
+	if out.String() != "hello" {
+		t.Fatal("expect", "hello", "got", out.String())
+	}
+	if !out.isClosed() {
+		t.Fatal("expect", "stdout closed")
+	}
+}
+
+func TestConn_unreachable(t *testing.T) {
+	router := service.NewRouter()
+	router.Prepare("down")
+	d := serve(t, router)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := dial(t, ctx, d)
+	defer c.Close()
+
+	in, _ := io.Pipe()
+	conn := NewConn(in, newOutput())
+	r, err := c.LinkWithListener(ctx, "down", NewListener(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	err = wait(t, conn, r)
+	if err == nil || !strings.Contains(err.Error(), "open service") {
+		t.Fatal("expect", "open service error", "got", err)
+	}
+
+	// only the stream failed
+	select {
+	case <-r.Done():
+		t.Fatal("expect route alive got", r.Wait())
+	default:
+	}
+}
+
+func TestConn_daemonClose(t *testing.T) {
+	router := service.NewRouter()
+	d := serve(t, router)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	exposer := dial(t, ctx, d)
+	defer exposer.Close()
+
+	_, err := exposer.Expose(ctx, "hold", service.Attribute{}, func() (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go func() {
+			c1.Write([]byte("hi"))
+			io.Copy(io.Discard, c1)
+		}()
+		return c2, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := dial(t, ctx, d)
+	defer c.Close()
+
+	in, w := io.Pipe()
+	defer w.Close()
+	out := newOutput()
+	conn := NewConn(in, out)
+	r, err := c.LinkWithListener(ctx, "hold", NewListener(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for out.String() != "hi" {
+		select {
+		case <-ctx.Done():
+			t.Fatal("expect", "hi", "got", out.String())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	d.close()
+
+	err = wait(t, conn, r)
+	if err == nil {
+		t.Fatal("expect", "link closed", "got", err)
+	}
+}
@@ -0,0 +1,132 @@
+// Package tunnels runs the exposes, links and forwards listed in a config
+// file over one client session.
+package tunnels
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/juju/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is a tunnels file:
+//
+//	exposes:
+//	  - name: web
+//	    addr: 127.0.0.1:8080
+//	    http:
+//	      hostnames: [www.example.org]
+//	links:
+//	  - name: db
+//	    listen: 127.0.0.1:5432
+//	forwards:
+//	  - addr: 10.0.0.2:22
+//	    listen: 127.0.0.1:2222
+type Config struct {
+	Exposes  []Expose  `yaml:"exposes"`
+	Links    []Link    `yaml:"links"`
+	Forwards []Forward `yaml:"forwards"`
+}
+
+type Expose struct {
+	Name string `yaml:"name"`
+	Addr string `yaml:"addr"` // [host]:port of the service
+
+	// Group, one of round-robin, least-conn or random, joins the service
+	// group Name balanced by it instead of claiming the name.
+	Group string `yaml:"group,omitempty"`
+	// RemotePort, when set, also exposes the service on this TCP port of
+	// the daemon, 0 picks a free port.
+	RemotePort *int `yaml:"remote_port,omitempty"`
+	// HTTP, when set, exposes the service as HTTP.
+	HTTP *HTTP `yaml:"http,omitempty"`
+	// Limits bound the streams of the service, the daemon may lower them.
+	Limits *Limits `yaml:"limits,omitempty"`
+}
+
+// Limits are service.Limits, 0 is unlimited.
+type Limits struct {
+	MaxStreams          int   `yaml:"max_streams,omitempty"`
+	StreamsPerSecond    int   `yaml:"streams_per_second,omitempty"`
+	ReadBytesPerSecond  int64 `yaml:"read_bytes_per_second,omitempty"` // from the service
+	WriteBytesPerSecond int64 `yaml:"write_bytes_per_second,omitempty"`
+}
+
+type HTTP struct {
+	Host      string   `yaml:"host,omitempty"`
+	Hostnames []string `yaml:"hostnames,omitempty"`
+}
+
+type Link struct {
+	Name   string `yaml:"name"`
+	Listen string `yaml:"listen"`
+}
+
+type Forward struct {
+	Addr   string `yaml:"addr"` // destination on the daemon side
+	Listen string `yaml:"listen"`
+}
+
+func Load(filename string) (*Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	config, err := Parse(data)
+	return config, errors.Annotate(err, filename)
+}
+
+func Parse(data []byte) (*Config, error) {
+	config := new(Config)
+	err := yaml.UnmarshalStrict(data, config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return config, errors.Trace(config.validate())
+}
+
+func (config *Config) validate() error {
+	listens := make(map[string]bool)
+	listen := func(addr string) error {
+		if addr == "" {
+			return errors.New("listen is empty")
+		}
+		if listens[addr] {
+			return errors.Errorf("listen %s is used twice", addr)
+		}
+		listens[addr] = true
+		return nil
+	}
+
+	for i, e := range config.Exposes {
+		if e.Name == "" || e.Addr == "" {
+			return errors.Errorf("exposes[%d]: name and addr are required", i)
+		}
+	}
+	for i, l := range config.Links {
+		if l.Name == "" {
+			return errors.Errorf("links[%d]: name is required", i)
+		}
+		if err := listen(l.Listen); err != nil {
+			return errors.Annotatef(err, "links[%d]", i)
+		}
+	}
+	for i, f := range config.Forwards {
+		if f.Addr == "" {
+			return errors.Errorf("forwards[%d]: addr is required", i)
+		}
+		if err := listen(f.Listen); err != nil {
+			return errors.Annotatef(err, "forwards[%d]", i)
+		}
+	}
+	return nil
+}
+
+// key identifies an entry, entries with the same key are unchanged.
+func key(typ string, entry interface{}) string {
+	data, _ := json.Marshal(entry)
+	return typ + " " + string(data)
+}
@@ -0,0 +1,56 @@
+package tunnels
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	config, err := Parse([]byte(`
+exposes:
+  - name: web
+    addr: 127.0.0.1:8080
+    remote_port: 0
+    http:
+      hostnames: [www.example.org]
+    limits:
+      max_streams: 10
+links:
+  - name: db
+    listen: 127.0.0.1:5432
+forwards:
+  - addr: 10.0.0.2:22
+    listen: 127.0.0.1:2222
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.Exposes) != 1 || len(config.Links) != 1 || len(config.Forwards) != 1 {
+		t.Fatal("expect 1 expose, link and forward got", config)
+	}
+	e := config.Exposes[0]
+	if e.RemotePort == nil || *e.RemotePort != 0 {
+		t.Fatal("expect remote port 0 got", e.RemotePort)
+	}
+	if e.HTTP == nil || len(e.HTTP.Hostnames) != 1 || e.HTTP.Hostnames[0] != "www.example.org" {
+		t.Fatal("expect HTTP hostname www.example.org got", e.HTTP)
+	}
+	if e.Limits == nil || e.Limits.MaxStreams != 10 {
+		t.Fatal("expect", 10, "got", e.Limits)
+	}
+}
+
+func TestParse_invalid(t *testing.T) {
+	for _, data := range []string{
+		"exposes:\n  - name: web\n",
+		"links:\n  - name: db\n",
+		"forwards:\n  - listen: 127.0.0.1:2222\n",
+		"exposes:\n  - name: web\n    addr: 127.0.0.1:80\n    unknown: 1\n",
+		"links:\n  - name: a\n    listen: 127.0.0.1:1\nforwards:\n  - addr: 10.0.0.2:22\n    listen: 127.0.0.1:1\n",
+	} {
+		_, err := Parse([]byte(data))
+		if err == nil {
+			t.Fatal("expect error for", data)
+		}
+	}
+}
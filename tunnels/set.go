@@ -0,0 +1,288 @@
+package tunnels
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/service-exposer/exposer/client"
+	"github.com/service-exposer/exposer/listener"
+	"github.com/service-exposer/exposer/protocal/expose"
+	"github.com/service-exposer/exposer/service"
+)
+
+var (
+	// RetryInterval is the wait time before reopening a route that failed
+	// while its session is alive, e.g. an expose whose name is taken.
+	RetryInterval = 5 * time.Second
+)
+
+var (
+	ErrServing = errors.New("tunnels are served by another client")
+)
+
+type tunnel struct {
+	desc   string
+	listen string // local address of links and forwards
+	shared *listener.SharedListener
+	open   func(ctx context.Context, c *client.Client, ln net.Listener) (*client.Route, error)
+
+	stop chan struct{} // closed when the tunnel is removed
+}
+
+// Set is the tunnels of a Config served over one client at a time. The
+// local listeners of links and forwards stay open across clients, so
+// reconnecting does not refuse local connections.
+type Set struct {
+	// Logf, if set, logs the routes going up and down.
+	Logf func(format string, v ...interface{})
+
+	mu      *sync.Mutex
+	tunnels map[string]*tunnel
+	client  *client.Client
+}
+
+func NewSet() *Set {
+	return &Set{
+		Logf: nil,
+
+		mu:      new(sync.Mutex),
+		tunnels: make(map[string]*tunnel),
+		client:  nil,
+	}
+}
+
+func (set *Set) logf(format string, v ...interface{}) {
+	if set.Logf != nil {
+		set.Logf(format, v...)
+	}
+}
+
+func entries(config *Config) map[string]*tunnel {
+	tunnels := make(map[string]*tunnel)
+	for _, e := range config.Exposes {
+		e := e
+		req := &expose.ExposeReq{
+			Name:       e.Name,
+			Group:      service.Balance(e.Group),
+			RemotePort: e.RemotePort,
+		}
+		if e.HTTP != nil {
+			req.Attr.HTTP.Is = true
+			req.Attr.HTTP.Host = e.HTTP.Host
+			req.Attr.HTTP.Hostnames = e.HTTP.Hostnames
+		}
+		if e.Limits != nil {
+			req.Limits = &service.Limits{
+				MaxStreams:          e.Limits.MaxStreams,
+				StreamsPerSecond:    e.Limits.StreamsPerSecond,
+				ReadBytesPerSecond:  e.Limits.ReadBytesPerSecond,
+				WriteBytesPerSecond: e.Limits.WriteBytesPerSecond,
+			}
+		}
+
+		tunnels[key("expose", e)] = &tunnel{
+			desc: "expose " + e.Name,
+			open: func(ctx context.Context, c *client.Client, ln net.Listener) (*client.Route, error) {
+				return c.ExposeWithReq(ctx, req, func() (net.Conn, error) {
+					conn, err := net.Dial("tcp", e.Addr)
+					return conn, errors.Trace(err)
+				})
+			},
+		}
+	}
+	for _, l := range config.Links {
+		l := l
+		tunnels[key("link", l)] = &tunnel{
+			desc:   "link " + l.Name + " on " + l.Listen,
+			listen: l.Listen,
+			open: func(ctx context.Context, c *client.Client, ln net.Listener) (*client.Route, error) {
+				return c.LinkWithListener(ctx, l.Name, ln)
+			},
+		}
+	}
+	for _, f := range config.Forwards {
+		f := f
+		tunnels[key("forward", f)] = &tunnel{
+			desc:   "forward " + f.Addr + " on " + f.Listen,
+			listen: f.Listen,
+			open: func(ctx context.Context, c *client.Client, ln net.Listener) (*client.Route, error) {
+				return c.ForwardWithListener(ctx, "tcp", f.Addr, ln)
+			},
+		}
+	}
+	return tunnels
+}
+
+// Apply makes the tunnels of set those of config. Unchanged tunnels are
+// left alone, removed ones are closed and new ones are opened on the
+// client being served. A new link or forward listening on the address of
+// a removed one takes over its listener. On error set is unchanged.
+func (set *Set) Apply(config *Config) error {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	var (
+		next    = entries(config)
+		removed = make(map[string]*tunnel)
+		reused  = make(map[string]*listener.SharedListener)
+		added   = make(map[string]*tunnel)
+	)
+	for k, t := range set.tunnels {
+		if _, exist := next[k]; !exist {
+			removed[k] = t
+			if t.shared != nil {
+				reused[t.listen] = t.shared
+			}
+		}
+	}
+	for k, t := range next {
+		if _, exist := set.tunnels[k]; !exist {
+			added[k] = t
+		}
+	}
+
+	// listen first, so a failure leaves everything as it was
+	for _, t := range added {
+		if t.listen == "" {
+			continue
+		}
+		if shared, exist := reused[t.listen]; exist {
+			t.shared = shared
+			continue
+		}
+
+		ln, err := net.Listen("tcp", t.listen)
+		if err != nil {
+			for _, t := range added {
+				if t.shared != nil && reused[t.listen] != t.shared {
+					t.shared.Close()
+				}
+				t.shared = nil
+			}
+			return errors.Annotate(err, t.desc)
+		}
+		t.shared = listener.Shared(ln)
+	}
+
+	taken := make(map[*listener.SharedListener]bool)
+	for _, t := range added {
+		if t.shared != nil {
+			taken[t.shared] = true
+		}
+	}
+	for k, t := range removed {
+		close(t.stop)
+		if t.shared != nil && !taken[t.shared] {
+			t.shared.Close()
+		}
+		delete(set.tunnels, k)
+		set.logf("remove %s", t.desc)
+	}
+	for k, t := range added {
+		t.stop = make(chan struct{})
+		set.tunnels[k] = t
+		set.logf("add %s", t.desc)
+		if set.client != nil {
+			go set.run(set.client, t)
+		}
+	}
+	return nil
+}
+
+// Serve opens every tunnel on c and keeps them open until c is gone.
+// Tunnels added meanwhile by Apply are opened on c too.
+func (set *Set) Serve(c *client.Client) error {
+	set.mu.Lock()
+	if set.client != nil {
+		set.mu.Unlock()
+		return errors.Trace(ErrServing)
+	}
+	set.client = c
+	for _, t := range set.tunnels {
+		go set.run(c, t)
+	}
+	set.mu.Unlock()
+
+	defer func() {
+		set.mu.Lock()
+		set.client = nil
+		set.mu.Unlock()
+	}()
+
+	<-c.Done()
+	return errors.Trace(c.Wait())
+}
+
+// run keeps the route of t open on c until t is removed or c is gone.
+func (set *Set) run(c *client.Client, t *tunnel) {
+	for {
+		var ln net.Listener
+		if t.shared != nil {
+			ln = t.shared.Listener()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-t.stop:
+			case <-c.Done():
+			case <-ctx.Done():
+			}
+			cancel()
+		}()
+		r, err := t.open(ctx, c, ln)
+		cancel()
+
+		if err == nil {
+			set.logf("setup %s", t.desc)
+			select {
+			case <-r.Done():
+				err = r.Wait()
+				if err == nil {
+					err = errors.New("route closed")
+				}
+			case <-t.stop:
+			case <-c.Done():
+			}
+			r.Close()
+		}
+		if ln != nil {
+			ln.Close()
+		}
+
+		select {
+		case <-t.stop:
+			return
+		case <-c.Done():
+			return
+		default:
+		}
+
+		set.logf("%s: %v, retry in %s", t.desc, err, RetryInterval)
+		select {
+		case <-time.After(RetryInterval):
+		case <-t.stop:
+			return
+		case <-c.Done():
+			return
+		}
+	}
+}
+
+// Close closes every tunnel and listener of set.
+func (set *Set) Close() error {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	for k, t := range set.tunnels {
+		close(t.stop)
+		if t.shared != nil {
+			t.shared.Close()
+		}
+		delete(set.tunnels, k)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package tunnels
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/service-exposer/exposer/client"
+	"github.com/service-exposer/exposer/server"
+)
+
+func echoServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func expectEcho(t *testing.T, addr string) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err = net.Dial("tcp", addr)
+		if err == nil {
+			conn.SetDeadline(time.Now().Add(time.Second))
+			conn.Write([]byte("hello"))
+			data := make([]byte, 5)
+			_, err = io.ReadFull(conn, data)
+			conn.Close()
+			if err == nil && string(data) == "hello" {
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("expect echo on", addr, "got", err)
+}
+
+func TestSet_Apply(t *testing.T) {
+	// the link may be opened before the expose it needs
+	RetryInterval = 100 * time.Millisecond
+
+	s, err := server.New(server.Options{
+		Key: "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	defer s.Shutdown(context.Background())
+
+	var (
+		echo   = echoServer(t)
+		listen = freeAddr(t)
+	)
+
+	set := NewSet()
+	defer set.Close()
+	err = set.Apply(&Config{
+		Exposes: []Expose{{Name: "echo", Addr: echo}},
+		Links:   []Link{{Name: "echo", Listen: listen}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.Dial(context.Background(), "ws://"+ln.Addr().String(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	go set.Serve(c)
+
+	expectEcho(t, listen)
+
+	set.mu.Lock()
+	exposeTunnel := set.tunnels[key("expose", Expose{Name: "echo", Addr: echo})]
+	set.mu.Unlock()
+
+	// the forward takes over the listener of the removed link
+	err = set.Apply(&Config{
+		Exposes:  []Expose{{Name: "echo", Addr: echo}},
+		Forwards: []Forward{{Addr: echo, Listen: listen}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set.mu.Lock()
+	if len(set.tunnels) != 2 {
+		t.Fatal("expect 2 tunnels got", len(set.tunnels))
+	}
+	if set.tunnels[key("expose", Expose{Name: "echo", Addr: echo})] != exposeTunnel {
+		t.Fatal("expect unchanged expose left alone")
+	}
+	if set.tunnels[key("forward", Forward{Addr: echo, Listen: listen})] == nil {
+		t.Fatal("expect forward added")
+	}
+	set.mu.Unlock()
+
+	expectEcho(t, listen)
+
+	// a listen failure leaves set unchanged
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	err = set.Apply(&Config{
+		Links: []Link{{Name: "echo", Listen: busy.Addr().String()}},
+	})
+	if err == nil {
+		t.Fatal("expect listen error")
+	}
+	set.mu.Lock()
+	if len(set.tunnels) != 2 {
+		t.Fatal("expect 2 tunnels got", len(set.tunnels))
+	}
+	set.mu.Unlock()
+}